@@ -1,22 +1,25 @@
 package main
 
 import (
-	"encoding/hex"
 	"flag"
 	"fmt"
 	"kvartalochain/common"
-	"net/http"
+	"kvartalochain/endpoint"
 	"os"
+
+	tmtypes "github.com/tendermint/tendermint/types"
 )
 
 var mint *bool
 var addrFlag *string
 var amountFlag *int
+var nodeFlag *string
 
 func main() {
 	mint = flag.Bool("mint", false, "Mint coints to address")
 	addrFlag = flag.String("addr", "DqF1B6iqaxeE3j4XvyPfLbba6QkQfQtwSUWBJmnQRMvN", "Address to add balance")
 	amountFlag = flag.Int("amount", 0, "Amount to be added")
+	nodeFlag = flag.String("node", "http://127.0.0.1:26657", "Tendermint RPC url of the node")
 	flag.Parse()
 
 	if *mint {
@@ -35,10 +38,13 @@ func main() {
 		tx.Type = common.TxTypeMint
 		sk0.SignTx(tx)
 
-		txHex := hex.EncodeToString(tx.Bytes())
-		var nodeurl = "http://127.0.0.1:26657"
-		fmt.Println("sending", nodeurl+`/broadcast_tx_commit?tx="`+txHex+`"`)
-		resp, err := http.Get(nodeurl + `/broadcast_tx_commit?tx="` + txHex + `"`)
+		client, err := endpoint.NewHTTPNodeClient(*nodeFlag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("sending", tx.Hex(), "to", *nodeFlag)
+		resp, err := client.BroadcastTxCommit(tmtypes.Tx(tx.Hex()))
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -1,35 +1,124 @@
 package chain
 
 import (
-	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"kvartalochain/common"
 	"kvartalochain/storage"
-
-	"github.com/dgraph-io/badger"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/log"
 )
 
 type KvartaloABCI struct {
 	archive      bool
-	db           *storage.Storage // used for state, balances and nonces
-	archiveDb    *badger.DB       // used for tx history archive
-	currentBatch *badger.Txn
+	archiving    bool              // whether the current block is stored in the archive
+	db           storage.StateDB   // used for state, balances and nonces
+	archiveDb    storage.ArchiveDB // used for tx history archive
+	currentBatch storage.ArchiveBatch
+	height       uint64    // height of the current block
+	committed    uint64    // height of the last committed block, accessed atomically
+	blockTime    time.Time // time of the current block
+	blockHash    []byte    // hash of the current block
+	parentHash   []byte    // hash of the previous block
+	txIndex      uint32    // index of the current tx in the block
+	blockTxs     []CommittedTx
+	listeners    []CommitListener
+	metrics      *Metrics
+	logger       log.Logger
+	blockStart   time.Time       // start of the processing of the current block
+	blockSupply  *storage.Supply // supply before the current block
+}
+
+// CommittedTx is a tx delivered in a committed block. The failed txs have
+// the Code and Log of the DeliverTx, and a nil Tx if they can not be decoded.
+type CommittedTx struct {
+	Height uint64
+	Index  uint32
+	Hash   []byte
+	Tx     *common.Tx
+	Code   uint32
+	Log    string
+}
+
+// Failed returns whether the tx was rejected by the DeliverTx
+func (c *CommittedTx) Failed() bool {
+	return c.Code != 0
+}
+
+// CommitListener is called after each Commit with the height and the txs of
+// the block, including the failed ones. It runs in the consensus routine, so
+// it must not block.
+type CommitListener func(height uint64, txs []CommittedTx)
+
+// GenesisState is the app state of the genesis file
+type GenesisState struct {
+	// AppHash makes the state hash the app hash of the blocks. The chains
+	// started before it keep empty app hashes.
+	AppHash bool `json:"app_hash"`
 }
 
 var _ abcitypes.Application = (*KvartaloABCI)(nil)
 
-func NewKvartaloApplication(db *storage.Storage, archiveDb *badger.DB) *KvartaloABCI {
+func NewKvartaloApplication(db storage.StateDB, archiveDb storage.ArchiveDB) *KvartaloABCI {
 	return &KvartaloABCI{
 		archive:   true,
 		db:        db,
 		archiveDb: archiveDb,
+		committed: storage.GetStateHeight(db),
+		metrics:   NopMetrics(),
+		logger:    log.NewNopLogger(),
 	}
 }
 
-func (KvartaloABCI) Info(req abcitypes.RequestInfo) abcitypes.ResponseInfo {
-	return abcitypes.ResponseInfo{}
+// SetLogger sets the logger of the app. It must be called before starting
+// the node.
+func (app *KvartaloABCI) SetLogger(logger log.Logger) {
+	app.logger = logger
+}
+
+// SetMetrics sets the Metrics of the app. It must be called before starting
+// the node.
+func (app *KvartaloABCI) SetMetrics(metrics *Metrics) {
+	app.metrics = metrics
+}
+
+// SetArchive enables or disables the tx history archive, enabled by
+// default. It must be called before starting the node.
+func (app *KvartaloABCI) SetArchive(archive bool) {
+	app.archive = archive
+}
+
+// Archive returns whether the tx history archive is enabled
+func (app *KvartaloABCI) Archive() bool {
+	return app.archive
+}
+
+// Height returns the height of the last block committed to the state. It
+// can be called from any goroutine.
+func (app *KvartaloABCI) Height() uint64 {
+	return atomic.LoadUint64(&app.committed)
+}
+
+// OnCommit adds a CommitListener. It must be called before starting the node.
+func (app *KvartaloABCI) OnCommit(listener CommitListener) {
+	app.listeners = append(app.listeners, listener)
+}
+
+// Info returns the last block committed to the state, so that Tendermint
+// only replays the later blocks when the node starts
+func (app *KvartaloABCI) Info(req abcitypes.RequestInfo) abcitypes.ResponseInfo {
+	height := storage.GetStateHeight(app.db)
+	if height == 0 {
+		return abcitypes.ResponseInfo{}
+	}
+	return abcitypes.ResponseInfo{
+		LastBlockHeight:  int64(height),
+		LastBlockAppHash: app.appHash(app.db.State()),
+	}
 }
 
 func (KvartaloABCI) SetOption(req abcitypes.RequestSetOption) abcitypes.ResponseSetOption {
@@ -37,46 +126,93 @@ func (KvartaloABCI) SetOption(req abcitypes.RequestSetOption) abcitypes.Response
 }
 
 func (app *KvartaloABCI) CheckTx(req abcitypes.RequestCheckTx) abcitypes.ResponseCheckTx {
-	txBytes, err := hex.DecodeString(string(req.Tx))
-	if err != nil {
-		return abcitypes.ResponseCheckTx{Code: ERRFORMAT} // invalid tx format
-	}
-	tx, err := common.TxFromBytes(txBytes)
-	if err != nil {
-		return abcitypes.ResponseCheckTx{Code: ERRFORMAT} // invalid tx format
+	tx, code := decodeTx(req.Tx)
+	if code == 0 {
+		code = app.isValid(tx)
 	}
-	code := app.isValid(tx)
+	app.metrics.CheckTxs.With("code", strconv.Itoa(int(code)), "type", txTypeLabel(tx)).Add(1)
 	if code != 0 {
-		fmt.Println("CheckTx not valid, code: ", code)
-		return abcitypes.ResponseCheckTx{Code: code}
+		app.logger.Debug("CheckTx not valid", "hash", txHashString(req.Tx), "code", code, "log", codeLog(code))
+		return abcitypes.ResponseCheckTx{Code: code, Log: codeLog(code)}
 	}
 	// return abcitypes.ResponseCheckTx{Code: code, GasWanted: 1}
 	return abcitypes.ResponseCheckTx{Code: code}
 }
 
 func (app *KvartaloABCI) DeliverTx(req abcitypes.RequestDeliverTx) abcitypes.ResponseDeliverTx {
-	code := app.performTx(req.Tx)
+	tx, code := decodeTx(req.Tx)
+	if code == 0 {
+		code = app.performTx(tx, req.Tx)
+	}
+	app.metrics.DeliverTxs.With("code", strconv.Itoa(int(code)), "type", txTypeLabel(tx)).Add(1)
+	committed := CommittedTx{
+		Height: app.height,
+		Index:  app.txIndex,
+		Hash:   storage.TxHash(req.Tx),
+		Tx:     tx,
+	}
+	app.txIndex++
 	if code != 0 {
 		// TODO if err, cancel tx, don't Commit()
-		return abcitypes.ResponseDeliverTx{Code: code}
+		app.logger.Info("DeliverTx failed", "height", app.height, "hash", txHashString(req.Tx),
+			"code", code, "log", codeLog(code))
+		committed.Code = code
+		committed.Log = codeLog(code)
+		app.blockTxs = append(app.blockTxs, committed)
+		return abcitypes.ResponseDeliverTx{Code: code, Log: codeLog(code)}
 	}
+	app.blockTxs = append(app.blockTxs, committed)
 
 	return abcitypes.ResponseDeliverTx{Code: code}
 }
 
 func (app *KvartaloABCI) Commit() abcitypes.ResponseCommit {
-	// TMP
-	// h, err := app.db.Commit() // store chain state
-	// if err != nil {
-	//         fmt.Println("ERR", err)
-	//         panic(err)
-	// }
-	app.currentBatch.Commit() // store archive history
-	// fmt.Println(h)
-	// return abcitypes.ResponseCommit{Data: h}
+	if app.archiving {
+		err := storage.StoreBlockHeader(app.currentBatch, &storage.BlockHeader{
+			Height:     app.height,
+			Hash:       app.blockHash,
+			ParentHash: app.parentHash,
+			Time:       app.blockTime,
+		})
+		if err != nil {
+			panic(err)
+		}
+		if err := storage.SetArchiveHeight(app.currentBatch, app.height); err != nil {
+			panic(err)
+		}
+	}
+	archiveStart := time.Now()
+	// the state must not be committed without the archive of the block, as
+	// it would not be archived again
+	if err := app.currentBatch.Commit(); err != nil { // store archive history
+		app.logger.Error("failed to store the archive of the block", "height", app.height, "err", err)
+		panic(err)
+	}
+	app.metrics.ArchiveWriteTime.Observe(time.Since(archiveStart).Seconds())
+	// the state is committed after the archive, so that a block is not
+	// archived twice if the node stops in between
+	storage.SetStateHeight(app.db, app.height)
+	stateHash, err := app.db.Commit() // store chain state
+	if err != nil {
+		panic(err)
+	}
+	atomic.StoreUint64(&app.committed, app.height)
+	for _, listener := range app.listeners {
+		listener(app.height, app.blockTxs)
+	}
+	app.logger.Debug("block committed", "height", app.height, "txs", len(app.blockTxs))
+	app.blockTxs = nil
+	app.observeBlock()
+	return abcitypes.ResponseCommit{Data: app.appHash(stateHash)}
+}
 
-	// return abcitypes.ResponseCommit{Data: app.db.State()}
-	return abcitypes.ResponseCommit{Data: []byte{}}
+// appHash returns the app hash of the state hash, which is empty for the
+// chains started without the app hash in the genesis
+func (app *KvartaloABCI) appHash(stateHash []byte) []byte {
+	if !storage.AppHashEnabled(app.db) {
+		return []byte{}
+	}
+	return stateHash
 }
 
 func (app *KvartaloABCI) Query(reqQuery abcitypes.RequestQuery) (resQuery abcitypes.ResponseQuery) {
@@ -108,14 +244,57 @@ func (app *KvartaloABCI) InitChain(req abcitypes.RequestInitChain) abcitypes.Res
 	// app.db.Set([]byte("init"), []byte("init"))
 	// app.db.Commit()
 
+	// the genesis files of the chains started with empty app hashes have no
+	// app state
+	if len(req.AppStateBytes) > 0 {
+		var genesis GenesisState
+		if err := json.Unmarshal(req.AppStateBytes, &genesis); err != nil {
+			panic(fmt.Errorf("invalid genesis app state: %w", err))
+		}
+		if genesis.AppHash {
+			storage.EnableAppHash(app.db)
+		}
+	}
 	return abcitypes.ResponseInitChain{}
 }
 
 func (app *KvartaloABCI) BeginBlock(req abcitypes.RequestBeginBlock) abcitypes.ResponseBeginBlock {
-	app.currentBatch = app.archiveDb.NewTransaction(true)
+	app.currentBatch = app.archiveDb.NewBatch()
+	app.height = uint64(req.Header.Height)
+	app.blockTime = req.Header.Time
+	app.blockHash = req.Hash
+	app.parentHash = req.Header.LastBlockId.Hash
+	app.txIndex = 0
+	app.blockStart = time.Now()
+	// the blocks already in the archive are replayed when the state is
+	// behind it, their txs and stats must not be stored again
+	archiveHeight, err := storage.GetArchiveHeight(app.archiveDb)
+	if err != nil {
+		panic(err)
+	}
+	app.archiving = app.archive && app.height > archiveHeight
+	app.blockSupply, _ = storage.GetSupply(app.db)
 	return abcitypes.ResponseBeginBlock{}
 }
 
 func (KvartaloABCI) EndBlock(req abcitypes.RequestEndBlock) abcitypes.ResponseEndBlock {
 	return abcitypes.ResponseEndBlock{}
 }
+
+// observeBlock records the metrics of the committed block
+func (app *KvartaloABCI) observeBlock() {
+	supply, err := storage.GetSupply(app.db)
+	if err == nil {
+		if app.blockSupply != nil {
+			app.metrics.MintedVolume.Add(float64(supply.Minted - app.blockSupply.Minted))
+			app.metrics.BurnedVolume.Add(float64(supply.Burned - app.blockSupply.Burned))
+		}
+		app.metrics.Accounts.Set(float64(supply.Accounts))
+	}
+	if sizer, ok := app.archiveDb.(archiveSizer); ok {
+		lsm, vlog := sizer.Size()
+		app.metrics.ArchiveSize.With("kind", "lsm").Set(float64(lsm))
+		app.metrics.ArchiveSize.With("kind", "vlog").Set(float64(vlog))
+	}
+	app.metrics.BlockProcessingTime.Observe(time.Since(app.blockStart).Seconds())
+}
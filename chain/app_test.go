@@ -1,25 +1,33 @@
 package chain
 
 import (
-	"encoding/binary"
+	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"kvartalochain/common"
 	"kvartalochain/storage"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dgraph-io/badger"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	abcitypes "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/libs/log"
 )
 
-func setDbBalance(db *storage.Storage, addr common.Address, balance uint64) {
-	var balanceBytes [8]byte
-	binary.LittleEndian.PutUint64(balanceBytes[:], balance)
-	db.Set(addr[:], balanceBytes[:])
+func setDbBalance(db storage.StateDB, addr common.Address, balance uint64) {
+	acc, err := storage.GetAccount(db, addr)
+	if err != nil {
+		panic(err)
+	}
+	acc.Balance = balance
+	storage.SetAccount(db, addr, acc)
 }
 func simulateTx(kApp *KvartaloABCI, sk *common.PrivateKey, from, to common.Address, amount, nonce uint64) (uint32, error) {
 	// create and sign tx
@@ -33,7 +41,7 @@ func simulateTx(kApp *KvartaloABCI, sk *common.PrivateKey, from, to common.Addre
 	fmt.Println("sender:", from.String())
 
 	// DeliverTx
-	_ = kApp.BeginBlock(abcitypes.RequestBeginBlock{})
+	_ = kApp.BeginBlock(abcitypes.RequestBeginBlock{Header: abcitypes.Header{Height: int64(kApp.Height()) + 1}})
 	req := abcitypes.RequestDeliverTx{
 		Tx: []byte(txHex),
 	}
@@ -44,7 +52,8 @@ func simulateTx(kApp *KvartaloABCI, sk *common.PrivateKey, from, to common.Addre
 func printBalances(t *testing.T, kApp *KvartaloABCI, addrs ...common.Address) {
 	fmt.Println("balances:")
 	for _, addr := range addrs {
-		balance := storage.GetBalance(kApp.db, addr)
+		balance, err := storage.GetBalance(kApp.db, addr)
+		require.Nil(t, err)
 		fmt.Println("	addr:", addr, " balance:", balance)
 	}
 }
@@ -57,10 +66,22 @@ func TestKvartaloApplication(t *testing.T) {
 	db, err := storage.NewStorage(tmpDir)
 	assert.Nil(t, err)
 
-	archiveDb, err := badger.Open(badger.DefaultOptions(tmpDir).WithLogger(nil))
+	badgerDb, err := badger.Open(badger.DefaultOptions(tmpDir).WithLogger(nil))
 	require.Nil(t, err)
+	archiveDb := storage.NewBadgerArchive(badgerDb)
 	defer archiveDb.Close()
 
+	testKvartaloApplication(t, db, archiveDb)
+}
+
+func TestKvartaloApplicationMem(t *testing.T) {
+	db, err := storage.NewMemStorage()
+	require.Nil(t, err)
+
+	testKvartaloApplication(t, db, storage.NewMemArchive())
+}
+
+func testKvartaloApplication(t *testing.T, db storage.StateDB, archiveDb storage.ArchiveDB) {
 	// initialize keys
 	a := "2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG"
 	b := "8h3u7NfgvUJsHJgKDUKwwVL1iZd3cwRtntpTfJ5Mefz2"
@@ -79,18 +100,23 @@ func TestKvartaloApplication(t *testing.T) {
 
 	kApp := NewKvartaloApplication(db, archiveDb)
 	printBalances(t, kApp, addr0, addr1)
+	var committed []CommittedTx
+	kApp.OnCommit(func(height uint64, txs []CommittedTx) {
+		committed = txs
+	})
 
 	// get balance
-	balance := storage.GetBalance(kApp.db, addr0)
+	balance, err := storage.GetBalance(kApp.db, addr0)
+	assert.Nil(t, err)
 	assert.Equal(t, uint64(10), balance)
 
 	// addr0 send to addr1
 	code, err := simulateTx(kApp, sk0, addr0, addr1, 10, 0)
 	assert.Nil(t, err)
 	assert.Equal(t, uint32(0), code)
-	balance = storage.GetBalance(kApp.db, addr0)
+	balance, _ = storage.GetBalance(kApp.db, addr0)
 	assert.Equal(t, uint64(0), balance)
-	balance = storage.GetBalance(kApp.db, addr1)
+	balance, _ = storage.GetBalance(kApp.db, addr1)
 	assert.Equal(t, uint64(20), balance)
 	printBalances(t, kApp, addr0, addr1)
 
@@ -98,6 +124,11 @@ func TestKvartaloApplication(t *testing.T) {
 	code, err = simulateTx(kApp, sk0, addr0, addr1, 10, 1)
 	assert.Nil(t, err)
 	assert.Equal(t, ERRNOFUNDS, code) // expect not enough funds
+	// the listeners get the failed txs
+	require.Equal(t, 1, len(committed))
+	assert.True(t, committed[0].Failed())
+	assert.Equal(t, ERRNOFUNDS, committed[0].Code)
+	assert.Equal(t, codeLog(ERRNOFUNDS), committed[0].Log)
 
 	// addr1 send to addr0
 	code, err = simulateTx(kApp, sk1, addr1, addr0, 10, 0)
@@ -114,8 +145,157 @@ func TestKvartaloApplication(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, uint32(0), code)
 	printBalances(t, kApp, addr0, addr1)
-	balance = storage.GetBalance(kApp.db, addr0)
+	balance, _ = storage.GetBalance(kApp.db, addr0)
 	assert.Equal(t, uint64(0), balance)
-	balance = storage.GetBalance(kApp.db, addr1)
+	balance, _ = storage.GetBalance(kApp.db, addr1)
 	assert.Equal(t, uint64(20), balance)
 }
+
+func TestMetrics(t *testing.T) {
+	db, err := storage.NewMemStorage()
+	require.Nil(t, err)
+	kApp := NewKvartaloApplication(db, storage.NewMemArchive())
+	reg := prometheus.NewRegistry()
+	kApp.SetMetrics(PrometheusMetrics(reg, "test"))
+
+	sk := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr := sk.Public().Address()
+	mint := &common.Tx{Type: common.TxTypeMint, From: addr, To: addr, Amount: 10}
+	require.Nil(t, sk.SignTx(mint))
+	transfer := common.NewTx(addr, addr, 20, 1)
+	require.Nil(t, sk.SignTx(transfer))
+
+	assert.Equal(t, uint32(0), kApp.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(mint.Hex())}).Code)
+	assert.Equal(t, ERRNOFUNDS, kApp.CheckTx(abcitypes.RequestCheckTx{Tx: []byte(transfer.Hex())}).Code)
+	assert.Equal(t, ERRFORMAT, kApp.CheckTx(abcitypes.RequestCheckTx{Tx: []byte("zz")}).Code)
+
+	kApp.BeginBlock(abcitypes.RequestBeginBlock{Header: abcitypes.Header{Height: 1}})
+	assert.Equal(t, uint32(0), kApp.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte(mint.Hex())}).Code)
+	assert.Equal(t, ERRNONCE, kApp.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte(mint.Hex())}).Code)
+	kApp.Commit()
+
+	families, err := reg.Gather()
+	require.Nil(t, err)
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			name := family.GetName()
+			for _, label := range metric.Label {
+				name += "," + label.GetName() + "=" + label.GetValue()
+			}
+			switch {
+			case metric.Counter != nil:
+				values[name] = metric.Counter.GetValue()
+			case metric.Gauge != nil:
+				values[name] = metric.Gauge.GetValue()
+			case metric.Histogram != nil:
+				values[name] = float64(metric.Histogram.GetSampleCount())
+			}
+		}
+	}
+	assert.Equal(t, map[string]float64{
+		"test_app_check_txs_total,code=0,type=mint":     1,
+		"test_app_check_txs_total,code=4,type=transfer": 1,
+		"test_app_check_txs_total,code=1,type=unknown":  1,
+		"test_app_deliver_txs_total,code=0,type=mint":   1,
+		"test_app_deliver_txs_total,code=3,type=mint":   1,
+		"test_app_minted_volume_total":                  10,
+		"test_app_burned_volume_total":                  0,
+		"test_app_accounts":                             1,
+		"test_app_block_processing_seconds":             1,
+		"test_app_archive_write_seconds":                1,
+	}, values)
+}
+
+func TestLogger(t *testing.T) {
+	db, err := storage.NewMemStorage()
+	require.Nil(t, err)
+	kApp := NewKvartaloApplication(db, storage.NewMemArchive())
+	var out bytes.Buffer
+	kApp.SetLogger(log.NewTMJSONLogger(&out).With("module", "app"))
+
+	sk := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr := sk.Public().Address()
+	tx := common.NewTx(addr, addr, 20, 0)
+	require.Nil(t, sk.SignTx(tx))
+	kApp.BeginBlock(abcitypes.RequestBeginBlock{Header: abcitypes.Header{Height: 3}})
+	assert.Equal(t, ERRNOFUNDS, kApp.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte(tx.Hex())}).Code)
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry map[string]interface{}
+		require.Nil(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	require.Equal(t, 2, len(entries))
+	assert.Equal(t, "not enough funds", entries[0]["_msg"])
+	assert.Equal(t, "DeliverTx failed", entries[1]["_msg"])
+	assert.Equal(t, "app", entries[1]["module"])
+	assert.Equal(t, float64(3), entries[1]["height"])
+	assert.Equal(t, txHashString([]byte(tx.Hex())), entries[1]["hash"])
+	assert.Equal(t, float64(ERRNOFUNDS), entries[1]["code"])
+}
+
+func TestReplay(t *testing.T) {
+	db, err := storage.NewMemStorage()
+	require.Nil(t, err)
+	archiveDb := storage.NewMemArchive()
+	kApp := NewKvartaloApplication(db, archiveDb)
+	genesis := abcitypes.RequestInitChain{AppStateBytes: []byte(`{"app_hash":true}`)}
+	kApp.InitChain(genesis)
+
+	sk := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr := sk.Public().Address()
+	mint := &common.Tx{Type: common.TxTypeMint, From: addr, To: addr, Amount: 10}
+	require.Nil(t, sk.SignTx(mint))
+	transfer := common.NewTx(addr, addr, 3, 1)
+	require.Nil(t, sk.SignTx(transfer))
+	blockTime := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	deliverBlock := func(kApp *KvartaloABCI) abcitypes.ResponseCommit {
+		kApp.BeginBlock(abcitypes.RequestBeginBlock{Header: abcitypes.Header{Height: 1, Time: blockTime}})
+		for _, tx := range []*common.Tx{mint, transfer} {
+			require.Equal(t, uint32(0), kApp.DeliverTx(abcitypes.RequestDeliverTx{Tx: []byte(tx.Hex())}).Code)
+		}
+		return kApp.Commit()
+	}
+
+	commit := deliverBlock(kApp)
+	assert.NotEmpty(t, commit.Data)
+	info := kApp.Info(abcitypes.RequestInfo{})
+	assert.Equal(t, int64(1), info.LastBlockHeight)
+	assert.Equal(t, commit.Data, info.LastBlockAppHash)
+	stats, err := storage.GetStats(archiveDb, storage.IntervalDay, blockTime, blockTime)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), stats[0].Transfers)
+	count, err := storage.GetTxCount(archiveDb, addr)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	// a state behind the archive replays the block, which is not archived
+	// again
+	db, err = storage.NewMemStorage()
+	require.Nil(t, err)
+	kApp = NewKvartaloApplication(db, archiveDb)
+	assert.Equal(t, int64(0), kApp.Info(abcitypes.RequestInfo{}).LastBlockHeight)
+	kApp.InitChain(genesis)
+	assert.Equal(t, commit.Data, deliverBlock(kApp).Data)
+	replayed, err := storage.GetStats(archiveDb, storage.IntervalDay, blockTime, blockTime)
+	require.Nil(t, err)
+	assert.Equal(t, stats, replayed)
+	count, err = storage.GetTxCount(archiveDb, addr)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), count)
+	balance, err := storage.GetBalance(db, addr)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(10), balance)
+
+	// the chains without the app hash in the genesis keep empty app hashes
+	db, err = storage.NewMemStorage()
+	require.Nil(t, err)
+	kApp = NewKvartaloApplication(db, storage.NewMemArchive())
+	kApp.InitChain(abcitypes.RequestInitChain{})
+	assert.Empty(t, deliverBlock(kApp).Data)
+	info = kApp.Info(abcitypes.RequestInfo{})
+	assert.Equal(t, int64(1), info.LastBlockHeight)
+	assert.Empty(t, info.LastBlockAppHash)
+}
@@ -0,0 +1,111 @@
+package chain
+
+import (
+	"kvartalochain/common"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	kitprom "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsSubsystem is the subsystem of the app metrics, under the
+// Tendermint instrumentation namespace
+const MetricsSubsystem = "app"
+
+// Metrics of the KvartaloABCI
+type Metrics struct {
+	// CheckTxs and DeliverTxs count the txs by result code and tx type
+	CheckTxs   metrics.Counter
+	DeliverTxs metrics.Counter
+	// BlockProcessingTime is the time from BeginBlock to the end of Commit
+	BlockProcessingTime metrics.Histogram
+	MintedVolume        metrics.Counter
+	BurnedVolume        metrics.Counter
+	Accounts            metrics.Gauge
+	// ArchiveWriteTime is the time to store the archive batch of a block
+	ArchiveWriteTime metrics.Histogram
+	// ArchiveSize is the size of the badger archive by kind, lsm or vlog
+	ArchiveSize metrics.Gauge
+}
+
+// PrometheusMetrics returns the Metrics registered in reg
+func PrometheusMetrics(reg stdprometheus.Registerer, namespace string) *Metrics {
+	counter := func(name, help string, labels ...string) metrics.Counter {
+		cv := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      name,
+			Help:      help,
+		}, labels)
+		reg.MustRegister(cv)
+		return kitprom.NewCounter(cv)
+	}
+	gauge := func(name, help string, labels ...string) metrics.Gauge {
+		gv := stdprometheus.NewGaugeVec(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      name,
+			Help:      help,
+		}, labels)
+		reg.MustRegister(gv)
+		return kitprom.NewGauge(gv)
+	}
+	histogram := func(name, help string, buckets []float64) metrics.Histogram {
+		hv := stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      name,
+			Help:      help,
+			Buckets:   buckets,
+		}, nil)
+		reg.MustRegister(hv)
+		return kitprom.NewHistogram(hv)
+	}
+	return &Metrics{
+		CheckTxs:            counter("check_txs_total", "Number of CheckTx calls.", "code", "type"),
+		DeliverTxs:          counter("deliver_txs_total", "Number of DeliverTx calls.", "code", "type"),
+		BlockProcessingTime: histogram("block_processing_seconds", "Time to process a block, from BeginBlock to the end of Commit.", stdprometheus.ExponentialBuckets(0.001, 2, 14)),
+		MintedVolume:        counter("minted_volume_total", "Amount minted."),
+		BurnedVolume:        counter("burned_volume_total", "Amount burned."),
+		Accounts:            gauge("accounts", "Number of accounts."),
+		ArchiveWriteTime:    histogram("archive_write_seconds", "Time to store the archive batch of a block.", stdprometheus.ExponentialBuckets(0.0005, 2, 14)),
+		ArchiveSize:         gauge("archive_size_bytes", "Size of the archive database.", "kind"),
+	}
+}
+
+// NopMetrics returns Metrics that discard the values
+func NopMetrics() *Metrics {
+	return &Metrics{
+		CheckTxs:            discard.NewCounter(),
+		DeliverTxs:          discard.NewCounter(),
+		BlockProcessingTime: discard.NewHistogram(),
+		MintedVolume:        discard.NewCounter(),
+		BurnedVolume:        discard.NewCounter(),
+		Accounts:            discard.NewGauge(),
+		ArchiveWriteTime:    discard.NewHistogram(),
+		ArchiveSize:         discard.NewGauge(),
+	}
+}
+
+// txTypeLabel returns the tx type label of the tx metrics, unknown when the
+// tx can not be decoded
+func txTypeLabel(tx *common.Tx) string {
+	if tx == nil {
+		return "unknown"
+	}
+	switch tx.Type {
+	case common.TxTypeNormal:
+		return "transfer"
+	case common.TxTypeMint:
+		return "mint"
+	default:
+		return "unknown"
+	}
+}
+
+// archiveSizer is implemented by the archives that report their size, as
+// the BadgerArchive
+type archiveSizer interface {
+	Size() (lsm, vlog int64)
+}
@@ -1,11 +1,12 @@
 package chain
 
 import (
-	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"kvartalochain/common"
 	"kvartalochain/storage"
+
+	"github.com/tendermint/tendermint/libs/log"
 )
 
 const ERRFORMAT = uint32(1)
@@ -14,6 +15,26 @@ const ERRNONCE = uint32(3)
 const ERRNOFUNDS = uint32(4)
 const ERRSIG = uint32(5)
 
+// codeLog returns the description of a CheckTx or DeliverTx result code
+func codeLog(code uint32) string {
+	switch code {
+	case 0:
+		return ""
+	case ERRFORMAT:
+		return "invalid tx format"
+	case ERRDB:
+		return "database error"
+	case ERRNONCE:
+		return "invalid nonce"
+	case ERRNOFUNDS:
+		return "not enough funds"
+	case ERRSIG:
+		return "invalid signature"
+	default:
+		return "unknown error"
+	}
+}
+
 func (app *KvartaloABCI) isValid(tx *common.Tx) (code uint32) {
 	// check signature
 	if !common.VerifySignatureTx(tx) {
@@ -22,9 +43,13 @@ func (app *KvartaloABCI) isValid(tx *common.Tx) (code uint32) {
 
 	switch tx.Type {
 	case common.TxTypeNormal:
-		senderBalance := storage.GetBalance(app.db, tx.From)
+		senderBalance, err := storage.GetBalance(app.db, tx.From)
+		if err != nil {
+			return ERRDB
+		}
 		if senderBalance < tx.Amount {
-			fmt.Println("[not enough funds] sender:", tx.From, "\nsenderBalance:", senderBalance, ", tx.Amount:", tx.Amount)
+			app.logger.Debug("not enough funds", "height", app.height, "sender", tx.From.String(),
+				"balance", senderBalance, "amount", tx.Amount)
 			return ERRNOFUNDS // not enough funds
 		}
 		break
@@ -41,91 +66,78 @@ func (app *KvartaloABCI) isValid(tx *common.Tx) (code uint32) {
 	return code
 }
 
-func (app KvartaloABCI) performTx(txRaw []byte) uint32 {
+// txHashString returns the hash of a Tendermint tx, as shown by the api
+func txHashString(txRaw []byte) string {
+	return fmt.Sprintf("%X", storage.TxHash(txRaw))
+}
+
+// decodeTx decodes the hex of the tx bytes of a Tendermint tx
+func decodeTx(txRaw []byte) (*common.Tx, uint32) {
 	txBytes, err := hex.DecodeString(string(txRaw))
 	if err != nil {
-		return ERRFORMAT // invalid tx format
+		return nil, ERRFORMAT // invalid tx format
 	}
 	tx, err := common.TxFromBytes(txBytes)
 	if err != nil {
-		return ERRFORMAT // invalid tx format
+		return nil, ERRFORMAT // invalid tx format
 	}
+	return tx, 0
+}
 
+func (app KvartaloABCI) performTx(tx *common.Tx, txRaw []byte) uint32 {
 	code := app.isValid(tx) // already checked in CheckTx()
 	if code != 0 {
 		return code
 	}
 
-	dbNonce := storage.GetNonce(app.db, tx.From)
-	if dbNonce != tx.Nonce {
+	sender, err := storage.GetAccount(app.db, tx.From)
+	if err != nil {
+		return ERRDB
+	}
+	if sender.Nonce != tx.Nonce {
 		return ERRNONCE
 	}
 
-	senderBalance := storage.GetBalance(app.db, tx.From)
-	receiverBalance := storage.GetBalance(app.db, tx.To)
+	supply, err := storage.GetSupply(app.db)
+	if err != nil {
+		return ERRDB
+	}
 
 	// TODO add checks
 
-	var newSenderBalance uint64
-	if tx.Type != common.TxTypeMint {
-		newSenderBalance = senderBalance - tx.Amount
+	if tx.Type == common.TxTypeMint {
+		supply.Minted += tx.Amount
+	} else {
+		sender.Balance = sender.Balance - tx.Amount
 	}
-	newReceiverBalance := receiverBalance + tx.Amount
-
-	var newSenderBalanceBytes [8]byte
-	binary.LittleEndian.PutUint64(newSenderBalanceBytes[:], newSenderBalance)
-	app.db.Set(tx.From[:], newSenderBalanceBytes[:])
-	var newReceiverBalanceBytes [8]byte
-	binary.LittleEndian.PutUint64(newReceiverBalanceBytes[:], newReceiverBalance)
-	app.db.Set(tx.To[:], newReceiverBalanceBytes[:])
+	sender.Nonce++
+	newAccounts := uint64(0)
+	if !storage.AccountExists(app.db, tx.From) {
+		newAccounts++
+	}
+	storage.SetAccount(app.db, tx.From, sender)
 
-	var newNonce [8]byte
-	binary.LittleEndian.PutUint64(newNonce[:], dbNonce+1)
-	app.db.Set(append(storage.PREFIXNONCE, tx.From[:]...), newNonce[:])
+	// the receiver is read after storing the sender, as both can be the
+	// same address
+	receiver, err := storage.GetAccount(app.db, tx.To)
+	if err != nil {
+		return ERRDB
+	}
+	receiver.Balance = receiver.Balance + tx.Amount
+	if !storage.AccountExists(app.db, tx.To) {
+		newAccounts++
+	}
+	supply.Accounts += newAccounts
+	storage.SetAccount(app.db, tx.To, receiver)
+	storage.SetSupply(app.db, supply)
 
 	// if node is in 'archive' mode, store history of tx
-	if app.archive {
-		// format in DB:
-		// 	key: PREFIXHISTORY | address | count
-		// 	value: tx.Bytes()
-
-		// store tx for sender
-		txFromCount, err := storage.GetTxCount(app.archiveDb, tx.From)
+	if app.archiving {
+		err = storage.StoreTx(app.currentBatch, app.height, app.txIndex, txRaw, tx)
 		if err != nil {
 			return ERRDB
 		}
-		key := append(storage.PREFIXHISTORY, tx.From[:]...)
-		var txFromCountBytes [8]byte
-		binary.LittleEndian.PutUint64(txFromCountBytes[:], txFromCount)
-		key = append(key, txFromCountBytes[:]...)
-		err = app.currentBatch.Set(key, tx.Bytes())
-		if err != nil {
-			return ERRDB
-		}
-		txFromCountKey := append(storage.PREFIXHISTORY, tx.From[:]...)
-		var txFromCountBytesNew [8]byte
-		binary.LittleEndian.PutUint64(txFromCountBytesNew[:], txFromCount+1)
-		err = app.currentBatch.Set(txFromCountKey, txFromCountBytesNew[:])
-		if err != nil {
-			return ERRDB
-		}
-		// store tx for receiver
-		txToCount, err := storage.GetTxCount(app.archiveDb, tx.To)
-		if err != nil {
-			return ERRDB
-		}
-		key = append(storage.PREFIXHISTORY, tx.To[:]...)
-		var txToCountBytes [8]byte
-		binary.LittleEndian.PutUint64(txToCountBytes[:], txToCount)
-		key = append(key, txToCountBytes[:]...)
-		err = app.currentBatch.Set(key, tx.Bytes())
-		if err != nil {
-			return ERRDB
-		}
-		txToCountKey := append(storage.PREFIXHISTORY, tx.To[:]...)
-		var txToCountBytesNew [8]byte
-		binary.LittleEndian.PutUint64(txToCountBytesNew[:], txToCount+1)
-		err = app.currentBatch.Set(txToCountKey, txToCountBytesNew[:])
+		err = storage.StoreStats(app.currentBatch, app.blockTime, tx, newAccounts, supply.Circulating())
 		if err != nil {
 			return ERRDB
 		}
@@ -135,3 +147,44 @@ func (app KvartaloABCI) performTx(txRaw []byte) uint32 {
 	// fmt.Println("addr:", tx.To.String(), " balance: ", newReceiverBalance)
 	return 0
 }
+
+// Simulation is the result of a tx run against a branch of the state
+type Simulation struct {
+	Code uint32
+	Log  string
+	Tx   *common.Tx
+	// Balances are the balances of the sender and the receiver after the
+	// tx, when it is valid
+	Balances map[common.Address]uint64
+}
+
+// SimulateTx runs the tx as DeliverTx would, against a Branch of the last
+// committed state of db that is discarded afterwards, so neither db nor the
+// archive are modified. The committed state is not changed by the block
+// being delivered, so it can be called from any goroutine.
+func SimulateTx(db storage.StateDB, txRaw []byte) (*Simulation, error) {
+	committed, err := db.Committed()
+	if err != nil {
+		return nil, err
+	}
+	branch := storage.NewBranch(committed)
+	app := KvartaloABCI{db: branch, logger: log.NewNopLogger()}
+	tx, code := decodeTx(txRaw)
+	if code == 0 {
+		code = app.performTx(tx, txRaw)
+	}
+	sim := &Simulation{Code: code, Log: codeLog(code)}
+	if code != 0 {
+		return sim, nil
+	}
+	sim.Tx = tx
+	sim.Balances = make(map[common.Address]uint64)
+	for _, addr := range []common.Address{tx.From, tx.To} {
+		balance, err := storage.GetBalance(branch, addr)
+		if err != nil {
+			return nil, err
+		}
+		sim.Balances[addr] = balance
+	}
+	return sim, nil
+}
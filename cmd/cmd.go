@@ -1,24 +1,32 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
+	"kvartalochain/chain"
 	"kvartalochain/endpoint"
+	"kvartalochain/storage"
+	"kvartalochain/webhook"
 
+	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
-	// log "github.com/sirupsen/logrus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/viper"
 	cfg "github.com/tendermint/tendermint/config"
-	log "github.com/tendermint/tendermint/libs/log"
 	"github.com/urfave/cli"
+	"google.golang.org/grpc"
 )
 
 var config = cfg.DefaultConfig()
-var logger = log.NewTMLogger(log.NewSyncWriter(os.Stdout))
 
 var ServerCommands = []cli.Command{
 	{
@@ -38,29 +46,85 @@ var ServerCommands = []cli.Command{
 		Aliases: []string{},
 		Usage:   "start the server",
 		Action:  cmdStart,
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "api-addr",
+				Usage: "address of the api, overrides api_addr of the app config",
+			},
+			cli.StringFlag{
+				Name:  "rpc-url",
+				Usage: "Tendermint RPC url used by the api, in the form http://127.0.0.1:26657, overrides rpc_url of the app config. By default the api calls the node in process",
+			},
+			cli.IntFlag{
+				Name:  "rebroadcast-limit",
+				Value: 3,
+				Usage: "number of times a submitted tx that drops out of the mempool is broadcasted again",
+			},
+			cli.BoolFlag{
+				Name:  "webhooks-allow-private",
+				Usage: "allow webhooks to private, loopback and link-local addresses",
+			},
+			cli.StringFlag{
+				Name:  "grpc-addr",
+				Usage: "address of the gRPC api, empty to disable it, overrides grpc_addr of the app config",
+			},
+			cli.StringFlag{
+				Name:  "rosetta-addr",
+				Usage: "address of the Rosetta api, in the form :8080, overrides rosetta_addr of the app config. Disabled by default",
+			},
+		}, append(storeFlags, apiFlags...)...),
+	},
+	{
+		Name:    "migrate",
+		Aliases: []string{},
+		Usage:   "upgrade the state and archive databases to the current schema version",
+		Action:  cmdMigrate,
+		Flags: append([]cli.Flag{
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "apply and verify the migrations without storing them",
+			},
+		}, storeFlags...),
+	},
+	{
+		Name:    "reindex",
+		Aliases: []string{},
+		Usage:   "rebuild the tx history archive from the Tendermint block store",
+		Action:  cmdReindex,
+		Flags:   storeFlags,
 	},
 	{
 		Name:    "info",
 		Aliases: []string{},
-		Usage:   "get info about the server",
+		Usage:   "get the status of a running node from its api",
 		Action:  cmdInfo,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "api-url",
+				Usage: "url of the api of the node, by default the one of api_addr of the app config",
+			},
+			cli.StringFlag{
+				Name:  "api-key",
+				Usage: "api key with the read scope, if the api requires it",
+			},
+		},
 	},
 }
 
 func cmdInitNode(c *cli.Context) error {
-	err := initNode(config)
+	err := initNode(config, homeDir(c), configFile(c))
 	return err
 }
 func cmdInitChain(c *cli.Context) error {
-	err := initGenesis(config)
+	err := initGenesis(config, homeDir(c), configFile(c))
 	return err
 }
 
-func cmdStart(c *cli.Context) error {
-	configFile := "tmp/config/config.toml"
+func loadConfig(c *cli.Context) error {
+	configFile := configFile(c)
 
 	// read config
-	config.RootDir = filepath.Dir(filepath.Dir(configFile))
+	config.RootDir = homeDir(c)
 	viper.SetConfigFile(configFile)
 	if err := viper.ReadInConfig(); err != nil {
 		return errors.Wrap(err, "viper failed to read config file")
@@ -68,35 +132,238 @@ func cmdStart(c *cli.Context) error {
 	if err := viper.Unmarshal(config); err != nil {
 		return errors.Wrap(err, "viper failed to unmarshal config")
 	}
+	// the paths of the p2p, mempool and consensus sections are relative to
+	// their own root
+	config.SetRoot(homeDir(c))
 	if err := config.ValidateBasic(); err != nil {
 		return errors.Wrap(err, "config is invalid")
 	}
+	return setupLogger(config)
+}
+
+// loadAppConfig returns the app config of the home directory, overridden by
+// the start flags that are set
+func loadAppConfig(c *cli.Context) (*AppConfig, error) {
+	appConfig, err := LoadAppConfig(homeDir(c))
+	if err != nil {
+		return nil, err
+	}
+	if c.IsSet("api-addr") {
+		appConfig.APIAddr = c.String("api-addr")
+	}
+	if c.IsSet("grpc-addr") {
+		appConfig.GRPCAddr = c.String("grpc-addr")
+	}
+	if c.IsSet("rosetta-addr") {
+		appConfig.RosettaAddr = c.String("rosetta-addr")
+	}
+	if c.IsSet("rpc-url") {
+		appConfig.RPCURL = c.String("rpc-url")
+	}
+	if c.IsSet("cors-origins") {
+		appConfig.CORSOrigins = nil
+		for _, origin := range strings.Split(c.String("cors-origins"), ",") {
+			appConfig.CORSOrigins = append(appConfig.CORSOrigins, strings.TrimSpace(origin))
+		}
+	}
+	return appConfig, appConfig.ValidateBasic()
+}
 
-	node, db, archiveDb := loadTendermint(config.DBPath)
+func cmdStart(c *cli.Context) error {
+	if err := loadConfig(c); err != nil {
+		return err
+	}
+	appConfig, err := loadAppConfig(c)
+	if err != nil {
+		return err
+	}
 
+	stateDir, archiveDir, err := storeDirs(c)
+	if err != nil {
+		return err
+	}
+	node, app, db, archiveDb := loadTendermint(stateDir, archiveDir)
+	// closed after the node and the services that write to the archive stop
+	defer archiveDb.Close()
+	app.SetLogger(rootLogger.With("module", "app"))
+	app.SetArchive(appConfig.Archive)
+	notifier := webhook.NewNotifier(archiveDb)
+	notifier.Logger = rootLogger.With("module", "webhook")
+	notifier.AllowPrivate = c.Bool("webhooks-allow-private")
+	app.OnCommit(endpoint.PublishCommit)
+	app.OnCommit(notifier.OnCommit)
+
+	var nodeClient endpoint.NodeClient
+	if rpcURL := appConfig.RPCURL; rpcURL != "" {
+		nodeClient, err = endpoint.NewHTTPNodeClient(rpcURL)
+		if err != nil {
+			return err
+		}
+	} else {
+		nodeClient = endpoint.NewLocalNodeClient(node)
+	}
+	tracker, err := endpoint.NewTracker(archiveDb, nodeClient)
+	if err != nil {
+		return err
+	}
+	tracker.MaxRebroadcasts = c.Int("rebroadcast-limit")
+	tracker.MaxTxs = appConfig.Pruning.TrackedTxs
+	tracker.Logger = rootLogger.With("module", "tracker")
+	app.OnCommit(tracker.OnCommit)
+	apiConfig, err := loadApiConfig(c)
+	if err != nil {
+		return err
+	}
+	apiConfig.CORSOrigins = appConfig.CORSOrigins
+	apiConfig.Logger = rootLogger.With("module", "api")
+	// the metrics are served by the Tendermint instrumentation listener
+	if config.Instrumentation.Prometheus {
+		namespace := config.Instrumentation.Namespace
+		app.SetMetrics(chain.PrometheusMetrics(stdprometheus.DefaultRegisterer, namespace))
+		apiConfig.Metrics = endpoint.PrometheusMetrics(stdprometheus.DefaultRegisterer, namespace)
+	}
+	endpoint.NodeVersion = c.App.Version
+	gin.SetMode(gin.ReleaseMode)
+	apiservice := endpoint.Serve(app, db, archiveDb, nodeClient, notifier, tracker, apiConfig)
 	go func() {
-		apiservice := endpoint.Serve(db, archiveDb)
-		apiservice.Run(":" + "3000")
-		logger.Info("api server running at :" + "3000")
+		logger.Info("api server running at " + appConfig.APIAddr)
+		if err := apiservice.Run(appConfig.APIAddr); err != nil {
+			logger.Error("api server stopped", "err", err)
+		}
 	}()
+	var grpcServer *grpc.Server
+	if grpcAddr := appConfig.GRPCAddr; grpcAddr != "" {
+		lis, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			return errors.Wrap(err, "failed to listen for the grpc api")
+		}
+		grpcServer = endpoint.ServeGRPC(apiConfig)
+		go func() {
+			logger.Info("grpc api server running at " + grpcAddr)
+			if err := grpcServer.Serve(lis); err != nil {
+				logger.Error("grpc api server stopped", "err", err)
+			}
+		}()
+	}
+	if rosettaAddr := appConfig.RosettaAddr; rosettaAddr != "" {
+		rosetta := endpoint.ServeRosetta(node.GenesisDoc().ChainID, apiConfig)
+		go func() {
+			logger.Info("rosetta api server running at " + rosettaAddr)
+			if err := rosetta.Run(rosettaAddr); err != nil {
+				logger.Error("rosetta api server stopped", "err", err)
+			}
+		}()
+	}
 
-	fmt.Println("starting node")
+	logger.Info("starting node", "version", c.App.Version)
 	node.Start()
+	notifier.Start()
+	tracker.Start()
+	// the node is stopped first, so that no block is committed while the
+	// services that listen to the commits stop
 	defer func() {
 		node.Stop()
 		node.Wait()
+		if grpcServer != nil {
+			grpcServer.Stop()
+		}
+		tracker.Stop()
+		notifier.Stop()
 	}()
 
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
-	<-ch
-	os.Exit(0)
+	sig := <-ch
+	logger.Info("stopping node", "signal", sig.String())
+	return nil
+}
+
+func cmdMigrate(c *cli.Context) error {
+	if err := loadConfig(c); err != nil {
+		return err
+	}
+	stateDir, archiveDir, err := storeDirs(c)
+	if err != nil {
+		return err
+	}
+	db, archiveDb, err := openStores(stateDir, archiveDir)
+	if err != nil {
+		return err
+	}
+	defer archiveDb.Close()
 
+	dryRun := c.Bool("dry-run")
+	storageLogger := rootLogger.With("module", "storage")
+	log := func(msg string) { storageLogger.Info(msg) }
+	if err := storage.MigrateState(db, dryRun, log); err != nil {
+		return errors.Wrap(err, "state migration failed")
+	}
+	if err := storage.MigrateArchive(archiveDb, dryRun, log); err != nil {
+		return errors.Wrap(err, "archive migration failed")
+	}
+	if dryRun {
+		return nil
+	}
+	if err := storage.CheckVersions(db, archiveDb); err != nil {
+		return err
+	}
+	logger.Info("databases are in the current schema version",
+		"state", storage.StateVersion, "archive", storage.ArchiveVersion)
 	return nil
 }
 
-func cmdInfo(c *cli.Context) error {
-	logger.Info("info")
+// apiURL returns the url of the api of the node of the home directory
+func apiURL(c *cli.Context) (string, error) {
+	appConfig, err := LoadAppConfig(homeDir(c))
+	if err != nil {
+		return "", err
+	}
+	host, port, err := net.SplitHostPort(appConfig.APIAddr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port), nil
+}
 
+func cmdInfo(c *cli.Context) error {
+	url := c.String("api-url")
+	if url == "" {
+		var err error
+		if url, err = apiURL(c); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(url, "/")+"/info", nil)
+	if err != nil {
+		return err
+	}
+	if key := c.String("api-key"); key != "" {
+		req.Header.Set(endpoint.APIKEYHEADER, key)
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to reach the api")
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("api error %d: %s", res.StatusCode, body)
+	}
+	var info endpoint.InfoMsg
+	if err := json.Unmarshal(body, &info); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
 	return nil
 }
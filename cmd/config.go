@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	tmos "github.com/tendermint/tendermint/libs/os"
+	"github.com/urfave/cli"
+)
+
+// GlobalFlags select the node home directory, so that several nodes can run
+// in the same machine
+var GlobalFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "home",
+		Value:  "tmp",
+		EnvVar: "KVARTALO_HOME",
+		Usage:  "home directory of the node, with its config and data",
+	},
+	cli.StringFlag{
+		Name:  "config",
+		Usage: "Tendermint config file, config/config.toml of the home directory by default. Without --home, the home is the parent directory of its directory",
+	},
+}
+
+// AppEnvPrefix is the prefix of the environment variables that override the
+// app config, as KVARTALO_API_ADDR or KVARTALO_PRUNING_TRACKED_TXS
+const AppEnvPrefix = "KVARTALO"
+
+// AppConfig is the config of the app, read from config/app.toml in the home
+// directory. The start flags override it.
+type AppConfig struct {
+	// APIAddr is the address of the REST api
+	APIAddr string `mapstructure:"api_addr"`
+	// GRPCAddr and RosettaAddr are the addresses of the gRPC and Rosetta
+	// apis, empty to disable them
+	GRPCAddr    string `mapstructure:"grpc_addr"`
+	RosettaAddr string `mapstructure:"rosetta_addr"`
+	// RPCURL is the Tendermint RPC url used by the api, empty to call the
+	// node in process
+	RPCURL string `mapstructure:"rpc_url"`
+	// Archive enables the tx history archive
+	Archive bool `mapstructure:"archive"`
+	// CORSOrigins are the origins allowed by CORS, all of them if empty
+	CORSOrigins []string      `mapstructure:"cors_origins"`
+	Pruning     PruningConfig `mapstructure:"pruning"`
+}
+
+// PruningConfig is the retention of the data of the app. The Tendermint
+// blocks are not pruned, as the archive is rebuilt from them by reindex.
+type PruningConfig struct {
+	// TrackedTxs is the number of submitted txs kept for /tx/:hash/status
+	TrackedTxs int `mapstructure:"tracked_txs"`
+}
+
+// DefaultAppConfig returns the AppConfig used for the missing values
+func DefaultAppConfig() *AppConfig {
+	return &AppConfig{
+		APIAddr:  ":3000",
+		GRPCAddr: ":9090",
+		Archive:  true,
+		Pruning:  PruningConfig{TrackedTxs: 10000},
+	}
+}
+
+const appConfigTemplate = `# kvartalochain app config. Each value can be overridden with an
+# environment variable, as ` + AppEnvPrefix + `_API_ADDR or ` + AppEnvPrefix + `_PRUNING_TRACKED_TXS
+
+# address of the REST api
+api_addr = "{{ .APIAddr }}"
+
+# address of the gRPC api, empty to disable it
+grpc_addr = "{{ .GRPCAddr }}"
+
+# address of the Rosetta api, empty to disable it
+rosetta_addr = "{{ .RosettaAddr }}"
+
+# Tendermint RPC url used by the api, in the form http://127.0.0.1:26657.
+# If empty, the api calls the node in process
+rpc_url = "{{ .RPCURL }}"
+
+# store the tx history archive, used by /history, /tx/:hash, /stats,
+# GraphQL and Rosetta
+archive = {{ .Archive }}
+
+# origins allowed by CORS, all of them if empty
+cors_origins = [{{ range $i, $o := .CORSOrigins }}{{ if $i }}, {{ end }}"{{ $o }}"{{ end }}]
+
+[pruning]
+# number of submitted txs kept for /tx/:hash/status, the oldest are dropped
+tracked_txs = {{ .Pruning.TrackedTxs }}
+`
+
+// homeDir returns the home directory of the --home and --config flags
+func homeDir(c *cli.Context) string {
+	if configFile := c.GlobalString("config"); configFile != "" && !c.GlobalIsSet("home") {
+		return filepath.Dir(filepath.Dir(configFile))
+	}
+	return c.GlobalString("home")
+}
+
+// configFile returns the Tendermint config file of the flags
+func configFile(c *cli.Context) string {
+	if configFile := c.GlobalString("config"); configFile != "" {
+		return configFile
+	}
+	return filepath.Join(homeDir(c), "config", "config.toml")
+}
+
+func appConfigFile(home string) string {
+	return filepath.Join(home, "config", "app.toml")
+}
+
+// WriteAppConfigFile writes appConfig to path, with a description of each
+// value
+func WriteAppConfigFile(path string, appConfig *AppConfig) error {
+	tmpl, err := template.New("app").Parse(appConfigTemplate)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, appConfig); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// LoadAppConfig reads the app config of the home directory, overridden by
+// the environment variables. The file is optional, the missing values are
+// the ones of DefaultAppConfig.
+func LoadAppConfig(home string) (*AppConfig, error) {
+	v := viper.New()
+	def := DefaultAppConfig()
+	v.SetDefault("api_addr", def.APIAddr)
+	v.SetDefault("grpc_addr", def.GRPCAddr)
+	v.SetDefault("rosetta_addr", def.RosettaAddr)
+	v.SetDefault("rpc_url", def.RPCURL)
+	v.SetDefault("archive", def.Archive)
+	v.SetDefault("cors_origins", def.CORSOrigins)
+	v.SetDefault("pruning.tracked_txs", def.Pruning.TrackedTxs)
+	v.SetEnvPrefix(AppEnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	path := appConfigFile(home)
+	if tmos.FileExists(path) {
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, errors.Wrap(err, "failed to read app config file")
+		}
+	}
+	appConfig := &AppConfig{}
+	if err := v.Unmarshal(appConfig); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal app config")
+	}
+	if err := appConfig.ValidateBasic(); err != nil {
+		return nil, errors.Wrap(err, "app config is invalid")
+	}
+	return appConfig, nil
+}
+
+// ValidateBasic checks the addresses and the pruning values
+func (appConfig *AppConfig) ValidateBasic() error {
+	if appConfig.APIAddr == "" {
+		return errors.New("api_addr can not be empty")
+	}
+	addrs := map[string]string{
+		"api_addr":     appConfig.APIAddr,
+		"grpc_addr":    appConfig.GRPCAddr,
+		"rosetta_addr": appConfig.RosettaAddr,
+	}
+	for name, addr := range addrs {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return errors.Wrapf(err, "invalid %s", name)
+		}
+	}
+	if appConfig.Pruning.TrackedTxs < 1 {
+		return errors.New("pruning.tracked_txs must be positive")
+	}
+	return nil
+}
+
+// ensureAppConfigFile writes the default app config in the home directory,
+// if there is none
+func ensureAppConfigFile(home string) error {
+	path := appConfigFile(home)
+	if tmos.FileExists(path) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	if err := WriteAppConfigFile(path, DefaultAppConfig()); err != nil {
+		return err
+	}
+	logger.Info("Generated app config file", "path", path)
+	return nil
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAppConfig(t *testing.T) {
+	home, err := ioutil.TempDir("", "kvartalo-home")
+	require.Nil(t, err)
+	defer os.RemoveAll(home)
+
+	// without file, the defaults
+	appConfig, err := LoadAppConfig(home)
+	require.Nil(t, err)
+	assert.Equal(t, DefaultAppConfig(), appConfig)
+
+	// the generated file has the defaults
+	require.Nil(t, ensureAppConfigFile(home))
+	appConfig, err = LoadAppConfig(home)
+	require.Nil(t, err)
+	assert.Equal(t, DefaultAppConfig(), appConfig)
+
+	// the file overrides the defaults, and the env the file
+	appConfig.APIAddr = "127.0.0.1:3001"
+	appConfig.Archive = false
+	appConfig.CORSOrigins = []string{"https://a.example", "https://b.example"}
+	appConfig.Pruning.TrackedTxs = 100
+	require.Nil(t, WriteAppConfigFile(filepath.Join(home, "config", "app.toml"), appConfig))
+	os.Setenv("KVARTALO_API_ADDR", ":3002")
+	os.Setenv("KVARTALO_PRUNING_TRACKED_TXS", "50")
+	defer os.Unsetenv("KVARTALO_API_ADDR")
+	defer os.Unsetenv("KVARTALO_PRUNING_TRACKED_TXS")
+	loaded, err := LoadAppConfig(home)
+	require.Nil(t, err)
+	assert.Equal(t, ":3002", loaded.APIAddr)
+	assert.Equal(t, ":9090", loaded.GRPCAddr)
+	assert.False(t, loaded.Archive)
+	assert.Equal(t, appConfig.CORSOrigins, loaded.CORSOrigins)
+	assert.Equal(t, 50, loaded.Pruning.TrackedTxs)
+
+	os.Setenv("KVARTALO_API_ADDR", "3002")
+	_, err = LoadAppConfig(home)
+	assert.Contains(t, err.Error(), "invalid api_addr")
+}
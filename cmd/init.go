@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"kvartalochain/chain"
 	"os"
 	"path/filepath"
 
@@ -15,22 +17,15 @@ import (
 	tmtime "github.com/tendermint/tendermint/types/time"
 )
 
-func initNode(config *cfg.Config) error {
-	err := os.Mkdir("tmp", os.ModePerm)
-	if err != nil {
-		return err
-	}
-	err = os.Mkdir("tmp/config", os.ModePerm)
-	if err != nil {
-		return err
-	}
-	err = os.Mkdir("tmp/data", os.ModePerm)
-	if err != nil {
-		return err
+// initNode creates the keys of the node and its config files in the home
+// directory
+func initNode(config *cfg.Config, home, configFile string) error {
+	for _, dir := range []string{filepath.Dir(configFile), filepath.Join(home, "config"), filepath.Join(home, "data")} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
 	}
-
-	configFile := "tmp/config/config.toml"
-	config.RootDir = filepath.Dir(filepath.Dir(configFile))
+	config.RootDir = home
 
 	// private validator
 	privValKeyFile := config.PrivValidatorKeyFile()
@@ -60,15 +55,20 @@ func initNode(config *cfg.Config) error {
 		logger.Info("Generated node key", "path", nodeKeyFile)
 	}
 
-	cfg.WriteConfigFile("tmp/config/config.toml", config)
+	// keep the ports and the rest of the values of an existing config
+	if tmos.FileExists(configFile) {
+		logger.Info("Found config file", "path", configFile)
+	} else {
+		cfg.WriteConfigFile(configFile, config)
+		logger.Info("Generated config file", "path", configFile)
+	}
 
-	return nil
+	return ensureAppConfigFile(home)
 }
 
-func initGenesis(config *cfg.Config) error {
+func initGenesis(config *cfg.Config, home, configFile string) error {
 
-	configFile := "tmp/config/config.toml"
-	config.RootDir = filepath.Dir(filepath.Dir(configFile))
+	config.RootDir = home
 
 	// private validator
 	privValKeyFile := config.PrivValidatorKeyFile()
@@ -96,10 +96,15 @@ func initGenesis(config *cfg.Config) error {
 	if tmos.FileExists(genFile) {
 		logger.Info("Found genesis file", "path", genFile)
 	} else {
+		appState, err := json.Marshal(chain.GenesisState{AppHash: true})
+		if err != nil {
+			return err
+		}
 		genDoc := types.GenesisDoc{
 			ChainID:         fmt.Sprintf("test-chain-%v", tmrand.Str(6)),
 			GenesisTime:     tmtime.Now(),
 			ConsensusParams: types.DefaultConsensusParams(),
+			AppState:        appState,
 		}
 		pubKey, err := pv.GetPubKey()
 		if err != nil {
@@ -117,7 +122,9 @@ func initGenesis(config *cfg.Config) error {
 		logger.Info("Generated genesis file", "path", genFile)
 	}
 
-	cfg.WriteConfigFile("tmp/config/config.toml", config)
+	if !tmos.FileExists(configFile) {
+		cfg.WriteConfigFile(configFile, config)
+	}
 
 	return nil
 }
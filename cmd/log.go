@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	cfg "github.com/tendermint/tendermint/config"
+	tmflags "github.com/tendermint/tendermint/libs/cli/flags"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+// rootLogger is the logger of the node and of the app modules, and logger
+// the one of the commands. Until loadConfig sets them with the log format
+// and level of the config, they log everything in plain text.
+var rootLogger = log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+var logger = rootLogger.With("module", "main")
+
+// Logger returns the logger of the commands
+func Logger() log.Logger {
+	return logger
+}
+
+// setupLogger sets the loggers with the log_format and log_level of
+// config. The app modules are app, storage, api, tracker and webhook, so
+// their level can be set as the Tendermint ones, as in
+// "main:info,state:info,app:debug,api:info,*:error".
+func setupLogger(config *cfg.Config) error {
+	var l log.Logger
+	if config.LogFormat == cfg.LogFormatJSON {
+		l = log.NewTMJSONLogger(log.NewSyncWriter(os.Stdout))
+	} else {
+		l = log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	}
+	l, err := tmflags.ParseLogLevel(config.LogLevel, l, cfg.DefaultLogLevel())
+	if err != nil {
+		return errors.Wrap(err, "failed to parse log level")
+	}
+	rootLogger = l
+	logger = rootLogger.With("module", "main")
+	return nil
+}
+
+// badgerLogger is the badger.Logger of the archive, its warnings are logged
+// as errors
+type badgerLogger struct {
+	log.Logger
+}
+
+func badgerMsg(format string, args ...interface{}) string {
+	return strings.TrimSpace(fmt.Sprintf(format, args...))
+}
+
+func (l badgerLogger) Errorf(format string, args ...interface{}) {
+	l.Error(badgerMsg(format, args...))
+}
+
+func (l badgerLogger) Warningf(format string, args ...interface{}) {
+	l.Error(badgerMsg(format, args...))
+}
+
+func (l badgerLogger) Infof(format string, args ...interface{}) {
+	l.Info(badgerMsg(format, args...))
+}
+
+func (l badgerLogger) Debugf(format string, args ...interface{}) {
+	l.Debug(badgerMsg(format, args...))
+}
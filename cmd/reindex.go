@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/pkg/errors"
+	nm "github.com/tendermint/tendermint/node"
+	sm "github.com/tendermint/tendermint/state"
+	"github.com/tendermint/tendermint/store"
+	tmdb "github.com/tendermint/tm-db"
+	"github.com/urfave/cli"
+)
+
+func cmdReindex(c *cli.Context) error {
+	if err := loadConfig(c); err != nil {
+		return err
+	}
+
+	// the Tendermint databases are locked while the node is running, so
+	// the node must be stopped
+	blockStoreDb, err := nm.DefaultDBProvider(&nm.DBContext{ID: "blockstore", Config: config})
+	if err != nil {
+		return errors.Wrap(err, "failed to open block store")
+	}
+	defer blockStoreDb.Close()
+	stateDb, err := nm.DefaultDBProvider(&nm.DBContext{ID: "state", Config: config})
+	if err != nil {
+		return errors.Wrap(err, "failed to open Tendermint state db")
+	}
+	defer stateDb.Close()
+	_, archiveDir, err := storeDirs(c)
+	if err != nil {
+		return err
+	}
+	archiveDb, err := openArchive(archiveDir)
+	if err != nil {
+		return err
+	}
+	defer archiveDb.Close()
+
+	blockStore := store.NewBlockStore(blockStoreDb)
+	if blockStore.Base() > 1 {
+		return fmt.Errorf("block store is pruned up to height %d, can not rebuild the history", blockStore.Base())
+	}
+
+	logger.Info("deleting archived txs")
+	if err := storage.DeleteArchivedTxs(archiveDb); err != nil {
+		return err
+	}
+	logger.Info("reindexing blocks", "height", blockStore.Height())
+	stats := newReindexStats()
+	for height := int64(1); height <= blockStore.Height(); height++ {
+		if err := reindexBlock(blockStore, stateDb, archiveDb, stats, height); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to reindex block %d", height))
+		}
+		if height%1000 == 0 {
+			logger.Info("reindexed blocks", "height", height)
+		}
+	}
+	// the history is rebuilt in the current schema
+	batch := archiveDb.NewBatch()
+	if err := batch.Set(storage.KEYSCHEMAVERSION, []byte{storage.ArchiveVersion}); err != nil {
+		batch.Discard()
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	logger.Info("reindex done", "height", blockStore.Height())
+	return nil
+}
+
+// reindexStats keeps the accounts and the supply while replaying the txs, as
+// the state at each height is not available
+type reindexStats struct {
+	accounts map[common.Address]bool
+	supply   uint64
+}
+
+func newReindexStats() *reindexStats {
+	return &reindexStats{accounts: make(map[common.Address]bool)}
+}
+
+// add returns the number of accounts created by the tx
+func (r *reindexStats) add(tx *common.Tx) uint64 {
+	if tx.Type == common.TxTypeMint {
+		r.supply += tx.Amount
+	}
+	newAccounts := uint64(0)
+	for _, addr := range []common.Address{tx.From, tx.To} {
+		if !r.accounts[addr] {
+			r.accounts[addr] = true
+			newAccounts++
+		}
+	}
+	return newAccounts
+}
+
+func reindexBlock(blockStore *store.BlockStore, stateDb tmdb.DB, archiveDb storage.ArchiveDB, stats *reindexStats, height int64) error {
+	block := blockStore.LoadBlock(height)
+	if block == nil {
+		return fmt.Errorf("block not found")
+	}
+	abciResponses, err := sm.LoadABCIResponses(stateDb, height)
+	if err != nil {
+		return err
+	}
+	if len(abciResponses.DeliverTxs) != len(block.Txs) {
+		return fmt.Errorf("block has %d txs, but %d DeliverTx results",
+			len(block.Txs), len(abciResponses.DeliverTxs))
+	}
+
+	batch := archiveDb.NewBatch()
+	for i, txRaw := range block.Txs {
+		if abciResponses.DeliverTxs[i].Code != 0 {
+			// failed txs are not in the archive
+			continue
+		}
+		txBytes, err := hex.DecodeString(string(txRaw))
+		if err != nil {
+			batch.Discard()
+			return err
+		}
+		tx, err := common.TxFromBytes(txBytes)
+		if err != nil {
+			batch.Discard()
+			return err
+		}
+		if err := storage.StoreTx(batch, uint64(height), uint32(i), txRaw, tx); err != nil {
+			batch.Discard()
+			return err
+		}
+		newAccounts := stats.add(tx)
+		if err := storage.StoreStats(batch, block.Time, tx, newAccounts, stats.supply); err != nil {
+			batch.Discard()
+			return err
+		}
+	}
+	err = storage.StoreBlockHeader(batch, &storage.BlockHeader{
+		Height:     uint64(height),
+		Hash:       block.Hash(),
+		ParentHash: block.LastBlockID.Hash,
+		Time:       block.Time,
+	})
+	if err != nil {
+		batch.Discard()
+		return err
+	}
+	if err := storage.SetArchiveHeight(batch, uint64(height)); err != nil {
+		batch.Discard()
+		return err
+	}
+	return batch.Commit()
+}
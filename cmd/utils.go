@@ -1,36 +1,172 @@
 package cmd
 
 import (
-	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
 
 	"kvartalochain/chain"
+	"kvartalochain/endpoint"
 	"kvartalochain/storage"
 
 	abci "github.com/tendermint/tendermint/abci/types"
-	cfg "github.com/tendermint/tendermint/config"
-	tmflags "github.com/tendermint/tendermint/libs/cli/flags"
-	"github.com/tendermint/tendermint/libs/log"
 	nm "github.com/tendermint/tendermint/node"
 	"github.com/tendermint/tendermint/p2p"
 	"github.com/tendermint/tendermint/privval"
 	"github.com/tendermint/tendermint/proxy"
+	"github.com/urfave/cli"
 )
 
-func loadTendermint(dbpath string) (*nm.Node, *storage.Storage, *badger.DB) {
-	fmt.Println("PATH", dbpath)
-	db, err := storage.NewStorage(dbpath)
+var storeFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "state-dir",
+		Value: "appdata/state",
+		Usage: "directory of the state db, relative to the node root",
+	},
+	cli.StringFlag{
+		Name:  "archive-dir",
+		Value: "appdata/archive",
+		Usage: "directory of the tx history archive db, relative to the node root",
+	},
+}
+
+// storeDirs returns the directories of the state and archive dbs, checking
+// that they are not shared with another db
+func storeDirs(c *cli.Context) (string, string, error) {
+	stateDir := rootify(c.String("state-dir"))
+	archiveDir := rootify(c.String("archive-dir"))
+	if err := storage.CheckDirsDisjoint(stateDir, archiveDir, config.DBDir()); err != nil {
+		return "", "", err
+	}
+	// the earlier versions kept both dbs in the db_dir of the Tendermint
+	// config, which was read relative to the working directory
+	for _, dir := range []string{config.DBDir(), config.DBPath} {
+		if err := storage.CheckLegacyLayout(dir, stateDir, archiveDir); err != nil {
+			return "", "", err
+		}
+	}
+	if err := storage.CheckDir(stateDir, storage.StateDirName, storage.IsStateFile); err != nil {
+		return "", "", err
+	}
+	if err := storage.CheckDir(archiveDir, storage.ArchiveDirName, storage.IsArchiveFile); err != nil {
+		return "", "", err
+	}
+	return stateDir, archiveDir, nil
+}
+
+var apiFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "api-keys",
+		Usage: "json file with the api keys, an array of {name, key, scopes}, where the scopes are read, submit or admin. Without it the api is open",
+	},
+	cli.BoolFlag{
+		Name:  "anonymous-read",
+		Usage: "allow the read endpoints without api key",
+	},
+	cli.Float64Flag{
+		Name:  "rate-limit",
+		Value: 50,
+		Usage: "requests per second of each api key, 0 for no limit",
+	},
+	cli.IntFlag{
+		Name:  "rate-burst",
+		Value: 100,
+		Usage: "requests of each api key that can be done at once",
+	},
+	cli.Float64Flag{
+		Name:  "ip-rate-limit",
+		Value: 10,
+		Usage: "requests per second of each ip without api key, 0 for no limit",
+	},
+	cli.IntFlag{
+		Name:  "ip-rate-burst",
+		Value: 20,
+		Usage: "requests of each ip without api key that can be done at once",
+	},
+	cli.StringFlag{
+		Name:  "trusted-proxies",
+		Usage: "comma separated ips or CIDR ranges of the reverse proxies whose X-Forwarded-For header gives the ip of the client. By default the header is ignored",
+	},
+	cli.StringFlag{
+		Name:  "cors-origins",
+		Usage: "comma separated origins allowed by CORS, overrides cors_origins of the app config. All of them by default",
+	},
+}
+
+// loadApiConfig returns the endpoint.Config of the apiFlags
+func loadApiConfig(c *cli.Context) (*endpoint.Config, error) {
+	apiConfig := &endpoint.Config{
+		AnonymousRead: c.Bool("anonymous-read"),
+		KeyRateLimit: endpoint.RateLimit{
+			Rate:  c.Float64("rate-limit"),
+			Burst: c.Int("rate-burst"),
+		},
+		IPRateLimit: endpoint.RateLimit{
+			Rate:  c.Float64("ip-rate-limit"),
+			Burst: c.Int("ip-rate-burst"),
+		},
+	}
+	if path := c.String("api-keys"); path != "" {
+		keys, err := endpoint.LoadAPIKeys(rootify(path))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load api keys")
+		}
+		apiConfig.Keys = keys
+	}
+	if proxies := c.String("trusted-proxies"); proxies != "" {
+		var list []string
+		for _, proxy := range strings.Split(proxies, ",") {
+			list = append(list, strings.TrimSpace(proxy))
+		}
+		nets, err := endpoint.ParseTrustedProxies(list)
+		if err != nil {
+			return nil, err
+		}
+		apiConfig.TrustedProxies = nets
+	}
+	return apiConfig, nil
+}
+
+func rootify(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(config.RootDir, path)
+}
+
+func openStores(stateDir, archiveDir string) (storage.StateDB, storage.ArchiveDB, error) {
+	db, err := storage.NewStorage(stateDir)
 	if err != nil {
-		logger.Error("failed to open storage db: %v", err)
-		os.Exit(1)
+		return nil, nil, errors.Wrap(err, "failed to open storage db")
 	}
+	archiveDb, err := openArchive(archiveDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, archiveDb, nil
+}
 
-	archiveDb, err := badger.Open(badger.DefaultOptions(dbpath))
+func openArchive(archiveDir string) (storage.ArchiveDB, error) {
+	badgerDb, err := badger.Open(badger.DefaultOptions(archiveDir).
+		WithLogger(badgerLogger{rootLogger.With("module", "badger")}))
 	if err != nil {
-		logger.Error("failed to open badger db: %v", err)
+		return nil, errors.Wrap(err, "failed to open badger db")
+	}
+	return storage.NewBadgerArchive(badgerDb), nil
+}
+
+func loadTendermint(stateDir, archiveDir string) (*nm.Node, *chain.KvartaloABCI, storage.StateDB, storage.ArchiveDB) {
+	logger.Info("opening stores", "state", stateDir, "archive", archiveDir)
+	db, archiveDb, err := openStores(stateDir, archiveDir)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	if err := storage.CheckVersions(db, archiveDb); err != nil {
+		logger.Error(err.Error())
 		os.Exit(1)
 	}
 	// defer db.Close()
@@ -39,21 +175,14 @@ func loadTendermint(dbpath string) (*nm.Node, *storage.Storage, *badger.DB) {
 
 	node, err := newTendermint(app)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v", err)
+		logger.Error(err.Error())
 		os.Exit(2)
 	}
-	return node, db, archiveDb
+	return node, app, db, archiveDb
 }
 
 // func newTendermint(app abci.Application, configFile string) (*nm.Node, error) {
 func newTendermint(app abci.Application) (*nm.Node, error) {
-	// create logger
-	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
-	var err error
-	logger, err = tmflags.ParseLogLevel(config.LogLevel, logger, cfg.DefaultLogLevel())
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to parse log level")
-	}
 	// read private validator
 	pv := privval.LoadFilePV(
 		config.PrivValidatorKeyFile(),
@@ -73,7 +202,7 @@ func newTendermint(app abci.Application) (*nm.Node, error) {
 		nm.DefaultGenesisDocProviderFunc(config),
 		nm.DefaultDBProvider,
 		nm.DefaultMetricsProvider(config.Instrumentation),
-		logger)
+		rootLogger)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create new Tendermint node")
 	}
@@ -79,10 +79,7 @@ func VerifySignature(addr *Address, msg, sigB []byte) bool {
 	return true
 }
 func VerifySignatureTx(tx *Tx) bool {
-	txToHash := tx.Clone()
-	txToHash.Signature = []byte{}
-	txBytes := txToHash.Bytes()
-	h := blake2b.Sum256(txBytes)
+	h := TxSigHash(tx)
 
 	pkRec, _, err := btcec.RecoverCompact(btcec.S256(), tx.Signature, h[:])
 	if err != nil {
@@ -260,3 +257,11 @@ func (tx *Tx) Clone() *Tx {
 		Signature: tx.Signature,
 	}
 }
+
+// TxSigHash returns the hash signed in the tx signature, the blake2b of the
+// tx bytes without signature
+func TxSigHash(tx *Tx) [32]byte {
+	txToHash := tx.Clone()
+	txToHash.Signature = []byte{}
+	return blake2b.Sum256(txToHash.Bytes())
+}
@@ -139,3 +139,14 @@ func TestAddressMarshallers(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, addr, addrParsed)
 }
+
+func TestTxSigHash(t *testing.T) {
+	sk := ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	tx := NewTx(sk.Public().Address(), sk.Public().Address(), 1, 0)
+	h := TxSigHash(tx)
+	assert.Nil(t, sk.SignTx(tx))
+	assert.Equal(t, h, TxSigHash(tx))
+	// the signature of the tx is the signature of the unsigned tx bytes
+	unsigned := NewTx(tx.From, tx.To, 1, 0)
+	assert.True(t, VerifySignature(&tx.From, unsigned.Bytes(), tx.Signature))
+}
@@ -0,0 +1,362 @@
+package endpoint
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	tmlog "github.com/tendermint/tendermint/libs/log"
+)
+
+// scopes of the api keys, ScopeAdmin includes the other scopes
+const (
+	ScopeRead   = "read"
+	ScopeSubmit = "submit"
+	ScopeAdmin  = "admin"
+)
+
+// APIKEYHEADER is the http header with the api key. The key can also be sent
+// as "Authorization: Bearer <key>".
+const APIKEYHEADER = "X-API-Key"
+
+// APIKey is a key of a client of the api and its scopes
+type APIKey struct {
+	Name   string   `json:"name"`
+	Key    string   `json:"key"`
+	Scopes []string `json:"scopes"`
+}
+
+func (k *APIKey) allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimit is a token bucket of Burst requests, refilled with Rate requests
+// per second. A zero Rate does not limit the requests.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Config are the access settings of the api. Without Keys, the api is open.
+type Config struct {
+	Keys []APIKey
+	// AnonymousRead allows the read endpoints without key when there are
+	// Keys
+	AnonymousRead bool
+	// KeyRateLimit is applied to each key, and IPRateLimit to each ip of
+	// the requests without key
+	KeyRateLimit RateLimit
+	IPRateLimit  RateLimit
+	// CORSOrigins are the allowed origins, all of them if empty
+	CORSOrigins []string
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header
+	// gives the ip of the client. Without them the header is ignored.
+	TrustedProxies []*net.IPNet
+	// Metrics records the requests, they are discarded if nil
+	Metrics *Metrics
+	// Logger logs the requests and the errors of the api, nothing is
+	// logged if nil
+	Logger tmlog.Logger
+}
+
+// LoadAPIKeys reads the api keys from a json file with an array of APIKey
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k.Key == "" {
+			return nil, fmt.Errorf("api key %q is empty", k.Name)
+		}
+		for _, s := range k.Scopes {
+			if s != ScopeRead && s != ScopeSubmit && s != ScopeAdmin {
+				return nil, fmt.Errorf("api key %q has an unknown scope %q", k.Name, s)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// ParseTrustedProxies parses the ips and CIDR ranges of the trusted proxies
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy ip %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy range %q", proxy)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(cfg *Config, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if proxy.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the ip of the client of the request. X-Forwarded-For is
+// only read when the request comes from a trusted proxy, and its last ip
+// that is not a trusted proxy is the client.
+func clientIP(cfg *Config, c *gin.Context) string {
+	ip, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		ip = c.Request.RemoteAddr
+	}
+	if !isTrustedProxy(cfg, ip) {
+		return ip
+	}
+	forwarded := strings.Split(c.GetHeader("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !isTrustedProxy(cfg, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func corsMiddleware(cfg *Config) gin.HandlerFunc {
+	corsConfig := cors.DefaultConfig()
+	if len(cfg.CORSOrigins) == 0 {
+		corsConfig.AllowAllOrigins = true
+	} else {
+		corsConfig.AllowOrigins = cfg.CORSOrigins
+	}
+	corsConfig.AddAllowHeaders("Authorization", APIKEYHEADER)
+	return cors.New(corsConfig)
+}
+
+// keyIndex returns the keys of cfg by the hash of the key, so the lookup
+// time does not depend on the key
+func keyIndex(cfg *Config) map[[32]byte]*APIKey {
+	keys := make(map[[32]byte]*APIKey)
+	for i := range cfg.Keys {
+		keys[sha256.Sum256([]byte(cfg.Keys[i].Key))] = &cfg.Keys[i]
+	}
+	return keys
+}
+
+// bearerKey returns the key of an "Authorization: Bearer <key>" value
+func bearerKey(auth string) string {
+	if len(auth) > len("Bearer ") && auth[:len("Bearer ")] == "Bearer " {
+		return auth[len("Bearer "):]
+	}
+	return ""
+}
+
+// checkScope returns an error if the request with apiKey, nil without key,
+// can not access the endpoints of scope. When there are no keys, all the
+// requests are allowed but the admin ones, which always need a key.
+func checkScope(cfg *Config, apiKey *APIKey, scope string) error {
+	if len(cfg.Keys) == 0 {
+		if scope == ScopeAdmin {
+			return newApiError(http.StatusForbidden, errors.New("the admin endpoints need api keys, set with --api-keys"))
+		}
+		return nil
+	}
+	if apiKey == nil {
+		if scope == ScopeRead && cfg.AnonymousRead {
+			return nil
+		}
+		return newApiError(http.StatusUnauthorized, errors.New("api key required"))
+	}
+	if !apiKey.allows(scope) {
+		return newApiError(http.StatusForbidden, fmt.Errorf("api key without %s scope", scope))
+	}
+	return nil
+}
+
+// requestKey returns the api key of the request, empty if it has none
+func requestKey(c *gin.Context) string {
+	if key := c.GetHeader(APIKEYHEADER); key != "" {
+		return key
+	}
+	return bearerKey(c.GetHeader("Authorization"))
+}
+
+// authenticate sets the APIKey of the request in the context, and rejects
+// the requests with unknown keys. It runs after rateLimit, so that the
+// requests with unknown keys have been charged to their ip.
+func authenticate(cfg *Config) gin.HandlerFunc {
+	keys := keyIndex(cfg)
+	return func(c *gin.Context) {
+		key := requestKey(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+		apiKey, ok := keys[sha256.Sum256([]byte(key))]
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "invalid api key",
+			})
+			return
+		}
+		c.Set("apikey", apiKey)
+		c.Next()
+	}
+}
+
+// requireScope rejects the requests whose key does not have the scope
+func requireScope(cfg *Config, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var apiKey *APIKey
+		if v, ok := c.Get("apikey"); ok {
+			apiKey = v.(*APIKey)
+		}
+		if err := checkScope(cfg, apiKey, scope); err != nil {
+			c.AbortWithStatusJSON(errorStatus(err), gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket for each client
+type rateLimiter struct {
+	mutex   sync.Mutex
+	limit   RateLimit
+	buckets map[string]*bucket
+}
+
+// maxBuckets is the number of clients after which the full buckets are
+// dropped
+const maxBuckets = 10000
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{limit: limit, buckets: make(map[string]*bucket)}
+}
+
+func (r *rateLimiter) refill(b *bucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * r.limit.Rate
+	if b.tokens > float64(r.limit.Burst) {
+		b.tokens = float64(r.limit.Burst)
+	}
+	b.last = now
+}
+
+// allow takes a token of the client bucket, and returns false if it is empty
+func (r *rateLimiter) allow(client string, now time.Time) bool {
+	return r.allowN(client, 1, now)
+}
+
+// allowN takes n tokens of the client bucket, and returns false if it does
+// not have them. n is capped at the burst, which empties a full bucket.
+func (r *rateLimiter) allowN(client string, n int, now time.Time) bool {
+	if r.limit.Rate <= 0 {
+		return true
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	b, ok := r.buckets[client]
+	if !ok {
+		if len(r.buckets) >= maxBuckets {
+			for k, other := range r.buckets {
+				r.refill(other, now)
+				if other.tokens >= float64(r.limit.Burst) {
+					delete(r.buckets, k)
+				}
+			}
+		}
+		b = &bucket{tokens: float64(r.limit.Burst), last: now}
+		r.buckets[client] = b
+	}
+	r.refill(b, now)
+	if n > r.limit.Burst {
+		n = r.limit.Burst
+	}
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// chargeRateLimit takes n more requests of the bucket of the client, for
+// the requests that cost more than one, and returns false if it is empty
+func chargeRateLimit(c *gin.Context, n int) bool {
+	if v, ok := c.Get("ratecharge"); ok && n > 0 {
+		return v.(func(int) bool)(n)
+	}
+	return true
+}
+
+// rateLimit limits the requests of each key, and of each ip for the requests
+// without a valid key, so that the keys can not be guessed faster than the
+// ip limit. The ip of the client and its chargeRateLimit function are set
+// in the context.
+func rateLimit(cfg *Config) gin.HandlerFunc {
+	keys := keyIndex(cfg)
+	byKey := newRateLimiter(cfg.KeyRateLimit)
+	byIP := newRateLimiter(cfg.IPRateLimit)
+	return func(c *gin.Context) {
+		ip := clientIP(cfg, c)
+		c.Set("clientip", ip)
+		limiter, client := byIP, ip
+		if apiKey, ok := keys[sha256.Sum256([]byte(requestKey(c)))]; ok {
+			limiter, client = byKey, apiKey.Key
+		}
+		c.Set("ratecharge", func(n int) bool {
+			return limiter.allowN(client, n, time.Now())
+		})
+		if !limiter.allow(client, time.Now()) {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": "rate limit exceeded",
+			})
+			return
+		}
+		c.Next()
+	}
+}
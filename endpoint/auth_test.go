@@ -0,0 +1,162 @@
+package endpoint
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kvartalochain/chain"
+	"kvartalochain/storage"
+	"kvartalochain/webhook"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestApiWithConfig(t *testing.T, cfg *Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	sto, err := storage.NewMemStorage()
+	require.Nil(t, err)
+	archive := storage.NewMemArchive()
+	app := chain.NewKvartaloApplication(sto, archive)
+	client := NewMockNodeClient(app)
+	txTracker, err := NewTracker(archive, client)
+	require.Nil(t, err)
+	return Serve(app, sto, archive, client, webhook.NewNotifier(archive), txTracker, cfg)
+}
+
+func doRequestHeaders(api *gin.Engine, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuth(t *testing.T) {
+	cfg := &Config{
+		Keys: []APIKey{
+			{Name: "reader", Key: "k-read", Scopes: []string{ScopeRead}},
+			{Name: "wallet", Key: "k-submit", Scopes: []string{ScopeRead, ScopeSubmit}},
+			{Name: "operator", Key: "k-admin", Scopes: []string{ScopeAdmin}},
+		},
+	}
+	api := newTestApiWithConfig(t, cfg)
+	withKey := func(key string) map[string]string {
+		return map[string]string{APIKEYHEADER: key}
+	}
+
+	w := doRequestHeaders(api, "GET", "/supply", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = doRequestHeaders(api, "GET", "/supply", "", withKey("wrong"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = doRequestHeaders(api, "GET", "/supply", "", withKey("k-read"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequestHeaders(api, "GET", "/supply", "", map[string]string{"Authorization": "Bearer k-read"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the body is invalid, so the request passes the auth when it gets 400
+	w = doRequestHeaders(api, "POST", "/tx", "{}", withKey("k-read"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = doRequestHeaders(api, "POST", "/tx", "{}", withKey("k-submit"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequestHeaders(api, "POST", "/tx", "{}", withKey("k-admin"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequestHeaders(api, "POST", "/webhooks", "{}", withKey("k-submit"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = doRequestHeaders(api, "POST", "/webhooks", "{}", withKey("k-admin"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	cfg.AnonymousRead = true
+	api = newTestApiWithConfig(t, cfg)
+	w = doRequestHeaders(api, "GET", "/supply", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequestHeaders(api, "POST", "/tx", "{}", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRateLimit(t *testing.T) {
+	api := newTestApiWithConfig(t, &Config{
+		Keys:          []APIKey{{Name: "wallet", Key: "k", Scopes: []string{ScopeRead}}},
+		AnonymousRead: true,
+		KeyRateLimit:  RateLimit{Rate: 1, Burst: 3},
+		IPRateLimit:   RateLimit{Rate: 1, Burst: 2},
+	})
+	for i := 0; i < 2; i++ {
+		w := doRequestHeaders(api, "GET", "/supply", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	w := doRequestHeaders(api, "GET", "/supply", "", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	// the probes are not limited
+	w = doRequestHeaders(api, "GET", "/healthz", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	// the key has its own bucket
+	for i := 0; i < 3; i++ {
+		w = doRequestHeaders(api, "GET", "/supply", "", map[string]string{APIKEYHEADER: "k"})
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	w = doRequestHeaders(api, "GET", "/supply", "", map[string]string{APIKEYHEADER: "k"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// the unknown keys are charged to the ip, which bucket is empty
+	w = doRequestHeaders(api, "GET", "/supply", "", map[string]string{APIKEYHEADER: "guess"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	// and X-Forwarded-For does not change the ip without trusted proxies
+	w = doRequestHeaders(api, "GET", "/supply", "", map[string]string{"X-Forwarded-For": "203.0.113.7"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// the buckets are refilled with the rate
+	limiter := newRateLimiter(RateLimit{Rate: 2, Burst: 1})
+	now := time.Now()
+	assert.True(t, limiter.allow("a", now))
+	assert.False(t, limiter.allow("a", now))
+	assert.True(t, limiter.allow("b", now))
+	assert.True(t, limiter.allow("a", now.Add(500*time.Millisecond)))
+}
+
+func TestCORS(t *testing.T) {
+	api := newTestApiWithConfig(t, &Config{CORSOrigins: []string{"https://wallet.example"}})
+	w := doRequestHeaders(api, "GET", "/supply", "", map[string]string{"Origin": "https://wallet.example"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://wallet.example", w.Header().Get("Access-Control-Allow-Origin"))
+	w = doRequestHeaders(api, "GET", "/supply", "", map[string]string{"Origin": "https://other.example"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	require.Nil(t, err)
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.NotNil(t, err)
+	_, err = ParseTrustedProxies([]string{"proxy"})
+	assert.NotNil(t, err)
+
+	for _, c := range []struct {
+		proxies   []*net.IPNet
+		remote    string
+		forwarded string
+		expected  string
+	}{
+		{nil, "192.0.2.1:1234", "203.0.113.7", "192.0.2.1"},
+		{proxies, "198.51.100.1:1234", "203.0.113.7", "198.51.100.1"},
+		{proxies, "192.0.2.1:1234", "", "192.0.2.1"},
+		{proxies, "192.0.2.1:1234", "203.0.113.7", "203.0.113.7"},
+		// the client can only prepend ips
+		{proxies, "192.0.2.1:1234", "1.2.3.4, 203.0.113.7, 10.0.0.2", "203.0.113.7"},
+	} {
+		req := httptest.NewRequest("GET", "/supply", nil)
+		req.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		ctx := &gin.Context{Request: req}
+		assert.Equal(t, c.expected, clientIP(&Config{TrustedProxies: c.proxies}, ctx), c.forwarded)
+	}
+}
@@ -0,0 +1,131 @@
+package endpoint
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/gin-gonic/gin"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// BuildTxMsg are the fields of a tx to build. Type is TxTypeNormal by
+// default.
+type BuildTxMsg struct {
+	Type   common.TxType  `json:"type"`
+	From   common.Address `json:"from" binding:"required"`
+	To     common.Address `json:"to" binding:"required"`
+	Amount uint64         `json:"amount" binding:"required"`
+	// Nonce is the next nonce of From, counting its txs in the mempool, if
+	// not given
+	Nonce *uint64 `json:"nonce"`
+}
+
+// BuildTxResultMsg is the unsigned tx. The signature is the 65 bytes compact
+// secp256k1 signature of SigHash, and it is sent with the tx fields to
+// POST /tx/submit.
+type BuildTxResultMsg struct {
+	Tx      *common.Tx `json:"tx"`
+	TxBytes string     `json:"txBytes"`
+	SigHash string     `json:"sigHash"`
+}
+
+// SubmitTxMsg are the fields of a built tx with its signature
+type SubmitTxMsg struct {
+	Type      common.TxType  `json:"type"`
+	From      common.Address `json:"from" binding:"required"`
+	To        common.Address `json:"to" binding:"required"`
+	Amount    uint64         `json:"amount" binding:"required"`
+	Nonce     *uint64        `json:"nonce" binding:"required"`
+	Signature string         `json:"signature" binding:"required"`
+	// Mode is the broadcast mode, as in PostTxMsg
+	Mode string `json:"mode"`
+}
+
+func checkTxType(t common.TxType) error {
+	if t != common.TxTypeNormal && t != common.TxTypeMint {
+		return fmt.Errorf("invalid tx type %d", t)
+	}
+	return nil
+}
+
+func handleBuildTx(c *gin.Context) {
+	var m BuildTxMsg
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := checkTxType(m.Type); err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	var nonce uint64
+	if m.Nonce != nil {
+		nonce = *m.Nonce
+	} else {
+		committed, err := storage.GetNonce(db, m.From)
+		if err != nil {
+			c.JSON(500, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		nonce, err = pendingNonce(m.From, committed)
+		if err != nil {
+			writeError(c, mempoolError(err))
+			return
+		}
+	}
+
+	tx := common.NewTx(m.From, m.To, m.Amount, nonce)
+	tx.Type = m.Type
+	sigHash := common.TxSigHash(tx)
+	c.JSON(200, BuildTxResultMsg{
+		Tx:      tx,
+		TxBytes: hex.EncodeToString(tx.Bytes()),
+		SigHash: hex.EncodeToString(sigHash[:]),
+	})
+}
+
+// handleSubmitTx rebuilds a tx from its fields and signature, and broadcasts
+// it as POST /tx does
+func handleSubmitTx(c *gin.Context) {
+	var m SubmitTxMsg
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := checkTxType(m.Type); err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	sig, err := hex.DecodeString(m.Signature)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	tx := common.NewTx(m.From, m.To, m.Amount, *m.Nonce)
+	tx.Type = m.Type
+	tx.Signature = sig
+	if !common.VerifySignatureTx(tx) {
+		c.JSON(400, gin.H{
+			"error": "invalid signature",
+		})
+		return
+	}
+	broadcastTx(c, tmtypes.Tx(tx.Hex()), m.Mode)
+}
@@ -0,0 +1,189 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"kvartalochain/chain"
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	EventIncoming = "incoming"
+	EventOutgoing = "outgoing"
+)
+
+// eventsKeepAlive is the interval of the keep alive messages of the event
+// stream, so proxies don't close idle connections
+var eventsKeepAlive = 30 * time.Second
+
+// subscriptionBuffer is the number of events that can be waiting to be sent
+// to a subscriber. Subscribers that don't keep up are disconnected.
+const subscriptionBuffer = 256
+
+// AccountEvent is sent to the subscribers of Addr for each committed tx of
+// the address. Balance is the balance of Addr after the block.
+type AccountEvent struct {
+	Type    string         `json:"type"`
+	Addr    common.Address `json:"addr"`
+	Height  uint64         `json:"height"`
+	Hash    string         `json:"hash"`
+	Tx      *common.Tx     `json:"tx"`
+	Balance uint64         `json:"balance"`
+}
+
+type subscription struct {
+	addrs map[common.Address]bool
+	ch    chan AccountEvent
+}
+
+type eventHub struct {
+	mutex sync.Mutex
+	subs  map[*subscription]bool
+}
+
+var hub = &eventHub{subs: make(map[*subscription]bool)}
+
+func (h *eventHub) subscribe(addrs []common.Address) *subscription {
+	sub := &subscription{
+		addrs: make(map[common.Address]bool),
+		ch:    make(chan AccountEvent, subscriptionBuffer),
+	}
+	for _, addr := range addrs {
+		sub.addrs[addr] = true
+	}
+	h.mutex.Lock()
+	h.subs[sub] = true
+	h.mutex.Unlock()
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *subscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.subs[sub] {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// subscribed returns true if there is any subscriber of addr
+func (h *eventHub) subscribed(addr common.Address) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for sub := range h.subs {
+		if sub.addrs[addr] {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *eventHub) publish(ev AccountEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for sub := range h.subs {
+		if !sub.addrs[ev.Addr] {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			// the subscriber is too slow, drop it
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// PublishCommit is a chain.CommitListener that sends the AccountEvents of the
+// successful txs to the subscribers
+func PublishCommit(height uint64, txs []chain.CommittedTx) {
+	balances := make(map[common.Address]uint64)
+	for _, committed := range txs {
+		if committed.Failed() {
+			continue
+		}
+		for _, ev := range []AccountEvent{
+			{Type: EventOutgoing, Addr: committed.Tx.From},
+			{Type: EventIncoming, Addr: committed.Tx.To},
+		} {
+			if !hub.subscribed(ev.Addr) {
+				continue
+			}
+			balance, ok := balances[ev.Addr]
+			if !ok {
+				var err error
+				balance, err = storage.GetBalance(db, ev.Addr)
+				if err != nil {
+					continue
+				}
+				balances[ev.Addr] = balance
+			}
+			ev.Height = height
+			ev.Hash = fmt.Sprintf("%X", committed.Hash)
+			ev.Tx = committed.Tx
+			ev.Balance = balance
+			hub.publish(ev)
+		}
+	}
+}
+
+// subscribeEvents subscribes to the AccountEvents of the addresses, each of
+// them can be a comma separated list
+func subscribeEvents(params []string) (*subscription, []common.Address, error) {
+	var addrs []common.Address
+	for _, param := range params {
+		for _, addrStr := range strings.Split(param, ",") {
+			addr, err := parseAddr(addrStr)
+			if err != nil {
+				return nil, nil, err
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, nil, newApiError(http.StatusBadRequest, errors.New("no addr to subscribe"))
+	}
+	return hub.subscribe(addrs), addrs, nil
+}
+
+// handleEvents streams with Server-Sent Events the AccountEvents of the
+// addresses in the addr query parameters
+func handleEvents(c *gin.Context) {
+	sub, addrs, err := subscribeEvents(c.QueryArray("addr"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	defer hub.unsubscribe(sub)
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.SSEvent("subscribed", gin.H{"addrs": addrs})
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev, ok := <-sub.ch:
+			if !ok {
+				return false
+			}
+			c.SSEvent(ev.Type, ev)
+			return true
+		case <-keepAlive.C:
+			c.SSEvent("ping", "")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package endpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readEvent returns the next event name and data of the stream
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		require.Nil(t, err)
+		line = strings.TrimRight(line, "\n")
+		if line == "" && event != "" {
+			return event, data
+		}
+		if strings.HasPrefix(line, "event:") {
+			event = line[len("event:"):]
+		} else if strings.HasPrefix(line, "data:") {
+			data = line[len("data:"):]
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	api, sto := newTestApi(t)
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	addr1, err := common.AddressFromString("HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2")
+	require.Nil(t, err)
+	storage.SetAccount(sto, addr0, &storage.Account{Balance: 10})
+
+	resp, err := http.Get(server.URL + "/events?addr=" + addr1.String())
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	event, _ := readEvent(t, r)
+	assert.Equal(t, "subscribed", event)
+
+	tx := common.NewTx(addr0, addr1, 4, 0)
+	require.Nil(t, sk0.SignTx(tx))
+	w := doRequest(api, "POST", "/tx", `{"txHex": "`+tx.Hex()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	event, data := readEvent(t, r)
+	assert.Equal(t, EventIncoming, event)
+	var ev AccountEvent
+	require.Nil(t, json.Unmarshal([]byte(data), &ev))
+	assert.Equal(t, addr1, ev.Addr)
+	assert.Equal(t, uint64(1), ev.Height)
+	assert.Equal(t, uint64(4), ev.Balance)
+	assert.Equal(t, tx, ev.Tx)
+
+	w = doRequest(api, "GET", "/events", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
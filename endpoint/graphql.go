@@ -0,0 +1,624 @@
+package endpoint
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// graphqlMaxComplexity is the max cost of a GraphQL query, see
+// queryComplexity
+var graphqlMaxComplexity = 5000
+
+// graphqlMaxDepth is the max nesting of the fields of a GraphQL query
+const graphqlMaxDepth = 12
+
+// graphqlCostPerRequest is the query complexity charged as one request of
+// the rate limit, so a query of graphqlMaxComplexity counts as 50 requests
+const graphqlCostPerRequest = 100
+
+// txs of a connection when first is not given, and the max first
+const (
+	graphqlDefaultFirst = 20
+	graphqlMaxFirst     = 100
+)
+
+type GraphQLMsg struct {
+	Query         string                 `json:"query" binding:"required"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// the values resolved by the GraphQL types
+type gqlAccount struct {
+	addr common.Address
+}
+
+type gqlBlock struct {
+	height uint64
+}
+
+// gqlTx is a tx of the archive, with the hash, height and index it was
+// stored with
+type gqlTx struct {
+	tx       *common.Tx
+	hash     []byte
+	archived *storage.ArchivedTx
+}
+
+func newGqlTx(archived *storage.ArchivedTx) *gqlTx {
+	return &gqlTx{tx: archived.Tx, hash: archived.Hash, archived: archived}
+}
+
+type gqlEdge struct {
+	cursor string
+	node   *gqlTx
+}
+
+type gqlConnection struct {
+	totalCount  uint64
+	edges       []gqlEdge
+	hasNextPage bool
+}
+
+func encodeCursor(n uint64) string {
+	return base64.StdEncoding.EncodeToString([]byte("tx:" + strconv.FormatUint(n, 10)))
+}
+
+func decodeCursor(cursor string) (uint64, error) {
+	b, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(b), "tx:") {
+		return 0, errors.New("invalid cursor")
+	}
+	n, err := strconv.ParseUint(string(b[len("tx:"):]), 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid cursor")
+	}
+	return n, nil
+}
+
+// pageArgs returns the first and after arguments of a connection field.
+// after is the position after which the page starts, and ok is false if
+// there is no after.
+func pageArgs(args map[string]interface{}) (first int, after uint64, ok bool, err error) {
+	first = graphqlDefaultFirst
+	if v, found := args["first"]; found {
+		first = v.(int)
+		if first < 0 || first > graphqlMaxFirst {
+			return 0, 0, false, fmt.Errorf("first must be between 0 and %d", graphqlMaxFirst)
+		}
+	}
+	if v, found := args["after"]; found {
+		after, err = decodeCursor(v.(string))
+		if err != nil {
+			return 0, 0, false, err
+		}
+		ok = true
+	}
+	return first, after, ok, nil
+}
+
+// accountTxs returns the txs of the address history, from the newest to the
+// oldest. The cursor is the position in the history.
+func accountTxs(addr common.Address, args map[string]interface{}) (*gqlConnection, error) {
+	first, after, ok, err := pageArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	count, err := storage.GetTxCount(archiveDb, addr)
+	if err != nil {
+		return nil, err
+	}
+	// end is the position of the newest tx of the page, plus one
+	end := count
+	if ok {
+		if after >= count {
+			return nil, errors.New("invalid cursor")
+		}
+		end = after
+	}
+	conn := &gqlConnection{totalCount: count}
+	for n := end; n > 0 && len(conn.edges) < first; n-- {
+		archived, err := storage.GetArchivedTx(archiveDb, addr, n-1)
+		if err != nil {
+			return nil, err
+		}
+		conn.edges = append(conn.edges, gqlEdge{cursor: encodeCursor(n - 1), node: newGqlTx(archived)})
+	}
+	conn.hasNextPage = end > uint64(len(conn.edges))
+	return conn, nil
+}
+
+// blockTxs returns the txs of the block in order. The cursor is the index in
+// the block.
+func blockTxs(height uint64, args map[string]interface{}) (*gqlConnection, error) {
+	first, after, ok, err := pageArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := storage.GetBlockTxs(archiveDb, height)
+	if err != nil {
+		return nil, err
+	}
+	var start uint64
+	if ok {
+		if after >= uint64(len(txs)) {
+			return nil, errors.New("invalid cursor")
+		}
+		start = after + 1
+	}
+	conn := &gqlConnection{totalCount: uint64(len(txs))}
+	for i := start; i < uint64(len(txs)) && len(conn.edges) < first; i++ {
+		conn.edges = append(conn.edges, gqlEdge{cursor: encodeCursor(i), node: newGqlTx(&txs[i])})
+	}
+	conn.hasNextPage = start+uint64(len(conn.edges)) < uint64(len(txs))
+	return conn, nil
+}
+
+// getBlock returns the block if it is in the archive
+func getBlock(height uint64) (*gqlBlock, error) {
+	archiveHeight, err := storage.GetArchiveHeight(archiveDb)
+	if err != nil {
+		return nil, err
+	}
+	if height == 0 || height > archiveHeight {
+		return nil, nil
+	}
+	return &gqlBlock{height: height}, nil
+}
+
+func parseUint64(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		if v >= 0 {
+			return uint64(v)
+		}
+	case float64:
+		if v >= 0 && v == float64(uint64(v)) {
+			return uint64(v)
+		}
+	case string:
+		if n, err := strconv.ParseUint(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// uint64Type is serialized as a JSON number, as in the REST api, as the
+// balances don't fit in the 32 bits of Int
+var uint64Type = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Uint64",
+	Description: "Unsigned 64 bits integer",
+	Serialize: func(v interface{}) interface{} {
+		return v
+	},
+	ParseValue: parseUint64,
+	ParseLiteral: func(v ast.Value) interface{} {
+		switch v := v.(type) {
+		case *ast.IntValue:
+			return parseUint64(v.Value)
+		case *ast.StringValue:
+			return parseUint64(v.Value)
+		}
+		return nil
+	},
+})
+
+var connectionArgs = graphql.FieldConfigArgument{
+	"first": &graphql.ArgumentConfig{
+		Type:        graphql.Int,
+		Description: fmt.Sprintf("number of txs, %d by default and at most %d", graphqlDefaultFirst, graphqlMaxFirst),
+	},
+	"after": &graphql.ArgumentConfig{
+		Type:        graphql.String,
+		Description: "cursor of the edge after which the page starts",
+	},
+}
+
+var graphqlSchema graphql.Schema
+
+func init() {
+	var accountType, txType, blockType *graphql.Object
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*gqlConnection).hasNextPage, nil
+				},
+			},
+			"endCursor": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					conn := p.Source.(*gqlConnection)
+					if len(conn.edges) == 0 {
+						return nil, nil
+					}
+					return conn.edges[len(conn.edges)-1].cursor, nil
+				},
+			},
+		},
+	})
+	txEdgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TxEdge",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"cursor": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(gqlEdge).cursor, nil
+					},
+				},
+				"node": &graphql.Field{
+					Type: graphql.NewNonNull(txType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(gqlEdge).node, nil
+					},
+				},
+			}
+		}),
+	})
+	txConnectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TxConnection",
+		Fields: graphql.Fields{
+			"totalCount": &graphql.Field{
+				Type: graphql.NewNonNull(uint64Type),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*gqlConnection).totalCount, nil
+				},
+			},
+			"edges": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(txEdgeType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*gqlConnection).edges, nil
+				},
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(pageInfoType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	accountType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Account",
+		Fields: graphql.Fields{
+			"address": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*gqlAccount).addr.String(), nil
+				},
+			},
+			"balance": &graphql.Field{
+				Type: graphql.NewNonNull(uint64Type),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return storage.GetBalance(db, p.Source.(*gqlAccount).addr)
+				},
+			},
+			"nonce": &graphql.Field{
+				Type:        graphql.NewNonNull(uint64Type),
+				Description: "next nonce",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return storage.GetNonce(db, p.Source.(*gqlAccount).addr)
+				},
+			},
+			"txs": &graphql.Field{
+				Type:        graphql.NewNonNull(txConnectionType),
+				Description: "archived txs, from the newest",
+				Args:        connectionArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return accountTxs(p.Source.(*gqlAccount).addr, p.Args)
+				},
+			},
+		},
+	})
+
+	txType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Tx",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"hash": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return fmt.Sprintf("%X", p.Source.(*gqlTx).hash), nil
+					},
+				},
+				"type": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "0 for a transfer, 1 for a mint",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return int(p.Source.(*gqlTx).tx.Type), nil
+					},
+				},
+				"from": &graphql.Field{
+					Type: graphql.NewNonNull(accountType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return &gqlAccount{addr: p.Source.(*gqlTx).tx.From}, nil
+					},
+				},
+				"to": &graphql.Field{
+					Type: graphql.NewNonNull(accountType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return &gqlAccount{addr: p.Source.(*gqlTx).tx.To}, nil
+					},
+				},
+				"amount": &graphql.Field{
+					Type: graphql.NewNonNull(uint64Type),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(*gqlTx).tx.Amount, nil
+					},
+				},
+				"nonce": &graphql.Field{
+					Type: graphql.NewNonNull(uint64Type),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(*gqlTx).tx.Nonce, nil
+					},
+				},
+				"signature": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return hex.EncodeToString(p.Source.(*gqlTx).tx.Signature), nil
+					},
+				},
+				"index": &graphql.Field{
+					Type:        graphql.Int,
+					Description: "position in the block",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return int(p.Source.(*gqlTx).archived.Index), nil
+					},
+				},
+				"block": &graphql.Field{
+					Type: blockType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return &gqlBlock{height: p.Source.(*gqlTx).archived.Height}, nil
+					},
+				},
+			}
+		}),
+	})
+
+	blockType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Block",
+		Fields: graphql.Fields{
+			"height": &graphql.Field{
+				Type: graphql.NewNonNull(uint64Type),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*gqlBlock).height, nil
+				},
+			},
+			"txs": &graphql.Field{
+				Type: graphql.NewNonNull(txConnectionType),
+				Args: connectionArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return blockTxs(p.Source.(*gqlBlock).height, p.Args)
+				},
+			},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"account": &graphql.Field{
+				Type: accountType,
+				Args: graphql.FieldConfigArgument{
+					"address": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					addr, err := common.AddressFromString(p.Args["address"].(string))
+					if err != nil {
+						return nil, err
+					}
+					return &gqlAccount{addr: addr}, nil
+				},
+			},
+			"tx": &graphql.Field{
+				Type: txType,
+				Args: graphql.FieldConfigArgument{
+					"hash": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					hash, err := hex.DecodeString(p.Args["hash"].(string))
+					if err != nil {
+						return nil, err
+					}
+					archived, err := storage.GetTxByHash(archiveDb, hash)
+					if err != nil || archived == nil {
+						return nil, err
+					}
+					return newGqlTx(archived), nil
+				},
+			},
+			"block": &graphql.Field{
+				Type: blockType,
+				Args: graphql.FieldConfigArgument{
+					"height": &graphql.ArgumentConfig{Type: graphql.NewNonNull(uint64Type)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return getBlock(p.Args["height"].(uint64))
+				},
+			},
+			"height": &graphql.Field{
+				Type:        graphql.NewNonNull(uint64Type),
+				Description: "height of the last archived block",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return storage.GetArchiveHeight(archiveDb)
+				},
+			},
+		},
+	})
+
+	var err error
+	graphqlSchema, err = graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// queryComplexity returns the cost of the operations of the query. Each
+// field costs 1, and the fields of a tx connection are counted once for each
+// of its first txs, so nested connections multiply their costs. The cost
+// stops at graphqlMaxComplexity+1, so it can not overflow, and the queries
+// with fields nested deeper than graphqlMaxDepth fail.
+func queryComplexity(doc *ast.Document, vars map[string]interface{}) (int, error) {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, def := range doc.Definitions {
+		if f, ok := def.(*ast.FragmentDefinition); ok {
+			fragments[f.Name.Value] = f
+		}
+	}
+	limit := graphqlMaxComplexity + 1
+	// add and mul saturate at limit, their arguments are at most limit
+	add := func(a, b int) int {
+		if a+b > limit {
+			return limit
+		}
+		return a + b
+	}
+	mul := func(a, b int) int {
+		if a != 0 && b > limit/a {
+			return limit
+		}
+		return a * b
+	}
+	var cost func(set *ast.SelectionSet, visited map[string]bool, depth int) (int, error)
+	cost = func(set *ast.SelectionSet, visited map[string]bool, depth int) (int, error) {
+		if set == nil {
+			return 0, nil
+		}
+		if depth > graphqlMaxDepth {
+			return 0, fmt.Errorf("query depth is over the limit of %d", graphqlMaxDepth)
+		}
+		total := 0
+		for _, sel := range set.Selections {
+			var n int
+			var err error
+			switch sel := sel.(type) {
+			case *ast.Field:
+				n, err = cost(sel.SelectionSet, visited, depth+1)
+				n = add(1, mul(fieldMultiplier(sel, vars), n))
+			case *ast.InlineFragment:
+				n, err = cost(sel.SelectionSet, visited, depth)
+			case *ast.FragmentSpread:
+				f, ok := fragments[sel.Name.Value]
+				if !ok || visited[sel.Name.Value] {
+					continue
+				}
+				visited[sel.Name.Value] = true
+				n, err = cost(f.SelectionSet, visited, depth)
+				delete(visited, sel.Name.Value)
+			}
+			if err != nil {
+				return 0, err
+			}
+			total = add(total, n)
+		}
+		return total, nil
+	}
+	total := 0
+	for _, def := range doc.Definitions {
+		if op, ok := def.(*ast.OperationDefinition); ok {
+			n, err := cost(op.SelectionSet, make(map[string]bool), 1)
+			if err != nil {
+				return 0, err
+			}
+			total = add(total, n)
+		}
+	}
+	return total, nil
+}
+
+// fieldMultiplier returns the number of txs of a connection field, and 1 for
+// the other fields
+func fieldMultiplier(field *ast.Field, vars map[string]interface{}) int {
+	if field.Name.Value != "txs" {
+		return 1
+	}
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "first" {
+			continue
+		}
+		var v interface{}
+		switch value := arg.Value.(type) {
+		case *ast.IntValue:
+			v = value.Value
+		case *ast.Variable:
+			v = vars[value.Name.Value]
+		}
+		// invalid values fail in the execution, the max is counted here
+		if n, ok := parseUint64(v).(uint64); ok && n <= graphqlMaxFirst {
+			return int(n)
+		}
+		return graphqlMaxFirst
+	}
+	return graphqlDefaultFirst
+}
+
+// handleGraphQL executes a GraphQL query over the state and the archive. The
+// result has the data and errors of the GraphQL response, and the queries
+// that can't be parsed or exceed graphqlMaxComplexity or graphqlMaxDepth are
+// rejected with 400.
+// Each graphqlCostPerRequest of complexity is charged to the rate limit.
+func handleGraphQL(c *gin.Context) {
+	var m GraphQLMsg
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	doc, err := parser.Parse(parser.ParseParams{Source: m.Query})
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	complexity, err := queryComplexity(doc, m.Variables)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if complexity > graphqlMaxComplexity {
+		c.JSON(400, gin.H{
+			"error": fmt.Sprintf("query complexity is over the limit of %d", graphqlMaxComplexity),
+		})
+		return
+	}
+	// the request itself was already charged
+	if !chargeRateLimit(c, (complexity-1)/graphqlCostPerRequest) {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": fmt.Sprintf("rate limit exceeded, the query complexity %d counts as %d requests",
+				complexity, (complexity-1)/graphqlCostPerRequest+1),
+		})
+		return
+	}
+
+	res := graphql.Do(graphql.Params{
+		Schema:         graphqlSchema,
+		RequestString:  m.Query,
+		VariableValues: m.Variables,
+		OperationName:  m.OperationName,
+		Context:        c.Request.Context(),
+	})
+	c.JSON(200, res)
+}
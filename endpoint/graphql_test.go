@@ -0,0 +1,170 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type graphqlResult struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func doGraphQL(t *testing.T, api *gin.Engine, query string, variables map[string]interface{}) graphqlResult {
+	body, err := json.Marshal(GraphQLMsg{Query: query, Variables: variables})
+	require.Nil(t, err)
+	w := doRequest(api, "POST", "/graphql", string(body))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var res graphqlResult
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	return res
+}
+
+func TestGraphQL(t *testing.T) {
+	api, sto := newTestApi(t)
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	addr1, err := common.AddressFromString("HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2")
+	require.Nil(t, err)
+	storage.SetAccount(sto, addr0, &storage.Account{Balance: 10})
+
+	var hashes []string
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		tx := common.NewTx(addr0, addr1, nonce+1, nonce)
+		require.Nil(t, sk0.SignTx(tx))
+		txHex := tx.Hex()
+		// the hash is the one of the hex as broadcasted
+		if nonce == 2 {
+			txHex = strings.ToUpper(txHex)
+		}
+		w := doRequest(api, "POST", "/tx", `{"txHex": "`+txHex+`"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		var res PostTxResultMsg
+		require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		hashes = append(hashes, res.Hash)
+	}
+
+	// account -> last txs -> counterparty balances
+	query := `query($addr: String!, $first: Int, $after: String) {
+		account(address: $addr) {
+			balance
+			nonce
+			txs(first: $first, after: $after) {
+				totalCount
+				edges { cursor node { hash amount block { height } to { address balance } } }
+				pageInfo { hasNextPage endCursor }
+			}
+		}
+	}`
+	res := doGraphQL(t, api, query, map[string]interface{}{"addr": addr0.String(), "first": 2})
+	require.Empty(t, res.Errors)
+	account := res.Data["account"].(map[string]interface{})
+	assert.Equal(t, float64(4), account["balance"])
+	assert.Equal(t, float64(3), account["nonce"])
+	txs := account["txs"].(map[string]interface{})
+	assert.Equal(t, float64(3), txs["totalCount"])
+	edges := txs["edges"].([]interface{})
+	require.Equal(t, 2, len(edges))
+	node := edges[0].(map[string]interface{})["node"].(map[string]interface{})
+	assert.Equal(t, hashes[2], node["hash"])
+	assert.Equal(t, float64(3), node["amount"])
+	assert.Equal(t, float64(3), node["block"].(map[string]interface{})["height"])
+	assert.Equal(t, float64(6), node["to"].(map[string]interface{})["balance"])
+	pageInfo := txs["pageInfo"].(map[string]interface{})
+	assert.Equal(t, true, pageInfo["hasNextPage"])
+
+	// next page
+	res = doGraphQL(t, api, query, map[string]interface{}{"addr": addr0.String(), "after": pageInfo["endCursor"]})
+	require.Empty(t, res.Errors)
+	txs = res.Data["account"].(map[string]interface{})["txs"].(map[string]interface{})
+	edges = txs["edges"].([]interface{})
+	require.Equal(t, 1, len(edges))
+	node = edges[0].(map[string]interface{})["node"].(map[string]interface{})
+	assert.Equal(t, hashes[0], node["hash"])
+	assert.Equal(t, false, txs["pageInfo"].(map[string]interface{})["hasNextPage"])
+
+	res = doGraphQL(t, api, `{ height tx(hash: "`+hashes[1]+`") { nonce from { address } block { txs { totalCount } } } block(height: 9) { height } }`, nil)
+	require.Empty(t, res.Errors)
+	assert.Equal(t, float64(3), res.Data["height"])
+	tx := res.Data["tx"].(map[string]interface{})
+	assert.Equal(t, float64(1), tx["nonce"])
+	assert.Equal(t, addr0.String(), tx["from"].(map[string]interface{})["address"])
+	assert.Nil(t, res.Data["block"])
+
+	res = doGraphQL(t, api, `{ account(address: "invalid") { balance } }`, nil)
+	assert.NotEmpty(t, res.Errors)
+
+	w := doRequest(api, "POST", "/graphql", `{"query": "{ account("}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGraphQLComplexity(t *testing.T) {
+	api, _ := newTestApi(t)
+
+	// 1 + 100 * (1 + 1 + 100 * 1) is over the limit
+	query := `{ block(height: 1) { txs(first: 100) { edges { node { block { txs(first: 100) { totalCount } } } } } } }`
+	w := doRequest(api, "POST", "/graphql", `{"query": "`+query+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "complexity")
+
+	// fragments and variables are counted
+	query = `query($n: Int) { block(height: 1) { txs(first: $n) { ...deep } } }
+		fragment deep on TxConnection { edges { node { block { txs(first: 100) { totalCount } } } } }`
+	body, err := json.Marshal(GraphQLMsg{Query: query, Variables: map[string]interface{}{"n": 100}})
+	require.Nil(t, err)
+	w = doRequest(api, "POST", "/graphql", string(body))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	body, err = json.Marshal(GraphQLMsg{Query: query, Variables: map[string]interface{}{"n": 2}})
+	require.Nil(t, err)
+	w = doRequest(api, "POST", "/graphql", string(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the cost of nested connections saturates instead of overflowing
+	nested := "totalCount"
+	for i := 0; i < 2; i++ {
+		nested = "txs(first: 100) { edges { node { from { " + nested + " } } } }"
+	}
+	doc, err := parser.Parse(parser.ParseParams{Source: `{ account(address: "x") { ` + nested + ` } }`})
+	require.Nil(t, err)
+	complexity, err := queryComplexity(doc, nil)
+	require.Nil(t, err)
+	assert.Equal(t, graphqlMaxComplexity+1, complexity)
+
+	// and the deeply nested queries are rejected
+	for i := 2; i < 11; i++ {
+		nested = "txs(first: 100) { edges { node { from { " + nested + " } } } }"
+	}
+	body, err = json.Marshal(GraphQLMsg{Query: `{ account(address: "x") { ` + nested + ` } }`})
+	require.Nil(t, err)
+	w = doRequest(api, "POST", "/graphql", string(body))
+	assert.Contains(t, w.Body.String(), "depth")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGraphQLRateLimit(t *testing.T) {
+	api := newTestApiWithConfig(t, &Config{IPRateLimit: RateLimit{Rate: 0.001, Burst: 10}})
+
+	// 1 + 1 + 100 * (1 + 1 + 3) counts as 6 requests
+	query := `{ block(height: 1) { txs(first: 100) { edges { node { hash amount nonce } } } } }`
+	w := doRequest(api, "POST", "/graphql", `{"query": "`+query+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(api, "POST", "/graphql", `{"query": "`+query+`"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	// the cheap queries still fit in the bucket
+	w = doRequest(api, "POST", "/graphql", `{"query": "{ height }"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,241 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/sha256"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"kvartalochain/common"
+	"kvartalochain/endpoint/pb"
+
+	tmtypes "github.com/tendermint/tendermint/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcScopes are the scopes of the gRPC methods, as the ones of the REST
+// endpoints
+var grpcScopes = map[string]string{
+	"/kvartalochain.Kvartalo/GetBalance":      ScopeRead,
+	"/kvartalochain.Kvartalo/GetNonce":        ScopeRead,
+	"/kvartalochain.Kvartalo/GetHistory":      ScopeRead,
+	"/kvartalochain.Kvartalo/SubscribeEvents": ScopeRead,
+	"/kvartalochain.Kvartalo/SubmitTx":        ScopeSubmit,
+}
+
+// grpcCode returns the gRPC code of an http status
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict, http.StatusUnprocessableEntity:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+func grpcError(err error) error {
+	return status.Error(grpcCode(errorStatus(err)), err.Error())
+}
+
+func pbTx(tx *common.Tx) *pb.Tx {
+	return &pb.Tx{
+		Type:      uint32(tx.Type),
+		From:      tx.From.String(),
+		To:        tx.To.String(),
+		Amount:    tx.Amount,
+		Nonce:     tx.Nonce,
+		Signature: tx.Signature,
+	}
+}
+
+func pbTxResult(r *TxResultMsg) *pb.TxResult {
+	if r == nil {
+		return nil
+	}
+	return &pb.TxResult{Code: r.Code, Log: r.Log}
+}
+
+type grpcService struct{}
+
+func (s *grpcService) GetBalance(ctx context.Context, req *pb.AddressRequest) (*pb.BalanceResponse, error) {
+	res, err := getBalance(req.Addr)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.BalanceResponse{Addr: res.Addr.String(), Balance: res.Balance}, nil
+}
+
+func (s *grpcService) GetNonce(ctx context.Context, req *pb.NonceRequest) (*pb.NonceResponse, error) {
+	res, err := getNonce(req.Addr, req.Pending)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.NonceResponse{Addr: res.Addr.String(), Nonce: res.Nonce}, nil
+}
+
+func (s *grpcService) GetHistory(req *pb.AddressRequest, stream pb.Kvartalo_GetHistoryServer) error {
+	txs, err := getHistory(req.Addr)
+	if err != nil {
+		return grpcError(err)
+	}
+	for i := range txs {
+		if err := stream.Send(pbTx(&txs[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SubmitTx returns the result codes in the response, as POST /tx does in the
+// body, and only fails when the tx can not be sent to the node
+func (s *grpcService) SubmitTx(ctx context.Context, req *pb.SubmitTxRequest) (*pb.SubmitTxResponse, error) {
+	res, _, err := submitTx(tmtypes.Tx(req.TxHex), req.Mode)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.SubmitTxResponse{
+		Hash:      res.Hash,
+		Mode:      res.Mode,
+		CheckTx:   pbTxResult(res.CheckTx),
+		DeliverTx: pbTxResult(res.DeliverTx),
+		Height:    res.Height,
+	}, nil
+}
+
+func (s *grpcService) SubscribeEvents(req *pb.SubscribeRequest, stream pb.Kvartalo_SubscribeEventsServer) error {
+	sub, _, err := subscribeEvents(req.Addrs)
+	if err != nil {
+		return grpcError(err)
+	}
+	defer hub.unsubscribe(sub)
+	for {
+		select {
+		case ev, ok := <-sub.ch:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "subscriber too slow")
+			}
+			err := stream.Send(&pb.AccountEvent{
+				Type:    ev.Type,
+				Addr:    ev.Addr.String(),
+				Height:  ev.Height,
+				Hash:    ev.Hash,
+				Tx:      pbTx(ev.Tx),
+				Balance: ev.Balance,
+			})
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// grpcAuth checks the api key and the rate limit of the gRPC calls with the
+// rules of the REST api. The key is sent in the x-api-key or authorization
+// metadata.
+type grpcAuth struct {
+	cfg   *Config
+	keys  map[[32]byte]*APIKey
+	byKey *rateLimiter
+	byIP  *rateLimiter
+}
+
+func newGrpcAuth(cfg *Config) *grpcAuth {
+	return &grpcAuth{
+		cfg:   cfg,
+		keys:  keyIndex(cfg),
+		byKey: newRateLimiter(cfg.KeyRateLimit),
+		byIP:  newRateLimiter(cfg.IPRateLimit),
+	}
+}
+
+func (a *grpcAuth) check(ctx context.Context, method string) error {
+	var key string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(strings.ToLower(APIKEYHEADER)); len(v) > 0 {
+		key = v[0]
+	} else if v := md.Get("authorization"); len(v) > 0 {
+		key = bearerKey(v[0])
+	}
+	apiKey, validKey := a.keys[sha256.Sum256([]byte(key))]
+
+	// the calls with unknown keys are charged to their ip, so that the
+	// keys can not be guessed faster than the ip limit
+	var allowed bool
+	if validKey {
+		allowed = a.byKey.allow(apiKey.Key, time.Now())
+	} else {
+		var ip string
+		if p, ok := peer.FromContext(ctx); ok {
+			ip = p.Addr.String()
+			if host, _, err := net.SplitHostPort(ip); err == nil {
+				ip = host
+			}
+		}
+		allowed = a.byIP.allow(ip, time.Now())
+	}
+	if !allowed {
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	if key != "" && !validKey {
+		return status.Error(codes.Unauthenticated, "invalid api key")
+	}
+
+	scope, ok := grpcScopes[method]
+	if !ok {
+		return status.Error(codes.Unimplemented, "unknown method "+method)
+	}
+	if err := checkScope(a.cfg, apiKey, scope); err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+func (a *grpcAuth) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.check(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *grpcAuth) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.check(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// ServeGRPC returns the gRPC server of the api, with the stores and the node
+// client set by Serve, which has to be called before. A nil cfg serves an
+// open api.
+func ServeGRPC(cfg *Config) *grpc.Server {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	auth := newGrpcAuth(cfg)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.unary),
+		grpc.StreamInterceptor(auth.stream),
+	)
+	pb.RegisterKvartaloServer(server, &grpcService{})
+	return server
+}
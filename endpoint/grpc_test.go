@@ -0,0 +1,127 @@
+package endpoint
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"kvartalochain/common"
+	"kvartalochain/endpoint/pb"
+	"kvartalochain/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestGrpcClient serves the gRPC api of the last Serve in memory
+func newTestGrpcClient(t *testing.T, cfg *Config) pb.KvartaloClient {
+	lis := bufconn.Listen(1 << 20)
+	server := ServeGRPC(cfg)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewKvartaloClient(conn)
+}
+
+func TestGrpc(t *testing.T) {
+	_, sto := newTestApi(t)
+	client := newTestGrpcClient(t, nil)
+	ctx := context.Background()
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	addr1, err := common.AddressFromString("HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2")
+	require.Nil(t, err)
+	storage.SetAccount(sto, addr0, &storage.Account{Balance: 10})
+
+	balance, err := client.GetBalance(ctx, &pb.AddressRequest{Addr: addr0.String()})
+	require.Nil(t, err)
+	assert.Equal(t, uint64(10), balance.Balance)
+	_, err = client.GetBalance(ctx, &pb.AddressRequest{Addr: "invalid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	events, err := client.SubscribeEvents(ctx, &pb.SubscribeRequest{Addrs: []string{addr1.String()}})
+	require.Nil(t, err)
+	require.Eventually(t, func() bool { return hub.subscribed(addr1) }, time.Second, 10*time.Millisecond)
+
+	tx := common.NewTx(addr0, addr1, 4, 0)
+	require.Nil(t, sk0.SignTx(tx))
+	res, err := client.SubmitTx(ctx, &pb.SubmitTxRequest{TxHex: tx.Hex()})
+	require.Nil(t, err)
+	assert.Equal(t, BroadcastCommit, res.Mode)
+	assert.Equal(t, uint32(0), res.DeliverTx.Code)
+	assert.Equal(t, int64(1), res.Height)
+
+	ev, err := events.Recv()
+	require.Nil(t, err)
+	assert.Equal(t, EventIncoming, ev.Type)
+	assert.Equal(t, addr1.String(), ev.Addr)
+	assert.Equal(t, res.Hash, ev.Hash)
+	assert.Equal(t, uint64(4), ev.Balance)
+
+	// a rejected tx is a response with its code, as in POST /tx
+	noFunds := common.NewTx(addr0, addr1, 100, 1)
+	require.Nil(t, sk0.SignTx(noFunds))
+	res, err = client.SubmitTx(ctx, &pb.SubmitTxRequest{TxHex: noFunds.Hex(), Mode: BroadcastSync})
+	require.Nil(t, err)
+	assert.NotEqual(t, uint32(0), res.CheckTx.Code)
+	_, err = client.SubmitTx(ctx, &pb.SubmitTxRequest{TxHex: tx.Hex(), Mode: "wrong"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	nonce, err := client.GetNonce(ctx, &pb.NonceRequest{Addr: addr0.String(), Pending: true})
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), nonce.Nonce)
+
+	history, err := client.GetHistory(ctx, &pb.AddressRequest{Addr: addr1.String()})
+	require.Nil(t, err)
+	var txs []*pb.Tx
+	for {
+		tx, err := history.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		txs = append(txs, tx)
+	}
+	require.Equal(t, 1, len(txs))
+	assert.Equal(t, addr0.String(), txs[0].From)
+	assert.Equal(t, uint64(4), txs[0].Amount)
+}
+
+func TestGrpcAuth(t *testing.T) {
+	newTestApi(t)
+	client := newTestGrpcClient(t, &Config{
+		Keys: []APIKey{
+			{Name: "reader", Key: "readkey", Scopes: []string{ScopeRead}},
+		},
+	})
+	addr := &pb.AddressRequest{Addr: "DqF1B6iqaxeE3j4XvyPfLbba6QkQfQtwSUWBJmnQRMvN"}
+
+	_, err := client.GetBalance(context.Background(), addr)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "wrong")
+	_, err = client.GetBalance(ctx, addr)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer readkey")
+	_, err = client.GetBalance(ctx, addr)
+	assert.Nil(t, err)
+	_, err = client.SubmitTx(ctx, &pb.SubmitTxRequest{TxHex: "00"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
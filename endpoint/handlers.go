@@ -1,114 +1,278 @@
 package endpoint
 
 import (
+	"errors"
 	"fmt"
+	"kvartalochain/chain"
 	"kvartalochain/common"
 	"kvartalochain/storage"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	tmtypes "github.com/tendermint/tendermint/types"
 )
 
+// The handlers are shared by the REST and the gRPC apis: each get* or
+// submit* function does the work, and returns an apiError with the http
+// status when it fails. The gin handlers and the gRPC methods only
+// translate the requests and the responses.
+
+// apiError is an error of a handler with its http status
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+func newApiError(status int, err error) error {
+	return &apiError{status: status, err: err}
+}
+
+// errorStatus returns the http status of err, 500 if it is not an apiError
+func errorStatus(err error) int {
+	if e, ok := err.(*apiError); ok {
+		return e.status
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(c *gin.Context, err error) {
+	if errors.Is(err, errPartialMempool) {
+		c.Header("Retry-After", mempoolRetryAfter)
+	}
+	c.JSON(errorStatus(err), gin.H{
+		"error": err.Error(),
+	})
+}
+
 type GetBalanceMsg struct {
 	Addr    common.Address `json:"addr"`
 	Balance uint64         `json:"balance"`
 }
 
-func handleInfo(c *gin.Context) {
-	c.JSON(200, gin.H{
-		"status": "ok",
-	})
+type NonceMsg struct {
+	Addr  common.Address `json:"addr"`
+	Nonce uint64         `json:"nonce"`
 }
 
-func handleGetBalance(c *gin.Context) {
-	addrStr := c.Param("addr")
-
+func parseAddr(addrStr string) (common.Address, error) {
 	addr, err := common.AddressFromString(addrStr)
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error": err.Error(),
-		})
+		return addr, newApiError(http.StatusBadRequest, err)
 	}
-	fmt.Println("get balance addr", addr, addr.String())
-	balance := storage.GetBalance(db, addr)
+	return addr, nil
+}
+
+func getBalance(addrStr string) (*GetBalanceMsg, error) {
+	addr, err := parseAddr(addrStr)
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error": err.Error(),
-		})
-		return
+		return nil, err
 	}
-
-	c.JSON(200, GetBalanceMsg{
+	logger.Debug("get balance", "addr", addr.String())
+	// the errors of the state are not the fault of the request, and are 500
+	balance, err := storage.GetBalance(db, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &GetBalanceMsg{
 		Addr:    addr,
 		Balance: balance,
-	})
+	}, nil
 }
 
-func handleGetNonce(c *gin.Context) {
-	addrStr := c.Param("addr")
+func handleGetBalance(c *gin.Context) {
+	res, err := getBalance(c.Param("addr"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(200, res)
+}
 
-	addr, err := common.AddressFromString(addrStr)
+// getNonce returns the next nonce of the address. With pending, the txs of
+// the address in the mempool are counted.
+func getNonce(addrStr string, pending bool) (*NonceMsg, error) {
+	addr, err := parseAddr(addrStr)
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error": err.Error(),
-		})
+		return nil, err
 	}
-	fmt.Println("get nonce addr", addr, addr.String())
-	nonce := storage.GetNonce(db, addr)
+	logger.Debug("get nonce", "addr", addr.String())
+	nonce, err := storage.GetNonce(db, addr)
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error": err.Error(),
-		})
-		return
+		return nil, err
 	}
+	if pending {
+		nonce, err = pendingNonce(addr, nonce)
+		if err != nil {
+			return nil, mempoolError(err)
+		}
+	}
+	return &NonceMsg{
+		Addr:  addr,
+		Nonce: nonce,
+	}, nil
+}
 
-	c.JSON(200, gin.H{
-		"addr":  addr,
-		"nonce": nonce,
-	})
+func handleGetNonce(c *gin.Context) {
+	res, err := getNonce(c.Param("addr"), c.Query("pending") == "true")
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(200, res)
 }
 
 type PostTxMsg struct {
-	TxHex string `json:"txHex"`
+	TxHex string `json:"txHex" binding:"required"`
+	// Mode is the broadcast mode: "async" returns without waiting for
+	// the CheckTx, "sync" waits for the CheckTx, and "commit" (default)
+	// waits until the tx is in a block
+	Mode string `json:"mode"`
+}
+
+type TxResultMsg struct {
+	Code uint32 `json:"code"`
+	Log  string `json:"log"`
+}
+
+type PostTxResultMsg struct {
+	Hash      string       `json:"hash"`
+	Mode      string       `json:"mode"`
+	CheckTx   *TxResultMsg `json:"checkTx,omitempty"`
+	DeliverTx *TxResultMsg `json:"deliverTx,omitempty"`
+	Height    int64        `json:"height,omitempty"`
+}
+
+const (
+	BroadcastAsync  = "async"
+	BroadcastSync   = "sync"
+	BroadcastCommit = "commit"
+)
+
+// codeStatus returns the http status for a CheckTx or DeliverTx result code
+func codeStatus(code uint32) int {
+	switch code {
+	case 0:
+		return http.StatusOK
+	case chain.ERRFORMAT, chain.ERRSIG:
+		return http.StatusBadRequest
+	case chain.ERRNONCE:
+		return http.StatusConflict
+	case chain.ERRNOFUNDS:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 func handlePostTx(c *gin.Context) {
 	var m PostTxMsg
-	c.BindJSON(&m)
-	_, err := http.Get("http://127.0.0.1:26657" + `/broadcast_tx_commit?tx="` + m.TxHex + `"`)
-	if err != nil {
+	if err := c.ShouldBindJSON(&m); err != nil {
 		c.JSON(400, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-	c.JSON(200, gin.H{
-		"status": "ok",
-	})
+	broadcastTx(c, tmtypes.Tx(m.TxHex), m.Mode)
+}
+
+// broadcastTx submits the tx and writes the PostTxResultMsg
+func broadcastTx(c *gin.Context, tx tmtypes.Tx, mode string) {
+	res, status, err := submitTx(tx, mode)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(status, res)
+}
+
+// submitTx sends the tx to the node with the broadcast mode, "commit" if
+// empty, and returns the result with its http status
+func submitTx(tx tmtypes.Tx, mode string) (*PostTxResultMsg, int, error) {
+	if mode == "" {
+		mode = BroadcastCommit
+	}
+	res := &PostTxResultMsg{
+		Hash: fmt.Sprintf("%X", tx.Hash()),
+		Mode: mode,
+	}
 
+	switch mode {
+	case BroadcastAsync, BroadcastSync:
+		broadcast := tmClient.BroadcastTxSync
+		if mode == BroadcastAsync {
+			broadcast = tmClient.BroadcastTxAsync
+		}
+		r, err := broadcast(tx)
+		if err != nil {
+			return nil, 0, newApiError(http.StatusBadGateway, err)
+		}
+		status := http.StatusAccepted
+		if mode == BroadcastSync {
+			res.CheckTx = &TxResultMsg{Code: r.Code, Log: r.Log}
+			if r.Code != 0 {
+				status = codeStatus(r.Code)
+				track(tx, TxRejected, 0, r.Code, r.Log)
+			} else {
+				track(tx, TxMempool, 0, 0, "")
+			}
+		} else {
+			track(tx, TxPending, 0, 0, "")
+		}
+		return res, status, nil
+	case BroadcastCommit:
+		r, err := tmClient.BroadcastTxCommit(tx)
+		if err != nil {
+			return nil, 0, newApiError(http.StatusBadGateway, err)
+		}
+		res.CheckTx = &TxResultMsg{Code: r.CheckTx.Code, Log: r.CheckTx.Log}
+		if r.CheckTx.Code != 0 {
+			track(tx, TxRejected, 0, r.CheckTx.Code, r.CheckTx.Log)
+			return res, codeStatus(r.CheckTx.Code), nil
+		}
+		res.DeliverTx = &TxResultMsg{Code: r.DeliverTx.Code, Log: r.DeliverTx.Log}
+		res.Height = r.Height
+		if r.DeliverTx.Code != 0 {
+			track(tx, TxRejected, r.Height, r.DeliverTx.Code, r.DeliverTx.Log)
+		} else {
+			track(tx, TxCommitted, r.Height, 0, "")
+		}
+		return res, codeStatus(r.DeliverTx.Code), nil
+	default:
+		return nil, 0, newApiError(http.StatusBadRequest, errors.New("invalid mode: "+mode))
+	}
 }
 
-func handleGetHistory(c *gin.Context) {
-	addrStr := c.Param("addr")
-	addr, err := common.AddressFromString(addrStr)
+// getHistory returns the archived txs of the address
+func getHistory(addrStr string) ([]common.Tx, error) {
+	addr, err := parseAddr(addrStr)
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error": err.Error(),
-		})
+		return nil, err
 	}
 	txCount, err := storage.GetTxCount(archiveDb, addr)
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error": err.Error(),
-		})
+		return nil, newApiError(http.StatusBadRequest, err)
 	}
 	txs, err := storage.GetAddressHistory(archiveDb, addr, txCount)
 	if err != nil {
-		c.JSON(400, gin.H{
-			"error": err.Error(),
-		})
+		return nil, newApiError(http.StatusBadRequest, err)
 	}
+	return txs, nil
+}
 
+func handleGetHistory(c *gin.Context) {
+	txs, err := getHistory(c.Param("addr"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
 	c.JSON(200, gin.H{
 		"txs": txs,
 	})
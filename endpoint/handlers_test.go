@@ -0,0 +1,474 @@
+package endpoint
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kvartalochain/chain"
+	"kvartalochain/common"
+	"kvartalochain/storage"
+	"kvartalochain/webhook"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+func newTestApi(t *testing.T) (*gin.Engine, storage.StateDB) {
+	gin.SetMode(gin.TestMode)
+	sto, err := storage.NewMemStorage()
+	require.Nil(t, err)
+	archive := storage.NewMemArchive()
+	app := chain.NewKvartaloApplication(sto, archive)
+	app.OnCommit(PublishCommit)
+	client := NewMockNodeClient(app)
+	txTracker, err := NewTracker(archive, client)
+	require.Nil(t, err)
+	app.OnCommit(txTracker.OnCommit)
+	return Serve(app, sto, archive, client, webhook.NewNotifier(archive), txTracker, nil), sto
+}
+
+func doRequest(api *gin.Engine, method, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	return w
+}
+
+func TestGetBalance(t *testing.T) {
+	api, sto := newTestApi(t)
+
+	addr, err := common.AddressFromString("DqF1B6iqaxeE3j4XvyPfLbba6QkQfQtwSUWBJmnQRMvN")
+	require.Nil(t, err)
+	storage.SetAccount(sto, addr, &storage.Account{Balance: 10})
+
+	w := doRequest(api, "GET", "/balance/"+addr.String(), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var msg GetBalanceMsg
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &msg))
+	assert.Equal(t, addr, msg.Addr)
+	assert.Equal(t, uint64(10), msg.Balance)
+
+	w = doRequest(api, "GET", "/balance/invalid", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// an account that can not be read is an error of the node
+	sto.Set(append(append([]byte{}, storage.PREFIXACCOUNT...), addr[:]...), []byte{0xff})
+	w = doRequest(api, "GET", "/balance/"+addr.String(), "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = doRequest(api, "GET", "/nonce/"+addr.String(), "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestPostTxInvalid(t *testing.T) {
+	api, _ := newTestApi(t)
+
+	w := doRequest(api, "POST", "/tx", "{}")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(api, "POST", "/tx", `{"txHex": "00", "mode": "wrong"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPostTx(t *testing.T) {
+	api, sto := newTestApi(t)
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	addr1, err := common.AddressFromString("HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2")
+	require.Nil(t, err)
+	storage.SetAccount(sto, addr0, &storage.Account{Balance: 10})
+
+	tx := common.NewTx(addr0, addr1, 4, 0)
+	require.Nil(t, sk0.SignTx(tx))
+	w := doRequest(api, "POST", "/tx", `{"txHex": "`+tx.Hex()+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res PostTxResultMsg
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, BroadcastCommit, res.Mode)
+	assert.Equal(t, uint32(0), res.DeliverTx.Code)
+	assert.Equal(t, int64(1), res.Height)
+	assert.Equal(t, 64, len(res.Hash))
+	balance, err := storage.GetBalance(sto, addr1)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(4), balance)
+
+	// not enough funds
+	tx = common.NewTx(addr0, addr1, 20, 1)
+	require.Nil(t, sk0.SignTx(tx))
+	w = doRequest(api, "POST", "/tx", `{"txHex": "`+tx.Hex()+`", "mode": "sync"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	res = PostTxResultMsg{}
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, chain.ERRNOFUNDS, res.CheckTx.Code)
+	assert.Equal(t, "not enough funds", res.CheckTx.Log)
+
+	// nonce already used
+	tx = common.NewTx(addr0, addr1, 1, 0)
+	require.Nil(t, sk0.SignTx(tx))
+	w = doRequest(api, "POST", "/tx", `{"txHex": "`+tx.Hex()+`"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestWebhooks(t *testing.T) {
+	// the webhooks always need an admin key
+	api, _ := newTestApi(t)
+	addr := "HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2"
+	body := `{"addr": "` + addr + `", "url": "http://203.0.113.10/paid", "secret": "s"}`
+	w := doRequest(api, "POST", "/webhooks", body)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	api = newTestApiWithConfig(t, &Config{Keys: []APIKey{{Name: "operator", Key: "k-admin", Scopes: []string{ScopeAdmin}}}})
+	doRequest := func(api *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+		return doRequestHeaders(api, method, path, body, map[string]string{APIKEYHEADER: "k-admin"})
+	}
+	// the callbacks can not reach the network of the node
+	w = doRequest(api, "POST", "/webhooks", `{"addr": "`+addr+`", "url": "http://127.0.0.1:8080/paid", "secret": "s"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(api, "POST", "/webhooks", body)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var hook webhook.Webhook
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &hook))
+	assert.Equal(t, "", hook.Secret)
+
+	w = doRequest(api, "GET", "/webhooks?addr="+addr, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Webhooks []webhook.Webhook `json:"webhooks"`
+	}
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, []webhook.Webhook{hook}, list.Webhooks)
+
+	w = doRequest(api, "GET", "/webhooks/"+hook.ID+"/deliveries", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(api, "DELETE", "/webhooks/"+hook.ID, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(api, "DELETE", "/webhooks/"+hook.ID, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = doRequest(api, "GET", "/webhooks/"+hook.ID+"/deliveries", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSupply(t *testing.T) {
+	api, sto := newTestApi(t)
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	addr1, err := common.AddressFromString("HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2")
+	require.Nil(t, err)
+
+	mint := common.NewTx(addr0, addr0, 30, 0)
+	mint.Type = common.TxTypeMint
+	require.Nil(t, sk0.SignTx(mint))
+	w := doRequest(api, "POST", "/tx", `{"txHex": "`+mint.Hex()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	tx := common.NewTx(addr0, addr1, 10, 1)
+	require.Nil(t, sk0.SignTx(tx))
+	w = doRequest(api, "POST", "/tx", `{"txHex": "`+tx.Hex()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(api, "GET", "/supply", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var supply GetSupplyMsg
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &supply))
+	assert.Equal(t, GetSupplyMsg{Minted: 30, Circulating: 30, Accounts: 2}, supply)
+
+	w = doRequest(api, "GET", "/accounts/top?n=1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var top GetTopAccountsMsg
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &top))
+	assert.Equal(t, uint64(2), top.Accounts)
+	assert.Equal(t, []storage.Holder{{Addr: addr0, Balance: 20}}, top.Top)
+
+	// the holders are computed once per height
+	storage.SetAccount(sto, addr1, &storage.Account{Balance: 40})
+	w = doRequest(api, "GET", "/accounts/top?n=3", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &top))
+	assert.Equal(t, []storage.Holder{{Addr: addr0, Balance: 20}, {Addr: addr1, Balance: 10}}, top.Top)
+	tx = common.NewTx(addr0, addr1, 5, 2)
+	require.Nil(t, sk0.SignTx(tx))
+	w = doRequest(api, "POST", "/tx", `{"txHex": "`+tx.Hex()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(api, "GET", "/accounts/top?n=3", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &top))
+	assert.Equal(t, []storage.Holder{{Addr: addr1, Balance: 45}, {Addr: addr0, Balance: 15}}, top.Top)
+
+	w = doRequest(api, "GET", "/accounts/top?n=0", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestStats(t *testing.T) {
+	api, _ := newTestApi(t)
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	addr1, err := common.AddressFromString("HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2")
+	require.Nil(t, err)
+
+	mint := common.NewTx(addr0, addr0, 40, 0)
+	mint.Type = common.TxTypeMint
+	require.Nil(t, sk0.SignTx(mint))
+	w := doRequest(api, "POST", "/tx", `{"txHex": "`+mint.Hex()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	for nonce := uint64(1); nonce <= 2; nonce++ {
+		tx := common.NewTx(addr0, addr1, 5, nonce)
+		require.Nil(t, sk0.SignTx(tx))
+		w = doRequest(api, "POST", "/tx", `{"txHex": "`+tx.Hex()+`"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	for _, interval := range []string{storage.IntervalDay, storage.IntervalWeek} {
+		w = doRequest(api, "GET", "/stats?interval="+interval, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var res struct {
+			Stats []storage.Stats `json:"stats"`
+		}
+		require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.Equal(t, 30, len(res.Stats))
+		last := res.Stats[len(res.Stats)-1]
+		assert.Equal(t, uint64(2), last.Transfers)
+		assert.Equal(t, uint64(10), last.Volume)
+		assert.Equal(t, uint64(1), last.Senders)
+		assert.Equal(t, uint64(1), last.Receivers)
+		assert.Equal(t, uint64(2), last.NewAccounts)
+		assert.Equal(t, uint64(40), last.Minted)
+		assert.Equal(t, uint64(40), last.Supply)
+		assert.Equal(t, 0.25, last.Velocity)
+		assert.Equal(t, uint64(0), res.Stats[0].Transfers)
+	}
+
+	w = doRequest(api, "GET", "/stats?interval=month", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(api, "GET", "/stats?from=2020-01-01&to=2030-01-01", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSimulateTx(t *testing.T) {
+	api, sto := newTestApi(t)
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	addr1, err := common.AddressFromString("HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2")
+	require.Nil(t, err)
+	storage.SetAccount(sto, addr0, &storage.Account{Balance: 10})
+	_, err = sto.Commit()
+	require.Nil(t, err)
+	// the changes of the block being delivered are not seen
+	storage.SetAccount(sto, addr0, &storage.Account{Balance: 100})
+
+	tx := common.NewTx(addr0, addr1, 4, 0)
+	require.Nil(t, sk0.SignTx(tx))
+	w := doRequest(api, "POST", "/tx/simulate", `{"txHex": "`+tx.Hex()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var res SimulateTxResultMsg
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, uint32(0), res.Code)
+	assert.Equal(t, []GetBalanceMsg{{addr0, 6}, {addr1, 4}}, res.Balances)
+
+	// the state is not modified
+	acc, err := storage.GetAccount(sto, addr0)
+	require.Nil(t, err)
+	assert.Equal(t, &storage.Account{Balance: 100}, acc)
+	assert.False(t, storage.AccountExists(sto, addr1))
+
+	tx = common.NewTx(addr0, addr1, 20, 0)
+	require.Nil(t, sk0.SignTx(tx))
+	w = doRequest(api, "POST", "/tx/simulate", `{"txHex": "`+tx.Hex()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	res = SimulateTxResultMsg{}
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, chain.ERRNOFUNDS, res.Code)
+	assert.Equal(t, "not enough funds", res.Log)
+	assert.Nil(t, res.Balances)
+
+	w = doRequest(api, "POST", "/tx/simulate", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPendingNonce(t *testing.T) {
+	api, sto := newTestApi(t)
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	addr1, err := common.AddressFromString("HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2")
+	require.Nil(t, err)
+	storage.SetAccount(sto, addr0, &storage.Account{Balance: 10, Nonce: 2})
+
+	mock := tmClient.(*MockNodeClient)
+	for _, nonce := range []uint64{2, 3, 5} {
+		tx := common.NewTx(addr0, addr1, 1, nonce)
+		require.Nil(t, sk0.SignTx(tx))
+		mock.Mempool = append(mock.Mempool, tmtypes.Tx(tx.Hex()))
+	}
+	mock.Mempool = append(mock.Mempool, tmtypes.Tx("invalid"))
+
+	var res struct {
+		Nonce uint64 `json:"nonce"`
+	}
+	w := doRequest(api, "GET", "/nonce/"+addr0.String(), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, uint64(2), res.Nonce)
+	w = doRequest(api, "GET", "/nonce/"+addr0.String()+"?pending=true", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	// the tx with nonce 5 can not be delivered until there is a tx with
+	// nonce 4
+	assert.Equal(t, uint64(4), res.Nonce)
+
+	var mempool struct {
+		Txs     []PendingTxMsg `json:"txs"`
+		Partial bool           `json:"partial"`
+	}
+	w = doRequest(api, "GET", "/mempool/"+addr1.String(), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &mempool))
+	require.Equal(t, 3, len(mempool.Txs))
+	assert.Equal(t, uint64(5), mempool.Txs[2].Tx.Nonce)
+	assert.Equal(t, 64, len(mempool.Txs[0].Hash))
+	assert.False(t, mempool.Partial)
+
+	w = doRequest(api, "GET", "/nonce/invalid?pending=true", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the node only returns the first txs of a large mempool
+	for len(mock.Mempool) <= mempoolLimit {
+		mock.Mempool = append(mock.Mempool, tmtypes.Tx("invalid"))
+	}
+	w = doRequest(api, "GET", "/mempool/"+addr1.String(), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &mempool))
+	assert.True(t, mempool.Partial)
+	// the requests that need the whole mempool are retried later
+	w = doRequest(api, "GET", "/nonce/"+addr0.String()+"?pending=true", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, mempoolRetryAfter, w.Header().Get("Retry-After"))
+	w = doRequest(api, "POST", "/txs", `{"txs": []}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, mempoolRetryAfter, w.Header().Get("Retry-After"))
+	w = doRequest(api, "POST", "/tx/build", `{"from": "`+addr0.String()+`", "to": "`+addr1.String()+`", "amount": 1}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, mempoolRetryAfter, w.Header().Get("Retry-After"))
+
+	// the errors of the node are not retried
+	mock.Mempool = nil
+	mock.MempoolErr = errors.New("connection refused")
+	w = doRequest(api, "GET", "/nonce/"+addr0.String()+"?pending=true", "")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Empty(t, w.Header().Get("Retry-After"))
+}
+
+func TestBuildAndSubmitTx(t *testing.T) {
+	api, sto := newTestApi(t)
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	addr1, err := common.AddressFromString("HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2")
+	require.Nil(t, err)
+	storage.SetAccount(sto, addr0, &storage.Account{Balance: 10, Nonce: 3})
+
+	fields := `"from": "` + addr0.String() + `", "to": "` + addr1.String() + `", "amount": 4`
+	w := doRequest(api, "POST", "/tx/build", `{`+fields+`}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var built BuildTxResultMsg
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &built))
+	assert.Equal(t, uint64(3), built.Tx.Nonce)
+	expected := common.NewTx(addr0, addr1, 4, 3)
+	assert.Equal(t, hex.EncodeToString(expected.Bytes()), built.TxBytes)
+
+	// the client only signs the hash
+	sigHash, err := hex.DecodeString(built.SigHash)
+	require.Nil(t, err)
+	sig, err := btcec.SignCompact(btcec.S256(), sk0.PrivateKey, sigHash, false)
+	require.Nil(t, err)
+
+	w = doRequest(api, "POST", "/tx/submit", `{`+fields+`, "nonce": 3, "signature": "00"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(api, "POST", "/tx/submit", `{`+fields+`, "signature": "`+hex.EncodeToString(sig)+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(api, "POST", "/tx/submit", `{`+fields+`, "nonce": 3, "signature": "`+hex.EncodeToString(sig)+`"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var res PostTxResultMsg
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, uint32(0), res.DeliverTx.Code)
+	balance, err := storage.GetBalance(sto, addr1)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(4), balance)
+
+	w = doRequest(api, "POST", "/tx/build", `{`+fields+`, "type": 7}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPostTxs(t *testing.T) {
+	api, sto := newTestApi(t)
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	sk1 := common.ImportKeyString("8h3u7NfgvUJsHJgKDUKwwVL1iZd3cwRtntpTfJ5Mefz2")
+	addr1 := sk1.Public().Address()
+	storage.SetAccount(sto, addr0, &storage.Account{Balance: 10})
+	storage.SetAccount(sto, addr1, &storage.Account{Balance: 10, Nonce: 4})
+
+	signed := func(sk *common.PrivateKey, to common.Address, nonce uint64) string {
+		tx := common.NewTx(sk.Public().Address(), to, 1, nonce)
+		require.Nil(t, sk.SignTx(tx))
+		return `"` + tx.Hex() + `"`
+	}
+	body := `{"txs": [` + strings.Join([]string{
+		signed(sk0, addr1, 0),
+		signed(sk1, addr0, 4),
+		signed(sk0, addr1, 1),
+		signed(sk1, addr0, 6), // nonce gap
+		signed(sk1, addr0, 7),
+		`"00"`,
+	}, ",") + `]}`
+	w := doRequest(api, "POST", "/txs", body)
+	require.Equal(t, http.StatusOK, w.Code)
+	var res struct {
+		Txs []BatchTxResultMsg `json:"txs"`
+	}
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	require.Equal(t, 6, len(res.Txs))
+	var statuses []string
+	for _, r := range res.Txs {
+		statuses = append(statuses, r.Status)
+	}
+	assert.Equal(t, []string{BatchTxBroadcast, BatchTxBroadcast, BatchTxBroadcast,
+		BatchTxInvalid, BatchTxInvalid, BatchTxInvalid}, statuses)
+	assert.Equal(t, "invalid nonce 6, expected 5", res.Txs[3].Error)
+
+	// the broadcasted txs can be polled
+	w = doRequest(api, "GET", "/tx/"+res.Txs[2].Hash, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var committed TxMsg
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &committed))
+	assert.Equal(t, uint64(1), committed.Tx.Nonce)
+	assert.Equal(t, uint64(3), committed.Height)
+	w = doRequest(api, "GET", "/tx/"+res.Txs[3].Hash, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = doRequest(api, "GET", "/tx/xyz", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the txs after a failed broadcast of the sender are skipped
+	mock := tmClient.(*MockNodeClient)
+	mock.BroadcastErr = errors.New("connection refused")
+	body = `{"txs": [` + strings.Join([]string{
+		signed(sk0, addr1, 2),
+		signed(sk0, addr1, 3),
+	}, ",") + `]}`
+	w = doRequest(api, "POST", "/txs", body)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	require.Equal(t, 2, len(res.Txs))
+	assert.Equal(t, BatchTxError, res.Txs[0].Status)
+	assert.Equal(t, "connection refused", res.Txs[0].Error)
+	assert.Equal(t, BatchTxSkipped, res.Txs[1].Status)
+}
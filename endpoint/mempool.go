@@ -0,0 +1,145 @@
+package endpoint
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/gin-gonic/gin"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// mempoolLimit is the number of mempool txs requested to the node, which is
+// the maximum allowed by Tendermint
+const mempoolLimit = 100
+
+// errPartialMempool is returned when the pending nonces can not be known, as
+// the node only returns the first mempoolLimit txs of the mempool
+var errPartialMempool = fmt.Errorf("the mempool has more than %d txs, the pending nonce is unknown", mempoolLimit)
+
+// mempoolRetryAfter is the Retry-After of the responses that need the whole
+// mempool while it is partial, the time of about a block
+const mempoolRetryAfter = "1"
+
+// mempoolError returns the apiError of an error reading the mempool: 503 for
+// errPartialMempool, which clears as the txs are committed, and 502 for the
+// errors of the node
+func mempoolError(err error) error {
+	if err == errPartialMempool {
+		return newApiError(http.StatusServiceUnavailable, err)
+	}
+	return newApiError(http.StatusBadGateway, err)
+}
+
+// PendingTxMsg is a tx waiting in the mempool
+type PendingTxMsg struct {
+	Hash string     `json:"hash"`
+	Tx   *common.Tx `json:"tx"`
+}
+
+// decodeTx decodes a raw Tendermint tx, which is the hex of the tx bytes
+func decodeTx(txRaw []byte) (*common.Tx, error) {
+	txBytes, err := hex.DecodeString(string(txRaw))
+	if err != nil {
+		return nil, err
+	}
+	return common.TxFromBytes(txBytes)
+}
+
+// mempoolTxs returns the txs in the mempool, and whether they are only a
+// part of it. Tendermint returns at most mempoolLimit txs, without paging.
+func mempoolTxs() ([]tmtypes.Tx, bool, error) {
+	res, err := tmClient.UnconfirmedTxs(mempoolLimit)
+	if err != nil {
+		return nil, false, err
+	}
+	return res.Txs, res.Count < res.Total, nil
+}
+
+// pendingTxs returns the txs in the mempool sent or received by addr, and
+// whether only a part of the mempool was read. Txs that can not be decoded
+// are skipped.
+func pendingTxs(addr common.Address) ([]PendingTxMsg, bool, error) {
+	mempool, partial, err := mempoolTxs()
+	if err != nil {
+		return nil, false, err
+	}
+	txs := []PendingTxMsg{}
+	for _, txRaw := range mempool {
+		tx, err := decodeTx(txRaw)
+		if err != nil {
+			continue
+		}
+		if tx.From != addr && tx.To != addr {
+			continue
+		}
+		txs = append(txs, PendingTxMsg{
+			Hash: fmt.Sprintf("%X", storage.TxHash(txRaw)),
+			Tx:   tx,
+		})
+	}
+	return txs, partial, nil
+}
+
+// nextNonce returns the nonce that follows the consecutive nonces of the txs
+// sent by addr, starting at the committed nonce
+func nextNonce(txs []*common.Tx, addr common.Address, committed uint64) uint64 {
+	nonces := make(map[uint64]bool)
+	for _, tx := range txs {
+		if tx.From == addr {
+			nonces[tx.Nonce] = true
+		}
+	}
+	nonce := committed
+	for nonces[nonce] {
+		nonce++
+	}
+	return nonce
+}
+
+// pendingNonce returns the next nonce of addr after its txs in the mempool,
+// which is the committed nonce when addr has no consecutive txs in the
+// mempool. It fails with errPartialMempool if the mempool can not be fully
+// read.
+func pendingNonce(addr common.Address, committed uint64) (uint64, error) {
+	pending, partial, err := pendingTxs(addr)
+	if err != nil {
+		return 0, err
+	}
+	if partial {
+		return 0, errPartialMempool
+	}
+	txs := make([]*common.Tx, len(pending))
+	for i := range pending {
+		txs[i] = pending[i].Tx
+	}
+	return nextNonce(txs, addr, committed), nil
+}
+
+// handleGetMempool returns the txs of the address waiting in the mempool.
+// partial is true when the mempool is larger than what the node returns, so
+// some txs of the address may be missing.
+func handleGetMempool(c *gin.Context) {
+	addr, err := common.AddressFromString(c.Param("addr"))
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	txs, partial, err := pendingTxs(addr)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"addr":    addr,
+		"txs":     txs,
+		"partial": partial,
+	})
+}
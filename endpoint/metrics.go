@@ -0,0 +1,69 @@
+package endpoint
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	kitprom "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsSubsystem is the subsystem of the api metrics, under the
+// Tendermint instrumentation namespace
+const MetricsSubsystem = "api"
+
+// Metrics of the api requests, by method, route and status
+type Metrics struct {
+	Requests        metrics.Counter
+	RequestDuration metrics.Histogram
+}
+
+// PrometheusMetrics returns the Metrics registered in reg
+func PrometheusMetrics(reg stdprometheus.Registerer, namespace string) *Metrics {
+	labels := []string{"method", "route", "status"}
+	requests := stdprometheus.NewCounterVec(stdprometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: MetricsSubsystem,
+		Name:      "requests_total",
+		Help:      "Number of api requests.",
+	}, labels)
+	duration := stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: MetricsSubsystem,
+		Name:      "request_duration_seconds",
+		Help:      "Time to answer the api requests.",
+		Buckets:   stdprometheus.DefBuckets,
+	}, labels)
+	reg.MustRegister(requests, duration)
+	return &Metrics{
+		Requests:        kitprom.NewCounter(requests),
+		RequestDuration: kitprom.NewHistogram(duration),
+	}
+}
+
+// NopMetrics returns Metrics that discard the values
+func NopMetrics() *Metrics {
+	return &Metrics{
+		Requests:        discard.NewCounter(),
+		RequestDuration: discard.NewHistogram(),
+	}
+}
+
+// instrument records the Metrics of each request. The route is the gin path,
+// so that the path parameters do not create new series.
+func instrument(m *Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		lvs := []string{"method", c.Request.Method, "route", route, "status", strconv.Itoa(c.Writer.Status())}
+		m.Requests.With(lvs...).Add(1)
+		m.RequestDuration.With(lvs...).Observe(time.Since(start).Seconds())
+	}
+}
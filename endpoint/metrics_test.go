@@ -0,0 +1,41 @@
+package endpoint
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	api := newTestApiWithConfig(t, &Config{Metrics: PrometheusMetrics(reg, "test")})
+
+	w := doRequest(api, "GET", "/balance/DqF1B6iqaxeE3j4XvyPfLbba6QkQfQtwSUWBJmnQRMvN", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(api, "GET", "/balance/invalid", "")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(api, "GET", "/nothing", "")
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// the series are by route, not by path
+	expected := `
+# HELP test_api_requests_total Number of api requests.
+# TYPE test_api_requests_total counter
+test_api_requests_total{method="GET",route="/balance/:addr",status="200"} 1
+test_api_requests_total{method="GET",route="/balance/:addr",status="400"} 1
+test_api_requests_total{method="GET",route="unmatched",status="404"} 1
+`
+	assert.Nil(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "test_api_requests_total"))
+	families, err := reg.Gather()
+	require.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() == "test_api_request_duration_seconds" {
+			assert.Equal(t, 3, len(family.Metric))
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package endpoint
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"sync"
+	"time"
+
+	abci "github.com/tendermint/tendermint/abci/types"
+	nm "github.com/tendermint/tendermint/node"
+	rpchttp "github.com/tendermint/tendermint/rpc/client/http"
+	rpclocal "github.com/tendermint/tendermint/rpc/client/local"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+	tmversion "github.com/tendermint/tendermint/version"
+)
+
+// NodeClient is used to talk with the Tendermint node
+type NodeClient interface {
+	BroadcastTxAsync(tx tmtypes.Tx) (*ctypes.ResultBroadcastTx, error)
+	BroadcastTxSync(tx tmtypes.Tx) (*ctypes.ResultBroadcastTx, error)
+	BroadcastTxCommit(tx tmtypes.Tx) (*ctypes.ResultBroadcastTxCommit, error)
+	// UnconfirmedTxs returns up to limit txs of the mempool, Tendermint
+	// returns at most 100
+	UnconfirmedTxs(limit int) (*ctypes.ResultUnconfirmedTxs, error)
+	NumUnconfirmedTxs() (*ctypes.ResultUnconfirmedTxs, error)
+	Status() (*ctypes.ResultStatus, error)
+	NetInfo() (*ctypes.ResultNetInfo, error)
+}
+
+// NewHTTPNodeClient returns a NodeClient that uses the RPC of the node at
+// nodeurl, in the form http://127.0.0.1:26657
+func NewHTTPNodeClient(nodeurl string) (NodeClient, error) {
+	return rpchttp.New(nodeurl, "/websocket")
+}
+
+// NewLocalNodeClient returns a NodeClient that calls the RPC core of a node
+// running in the same process
+func NewLocalNodeClient(node *nm.Node) NodeClient {
+	return rpclocal.New(node)
+}
+
+// MockNodeClient is a NodeClient without Tendermint node, to be used in
+// tests. Each broadcasted tx that passes the CheckTx is delivered in its own
+// block to the abci.Application.
+type MockNodeClient struct {
+	mutex  sync.Mutex
+	app    abci.Application
+	height int64
+	// Txs are the broadcasted txs
+	Txs []tmtypes.Tx
+	// Mempool are the txs returned by UnconfirmedTxs, as the broadcasted
+	// txs are delivered without waiting in a mempool
+	Mempool []tmtypes.Tx
+	// CatchingUp and Peers are returned by Status and NetInfo
+	CatchingUp bool
+	Peers      int
+	// BroadcastErr is returned by the broadcasts if set, without
+	// broadcasting the tx
+	BroadcastErr error
+	// MempoolErr is returned by UnconfirmedTxs if set
+	MempoolErr error
+}
+
+var _ NodeClient = (*MockNodeClient)(nil)
+
+func NewMockNodeClient(app abci.Application) *MockNodeClient {
+	return &MockNodeClient{app: app}
+}
+
+func (m *MockNodeClient) broadcast(tx tmtypes.Tx) (abci.ResponseCheckTx, abci.ResponseDeliverTx, int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Txs = append(m.Txs, tx)
+
+	checkTx := m.app.CheckTx(abci.RequestCheckTx{Tx: tx})
+	if checkTx.Code != 0 {
+		return checkTx, abci.ResponseDeliverTx{}, 0
+	}
+	m.height++
+	hash := mockBlockHash(m.height)
+	m.app.BeginBlock(abci.RequestBeginBlock{
+		Hash: hash[:],
+		Header: abci.Header{
+			Height:      m.height,
+			Time:        time.Now(),
+			LastBlockId: abci.BlockID{Hash: mockParentHash(m.height)},
+		},
+	})
+	deliverTx := m.app.DeliverTx(abci.RequestDeliverTx{Tx: tx})
+	m.app.EndBlock(abci.RequestEndBlock{Height: m.height})
+	m.app.Commit()
+	return checkTx, deliverTx, m.height
+}
+
+func (m *MockNodeClient) BroadcastTxAsync(tx tmtypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+	if m.BroadcastErr != nil {
+		return nil, m.BroadcastErr
+	}
+	m.broadcast(tx)
+	return &ctypes.ResultBroadcastTx{Hash: tx.Hash()}, nil
+}
+
+func (m *MockNodeClient) BroadcastTxSync(tx tmtypes.Tx) (*ctypes.ResultBroadcastTx, error) {
+	if m.BroadcastErr != nil {
+		return nil, m.BroadcastErr
+	}
+	checkTx, _, _ := m.broadcast(tx)
+	return &ctypes.ResultBroadcastTx{
+		Code: checkTx.Code,
+		Log:  checkTx.Log,
+		Hash: tx.Hash(),
+	}, nil
+}
+
+func (m *MockNodeClient) BroadcastTxCommit(tx tmtypes.Tx) (*ctypes.ResultBroadcastTxCommit, error) {
+	if m.BroadcastErr != nil {
+		return nil, m.BroadcastErr
+	}
+	checkTx, deliverTx, height := m.broadcast(tx)
+	return &ctypes.ResultBroadcastTxCommit{
+		CheckTx:   checkTx,
+		DeliverTx: deliverTx,
+		Hash:      tx.Hash(),
+		Height:    height,
+	}, nil
+}
+
+func (m *MockNodeClient) UnconfirmedTxs(limit int) (*ctypes.ResultUnconfirmedTxs, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.MempoolErr != nil {
+		return nil, m.MempoolErr
+	}
+	txs := m.Mempool
+	if len(txs) > limit {
+		txs = txs[:limit]
+	}
+	return &ctypes.ResultUnconfirmedTxs{
+		Count: len(txs),
+		Total: len(m.Mempool),
+		Txs:   txs,
+	}, nil
+}
+
+// mockBlockHash is the hash of the blocks of the MockNodeClient
+func mockBlockHash(height int64) [32]byte {
+	return sha256.Sum256([]byte(strconv.FormatInt(height, 10)))
+}
+
+func mockParentHash(height int64) []byte {
+	if height == 1 {
+		return nil
+	}
+	hash := mockBlockHash(height - 1)
+	return hash[:]
+}
+
+func (m *MockNodeClient) NumUnconfirmedTxs() (*ctypes.ResultUnconfirmedTxs, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return &ctypes.ResultUnconfirmedTxs{
+		Count: len(m.Mempool),
+		Total: len(m.Mempool),
+	}, nil
+}
+
+func (m *MockNodeClient) Status() (*ctypes.ResultStatus, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var status ctypes.ResultStatus
+	status.NodeInfo.Network = "mock"
+	status.NodeInfo.Version = tmversion.TMCoreSemVer
+	status.SyncInfo.LatestBlockHeight = m.height
+	status.SyncInfo.CatchingUp = m.CatchingUp
+	if m.height > 0 {
+		hash := mockBlockHash(m.height)
+		status.SyncInfo.LatestBlockHash = hash[:]
+	}
+	return &status, nil
+}
+
+func (m *MockNodeClient) NetInfo() (*ctypes.ResultNetInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return &ctypes.ResultNetInfo{Listening: true, NPeers: m.Peers}, nil
+}
@@ -0,0 +1,428 @@
+package endpoint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// obj is a node of the OpenAPI document
+type obj = map[string]interface{}
+
+func ref(schema string) obj {
+	return obj{"$ref": "#/components/schemas/" + schema}
+}
+
+func arrayOf(items obj) obj {
+	return obj{"type": "array", "items": items}
+}
+
+func object(properties obj, required ...string) obj {
+	o := obj{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		o["required"] = required
+	}
+	return o
+}
+
+func str(description string) obj {
+	return obj{"type": "string", "description": description}
+}
+
+func uint64Schema(description string) obj {
+	return obj{"type": "integer", "format": "int64", "minimum": 0, "description": description}
+}
+
+func uint32Schema(description string) obj {
+	return obj{"type": "integer", "format": "int32", "minimum": 0, "description": description}
+}
+
+// param is a path or query parameter
+type param struct {
+	name        string
+	in          string
+	description string
+	schema      obj
+}
+
+func pathParam(name, description string, schema obj) param {
+	return param{name: name, in: "path", description: description, schema: schema}
+}
+
+func queryParam(name, description string, schema obj) param {
+	return param{name: name, in: "query", description: description, schema: schema}
+}
+
+// apiOperation describes a route of the api. The path is in gin format.
+type apiOperation struct {
+	method  string
+	path    string
+	summary string
+	// scope is the api key scope of the route, empty for the public routes
+	scope  string
+	params []param
+	// body is the name of the request body schema, if any
+	body string
+	// status and response are the success status and its schema
+	status   int
+	response obj
+	// accepted is true for the routes that can answer 202 with the response
+	// schema
+	accepted bool
+	// errors are the http statuses of the errors of the route, besides the
+	// auth and rate limit ones
+	errors []int
+}
+
+var apiOperations = []apiOperation{
+	{method: "GET", path: "/openapi.json", summary: "OpenAPI document of the api",
+		status: 200, response: obj{"type": "object"}},
+	{method: "GET", path: "/healthz", summary: "Liveness probe, fails while the node is down or syncing",
+		status: 200, response: ref("HealthMsg"), errors: []int{503}},
+	{method: "GET", path: "/readyz", summary: "Readiness probe, fails also while the state or the archive are behind the node",
+		status: 200, response: ref("HealthMsg"), errors: []int{503}},
+	{method: "GET", path: "/info", summary: "Node status", scope: ScopeRead,
+		status: 200, response: ref("InfoMsg"), errors: []int{500, 502}},
+	{method: "GET", path: "/balance/:addr", summary: "Balance of an address", scope: ScopeRead,
+		params: []param{addrParam},
+		status: 200, response: ref("GetBalanceMsg"), errors: []int{400, 500}},
+	{method: "GET", path: "/nonce/:addr", summary: "Next nonce of an address", scope: ScopeRead,
+		params: []param{addrParam,
+			queryParam("pending", "if true, counts the txs of the address in the mempool", obj{"type": "boolean"})},
+		status: 200, response: ref("NonceMsg"), errors: []int{400, 500, 502, 503}},
+	{method: "GET", path: "/mempool/:addr", summary: "Txs of an address waiting in the mempool", scope: ScopeRead,
+		params: []param{addrParam},
+		status: 200, response: object(obj{"addr": ref("Address"), "txs": arrayOf(ref("PendingTxMsg")),
+			"partial": obj{"type": "boolean", "description": "true if the mempool is larger than what the node returns, and some txs may be missing"}}),
+		errors: []int{400, 502}},
+	{method: "POST", path: "/tx/simulate", summary: "Run a tx against the current state without broadcasting it", scope: ScopeRead,
+		body:   "SimulateTxMsg",
+		status: 200, response: ref("SimulateTxResultMsg"), errors: []int{400, 500}},
+	{method: "POST", path: "/tx/build", summary: "Build an unsigned tx and the hash to sign", scope: ScopeRead,
+		body:   "BuildTxMsg",
+		status: 200, response: ref("BuildTxResultMsg"), errors: []int{400, 500, 502, 503}},
+	{method: "GET", path: "/tx/:hash", summary: "Committed tx", scope: ScopeRead,
+		params: []param{hashParam},
+		status: 200, response: ref("TxMsg"), errors: []int{400, 404, 500}},
+	{method: "GET", path: "/tx/:hash/status", summary: "State of a submitted tx", scope: ScopeRead,
+		params: []param{hashParam},
+		status: 200, response: ref("TrackedTx"), errors: []int{400, 404, 500}},
+	{method: "GET", path: "/history/:addr", summary: "Archived txs of an address", scope: ScopeRead,
+		params: []param{addrParam},
+		status: 200, response: object(obj{"txs": arrayOf(ref("Tx"))}), errors: []int{400}},
+	{method: "GET", path: "/supply", summary: "Supply totals", scope: ScopeRead,
+		status: 200, response: ref("GetSupplyMsg"), errors: []int{500}},
+	{method: "GET", path: "/accounts/top", summary: "Holders with the highest balance", scope: ScopeRead,
+		params: []param{queryParam("n", fmt.Sprintf("number of holders, %d by default and at most %d", defaultTopHolders, maxTopHolders),
+			obj{"type": "integer", "minimum": 1, "maximum": maxTopHolders})},
+		status: 200, response: ref("GetTopAccountsMsg"), errors: []int{400, 500}},
+	{method: "GET", path: "/stats", summary: "Economic stats of each day or week", scope: ScopeRead,
+		params: []param{
+			queryParam("interval", "day (default) or week", obj{"type": "string", "enum": []string{"day", "week"}}),
+			queryParam("from", "first date, 2006-01-02 or RFC3339, 30 intervals before to by default", obj{"type": "string"}),
+			queryParam("to", "last date, 2006-01-02 or RFC3339, now by default", obj{"type": "string"}),
+		},
+		status: 200, response: object(obj{"interval": str("day or week"), "stats": arrayOf(ref("Stats"))}),
+		errors: []int{400, 500}},
+	{method: "GET", path: "/events", summary: "Server-Sent Events stream of the txs of the addresses", scope: ScopeRead,
+		params: []param{queryParam("addr", "addresses to subscribe, repeated or comma separated", arrayOf(ref("Address")))},
+		status: 200, response: ref("AccountEvent"), errors: []int{400}},
+	{method: "POST", path: "/graphql", summary: "GraphQL query of the accounts, txs and blocks", scope: ScopeRead,
+		body:   "GraphQLMsg",
+		status: 200, response: object(obj{"data": obj{"type": "object"}, "errors": arrayOf(object(obj{"message": str("error message")}))}),
+		errors: []int{400}},
+	{method: "POST", path: "/tx", summary: "Broadcast a signed tx", scope: ScopeSubmit,
+		body:   "PostTxMsg",
+		status: 200, response: ref("PostTxResultMsg"), accepted: true, errors: []int{400, 409, 422, 500, 502}},
+	{method: "POST", path: "/tx/submit", summary: "Broadcast a built tx with its signature", scope: ScopeSubmit,
+		body:   "SubmitTxMsg",
+		status: 200, response: ref("PostTxResultMsg"), accepted: true, errors: []int{400, 409, 422, 500, 502}},
+	{method: "POST", path: "/txs", summary: "Validate and broadcast a batch of signed txs", scope: ScopeSubmit,
+		body:   "PostTxsMsg",
+		status: 200, response: object(obj{"txs": arrayOf(ref("BatchTxResultMsg"))}), errors: []int{400, 502, 503}},
+	{method: "POST", path: "/webhooks", summary: "Register a webhook for the payments to an address", scope: ScopeAdmin,
+		body:   "PostWebhookMsg",
+		status: 201, response: ref("Webhook"), errors: []int{400}},
+	{method: "GET", path: "/webhooks", summary: "Webhooks of an address", scope: ScopeAdmin,
+		params: []param{queryParam("addr", "address of the webhooks", ref("Address"))},
+		status: 200, response: object(obj{"webhooks": arrayOf(ref("Webhook"))}), errors: []int{400, 500}},
+	{method: "DELETE", path: "/webhooks/:id", summary: "Remove a webhook", scope: ScopeAdmin,
+		params: []param{webhookParam},
+		status: 200, response: object(obj{"status": str("ok")}), errors: []int{404, 500}},
+	{method: "GET", path: "/webhooks/:id/deliveries", summary: "Deliveries of a webhook", scope: ScopeAdmin,
+		params: []param{webhookParam},
+		status: 200, response: object(obj{"deliveries": arrayOf(ref("Delivery"))}), errors: []int{404, 500}},
+}
+
+var addrParam = pathParam("addr", "address", ref("Address"))
+var hashParam = pathParam("hash", "tx hash", ref("Hash"))
+var webhookParam = pathParam("id", "webhook id", str("webhook id"))
+
+var txSchema = object(obj{
+	"type":      obj{"type": "integer", "enum": []int{0, 1}, "description": "0 for a transfer, 1 for a mint"},
+	"from":      ref("Address"),
+	"to":        ref("Address"),
+	"amount":    uint64Schema("amount"),
+	"nonce":     uint64Schema("nonce of the sender"),
+	"signature": obj{"type": "string", "format": "byte", "description": "base64 of the 65 bytes compact secp256k1 signature"},
+}, "type", "from", "to", "amount", "nonce", "signature")
+
+var apiSchemas = obj{
+	"Error": object(obj{"error": str("description of the error")}, "error"),
+	"Address": obj{"type": "string", "description": "base58 of the 32 bytes address",
+		"example": "DqF1B6iqaxeE3j4XvyPfLbba6QkQfQtwSUWBJmnQRMvN"},
+	"Hash": obj{"type": "string", "description": "hex of the sha256 of the raw Tendermint tx",
+		"pattern": "^[0-9A-Fa-f]{64}$"},
+	"Tx": txSchema,
+	"InfoMsg": object(obj{
+		"version":           str("version of the node"),
+		"tendermintVersion": str("version of Tendermint"),
+		"network":           str("chain id"),
+		"latestHeight":      obj{"type": "integer", "format": "int64", "description": "height of the last block of the node"},
+		"latestBlockHash":   str("hex of the hash of the last block"),
+		"latestAppHash":     str("hex of the app hash of the last block"),
+		"latestBlockTime":   obj{"type": "string", "format": "date-time"},
+		"catchingUp":        obj{"type": "boolean", "description": "true while the node is syncing"},
+		"peers":             obj{"type": "integer", "description": "number of connected peers"},
+		"mempoolSize":       obj{"type": "integer", "description": "number of txs in the mempool"},
+		"stateHeight":       uint64Schema("height of the last block committed to the state"),
+		"archiveHeight":     uint64Schema("height of the last block stored in the archive"),
+		"archive":           obj{"type": "boolean", "description": "false if the node does not store the tx history archive"},
+	}),
+	"HealthMsg": object(obj{
+		"status": obj{"type": "string", "enum": []string{"ok", "unavailable"}},
+		"error":  str("why the node is not healthy or ready"),
+	}, "status"),
+	"GetBalanceMsg": object(obj{"addr": ref("Address"), "balance": uint64Schema("balance")}),
+	"NonceMsg":      object(obj{"addr": ref("Address"), "nonce": uint64Schema("next nonce")}),
+	"PendingTxMsg":  object(obj{"hash": ref("Hash"), "tx": ref("Tx")}),
+	"PostTxMsg": object(obj{
+		"txHex": str("hex of the signed tx bytes"),
+		"mode":  obj{"type": "string", "enum": []string{BroadcastAsync, BroadcastSync, BroadcastCommit}, "default": BroadcastCommit},
+	}, "txHex"),
+	"TxResultMsg": object(obj{"code": uint32Schema("0 for valid txs"), "log": str("description of the code")}),
+	"PostTxResultMsg": object(obj{
+		"hash":      ref("Hash"),
+		"mode":      str("broadcast mode"),
+		"checkTx":   ref("TxResultMsg"),
+		"deliverTx": ref("TxResultMsg"),
+		"height":    uint64Schema("height of the block, in commit mode"),
+	}),
+	"SimulateTxMsg": object(obj{"txHex": str("hex of the signed tx bytes")}, "txHex"),
+	"SimulateTxResultMsg": object(obj{
+		"hash":     ref("Hash"),
+		"code":     uint32Schema("0 for valid txs"),
+		"log":      str("description of the code"),
+		"tx":       ref("Tx"),
+		"balances": arrayOf(ref("GetBalanceMsg")),
+	}),
+	"BuildTxMsg": object(obj{
+		"type":   obj{"type": "integer", "enum": []int{0, 1}, "default": 0},
+		"from":   ref("Address"),
+		"to":     ref("Address"),
+		"amount": uint64Schema("amount"),
+		"nonce":  uint64Schema("next nonce of the sender by default"),
+	}, "from", "to", "amount"),
+	"BuildTxResultMsg": object(obj{
+		"tx":      ref("Tx"),
+		"txBytes": str("hex of the unsigned tx bytes"),
+		"sigHash": str("hex of the hash to sign"),
+	}),
+	"SubmitTxMsg": object(obj{
+		"type":      obj{"type": "integer", "enum": []int{0, 1}, "default": 0},
+		"from":      ref("Address"),
+		"to":        ref("Address"),
+		"amount":    uint64Schema("amount"),
+		"nonce":     uint64Schema("nonce"),
+		"signature": str("hex of the 65 bytes compact signature of the sigHash"),
+		"mode":      obj{"type": "string", "enum": []string{BroadcastAsync, BroadcastSync, BroadcastCommit}, "default": BroadcastCommit},
+	}, "from", "to", "amount", "nonce", "signature"),
+	"TxMsg": object(obj{
+		"hash":   ref("Hash"),
+		"height": uint64Schema("height of the block"),
+		"index":  uint32Schema("index of the tx in the block"),
+		"tx":     ref("Tx"),
+	}),
+	"TrackedTx": object(obj{
+		"hash":         ref("Hash"),
+		"txHex":        str("hex of the signed tx bytes"),
+		"state":        obj{"type": "string", "enum": []string{TxPending, TxMempool, TxCommitted, TxRejected, TxExpired}},
+		"height":       uint64Schema("height of the block, when committed"),
+		"code":         uint32Schema("result code, when rejected"),
+		"log":          str("description of the code, when rejected"),
+		"rebroadcasts": obj{"type": "integer"},
+		"submittedAt":  obj{"type": "string", "format": "date-time"},
+		"updatedAt":    obj{"type": "string", "format": "date-time"},
+	}),
+	"GraphQLMsg": object(obj{
+		"query":         str("GraphQL query, see the schema with an introspection query"),
+		"variables":     obj{"type": "object"},
+		"operationName": str("operation to execute, if the query has several"),
+	}, "query"),
+	"PostTxsMsg": object(obj{"txs": arrayOf(str("hex of the signed tx bytes"))}, "txs"),
+	"BatchTxResultMsg": object(obj{
+		"hash":   ref("Hash"),
+		"status": obj{"type": "string", "enum": []string{BatchTxBroadcast, BatchTxInvalid, BatchTxError, BatchTxSkipped}},
+		"error":  str("why the tx was not broadcasted"),
+	}),
+	"GetSupplyMsg": object(obj{
+		"minted":      uint64Schema("total minted"),
+		"burned":      uint64Schema("total burned"),
+		"circulating": uint64Schema("minted minus burned"),
+		"accounts":    uint64Schema("number of accounts"),
+	}),
+	"Holder": object(obj{"addr": ref("Address"), "balance": uint64Schema("balance")}),
+	"GetTopAccountsMsg": object(obj{
+		"accounts": uint64Schema("number of accounts"),
+		"top":      arrayOf(ref("Holder")),
+	}),
+	"Stats": object(obj{
+		"start":       obj{"type": "string", "format": "date-time"},
+		"transfers":   uint64Schema("number of transfers"),
+		"volume":      uint64Schema("amount transferred"),
+		"senders":     uint64Schema("distinct senders"),
+		"receivers":   uint64Schema("distinct receivers"),
+		"newAccounts": uint64Schema("accounts created"),
+		"minted":      uint64Schema("amount minted"),
+		"burned":      uint64Schema("amount burned"),
+		"supply":      uint64Schema("circulating supply at the end of the interval"),
+		"velocity":    obj{"type": "number", "description": "volume divided by supply"},
+	}),
+	"AccountEvent": object(obj{
+		"type":    obj{"type": "string", "enum": []string{EventIncoming, EventOutgoing}},
+		"addr":    ref("Address"),
+		"height":  uint64Schema("height of the block"),
+		"hash":    ref("Hash"),
+		"tx":      ref("Tx"),
+		"balance": uint64Schema("balance of addr after the block"),
+	}),
+	"PostWebhookMsg": object(obj{
+		"addr":   ref("Address"),
+		"url":    str("http or https url called for each payment"),
+		"secret": str("key of the HMAC-SHA256 signature of the requests"),
+	}, "addr", "url", "secret"),
+	"Webhook": object(obj{"id": str("webhook id"), "addr": ref("Address"), "url": str("callback url")}),
+	"Delivery": object(obj{
+		"id":             str("delivery id"),
+		"webhookId":      str("webhook id"),
+		"payload":        obj{"type": "object"},
+		"status":         obj{"type": "string", "enum": []string{"pending", "delivered", "failed"}},
+		"attempts":       obj{"type": "integer"},
+		"createdAt":      obj{"type": "string", "format": "date-time"},
+		"nextAttempt":    obj{"type": "string", "format": "date-time"},
+		"lastStatusCode": obj{"type": "integer"},
+		"lastError":      str("error of the last attempt"),
+	}),
+}
+
+var statusDescriptions = map[int]string{
+	400: "invalid request",
+	401: "api key required or invalid",
+	403: "the api key does not have the scope of the route",
+	404: "not found",
+	409: "invalid nonce",
+	422: "not enough funds",
+	429: "rate limit exceeded",
+	500: "internal error",
+	502: "error of the Tendermint node",
+	503: "the node is not healthy or not ready, or the mempool is too large to read, with a Retry-After header",
+}
+
+// openAPIPath converts a gin path to an OpenAPI path
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func jsonContent(schema obj) obj {
+	return obj{"application/json": obj{"schema": schema}}
+}
+
+func (op *apiOperation) document() obj {
+	responses := obj{}
+	content := jsonContent(op.response)
+	if op.path == "/events" {
+		content = obj{"text/event-stream": obj{"schema": op.response}}
+	}
+	responses[fmt.Sprint(op.status)] = obj{"description": "success", "content": content}
+	if op.accepted {
+		responses["202"] = obj{"description": "accepted, the result of the tx is not known yet", "content": content}
+	}
+	statuses := append([]int{}, op.errors...)
+	statuses = append(statuses, 429)
+	if op.scope != "" {
+		statuses = append(statuses, 401, 403)
+	}
+	for _, status := range statuses {
+		responses[fmt.Sprint(status)] = obj{"$ref": fmt.Sprintf("#/components/responses/%d", status)}
+	}
+
+	doc := obj{"summary": op.summary, "responses": responses}
+	if len(op.params) > 0 {
+		var params []obj
+		for _, p := range op.params {
+			params = append(params, obj{
+				"name":        p.name,
+				"in":          p.in,
+				"description": p.description,
+				"required":    p.in == "path",
+				"schema":      p.schema,
+			})
+		}
+		doc["parameters"] = params
+	}
+	if op.body != "" {
+		doc["requestBody"] = obj{"required": true, "content": jsonContent(ref(op.body))}
+	}
+	if op.scope != "" {
+		doc["security"] = []obj{{"apiKey": []string{}}, {"bearer": []string{}}}
+		doc["description"] = "Requires an api key with the " + op.scope + " scope, when the node has api keys."
+		if op.scope == ScopeAdmin {
+			doc["description"] = "Requires an api key with the admin scope, it is not available on nodes without api keys."
+		}
+	}
+	return doc
+}
+
+// openAPIDocument returns the OpenAPI 3 document of the api
+func openAPIDocument() obj {
+	paths := obj{}
+	for i := range apiOperations {
+		op := &apiOperations[i]
+		path := openAPIPath(op.path)
+		if _, ok := paths[path]; !ok {
+			paths[path] = obj{}
+		}
+		paths[path].(obj)[strings.ToLower(op.method)] = op.document()
+	}
+	responses := obj{}
+	for status, description := range statusDescriptions {
+		responses[fmt.Sprint(status)] = obj{"description": description, "content": jsonContent(ref("Error"))}
+	}
+	return obj{
+		"openapi": "3.0.3",
+		"info": obj{
+			"title":   "kvartalochain api",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": obj{
+			"schemas":   apiSchemas,
+			"responses": responses,
+			"securitySchemes": obj{
+				"apiKey": obj{"type": "apiKey", "in": "header", "name": APIKEYHEADER},
+				"bearer": obj{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func handleOpenAPI(c *gin.Context) {
+	c.JSON(200, openAPIDocument())
+}
@@ -0,0 +1,139 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"kvartalochain/common"
+	"kvartalochain/storage"
+	"kvartalochain/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jsonFields returns the json names of the fields of a struct
+func jsonFields(v interface{}) []string {
+	var fields []string
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func schemaProperties(schema obj) []string {
+	var props []string
+	for name := range schema["properties"].(obj) {
+		props = append(props, name)
+	}
+	sort.Strings(props)
+	return props
+}
+
+// refs returns the $ref values of a document node
+func refs(node interface{}) []string {
+	var r []string
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for k, v := range n {
+			if k == "$ref" {
+				r = append(r, v.(string))
+			} else {
+				r = append(r, refs(v)...)
+			}
+		}
+	case []interface{}:
+		for _, v := range n {
+			r = append(r, refs(v)...)
+		}
+	}
+	return r
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	api, _ := newTestApi(t)
+
+	var routes, documented []string
+	for _, route := range api.Routes() {
+		routes = append(routes, route.Method+" "+route.Path)
+	}
+	for _, op := range apiOperations {
+		documented = append(documented, op.method+" "+op.path)
+	}
+	sort.Strings(routes)
+	sort.Strings(documented)
+	assert.Equal(t, routes, documented, "the routes and the OpenAPI document differ")
+
+	w := doRequest(api, "GET", "/openapi.json", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var doc map[string]interface{}
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, len(apiSchemas), len(doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})))
+
+	// all the references are defined
+	components := doc["components"].(map[string]interface{})
+	for _, r := range refs(doc) {
+		parts := strings.Split(strings.TrimPrefix(r, "#/components/"), "/")
+		require.Equal(t, 2, len(parts), r)
+		_, ok := components[parts[0]].(map[string]interface{})[parts[1]]
+		assert.True(t, ok, "undefined reference %s", r)
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	types := map[string]interface{}{
+		"Tx":                  common.Tx{},
+		"InfoMsg":             InfoMsg{},
+		"HealthMsg":           HealthMsg{},
+		"GetBalanceMsg":       GetBalanceMsg{},
+		"NonceMsg":            NonceMsg{},
+		"PendingTxMsg":        PendingTxMsg{},
+		"PostTxMsg":           PostTxMsg{},
+		"TxResultMsg":         TxResultMsg{},
+		"PostTxResultMsg":     PostTxResultMsg{},
+		"SimulateTxMsg":       SimulateTxMsg{},
+		"SimulateTxResultMsg": SimulateTxResultMsg{},
+		"BuildTxMsg":          BuildTxMsg{},
+		"BuildTxResultMsg":    BuildTxResultMsg{},
+		"SubmitTxMsg":         SubmitTxMsg{},
+		"TxMsg":               TxMsg{},
+		"TrackedTx":           TrackedTx{},
+		"PostTxsMsg":          PostTxsMsg{},
+		"GraphQLMsg":          GraphQLMsg{},
+		"BatchTxResultMsg":    BatchTxResultMsg{},
+		"GetSupplyMsg":        GetSupplyMsg{},
+		"Holder":              storage.Holder{},
+		"GetTopAccountsMsg":   GetTopAccountsMsg{},
+		"Stats":               storage.Stats{},
+		"AccountEvent":        AccountEvent{},
+		"PostWebhookMsg":      PostWebhookMsg{},
+		"Delivery":            webhook.Delivery{},
+	}
+	for name, v := range types {
+		schema, ok := apiSchemas[name].(obj)
+		require.True(t, ok, "schema %s not defined", name)
+		assert.Equal(t, jsonFields(v), schemaProperties(schema), "schema %s differs from its type", name)
+	}
+
+	// the secret of the webhooks is not returned
+	var fields []string
+	for _, f := range jsonFields(webhook.Webhook{}) {
+		if f != "secret" {
+			fields = append(fields, f)
+		}
+	}
+	assert.Equal(t, fields, schemaProperties(apiSchemas["Webhook"].(obj)))
+}
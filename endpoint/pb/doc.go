@@ -0,0 +1,4 @@
+// Package pb has the gRPC service of the api, generated from kvartalo.proto
+package pb
+
+//go:generate protoc --go_out=plugins=grpc,paths=source_relative:. kvartalo.proto
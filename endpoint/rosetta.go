@@ -0,0 +1,650 @@
+package endpoint
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/gin-gonic/gin"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+const (
+	RosettaVersion    = "1.4.0"
+	RosettaBlockchain = "kvartalochain"
+)
+
+// RosettaCurrency is the coin of the chain, the amounts have no decimals
+var RosettaCurrency = &Currency{Symbol: "KVT", Decimals: 0}
+
+// operation types and statuses. A transfer is a debit of the sender and a
+// credit of the receiver, and a mint only the credit of the receiver.
+const (
+	OpTransfer = "TRANSFER"
+	OpMint     = "MINT"
+	OpSuccess  = "SUCCESS"
+)
+
+// the signatures are the r, s and recovery id of the secp256k1 signature of
+// common.TxSigHash
+const (
+	rosettaCurveType     = "secp256k1"
+	rosettaSignatureType = "ecdsa_recovery"
+)
+
+var (
+	ErrRosettaNetwork       = &RosettaError{Code: 1, Message: "invalid network"}
+	ErrRosettaRequest       = &RosettaError{Code: 2, Message: "invalid request"}
+	ErrRosettaBlockNotFound = &RosettaError{Code: 3, Message: "block not found"}
+	ErrRosettaTxNotFound    = &RosettaError{Code: 4, Message: "tx not found"}
+	ErrRosettaAddress       = &RosettaError{Code: 5, Message: "invalid address"}
+	ErrRosettaOperations    = &RosettaError{Code: 6, Message: "unsupported operations"}
+	ErrRosettaTx            = &RosettaError{Code: 7, Message: "invalid tx"}
+	ErrRosettaSignature     = &RosettaError{Code: 8, Message: "invalid signature"}
+	ErrRosettaRejected      = &RosettaError{Code: 9, Message: "tx rejected by the node"}
+	ErrRosettaNode          = &RosettaError{Code: 10, Message: "node unavailable", Retriable: true}
+	ErrRosettaArchive       = &RosettaError{Code: 11, Message: "archive error"}
+	ErrRosettaNotReady      = &RosettaError{Code: 12, Message: "no block archived yet", Retriable: true}
+	ErrRosettaState         = &RosettaError{Code: 13, Message: "state error"}
+)
+
+var rosettaErrors = []*RosettaError{ErrRosettaNetwork, ErrRosettaRequest,
+	ErrRosettaBlockNotFound, ErrRosettaTxNotFound, ErrRosettaAddress,
+	ErrRosettaOperations, ErrRosettaTx, ErrRosettaSignature, ErrRosettaRejected,
+	ErrRosettaNode, ErrRosettaArchive, ErrRosettaNotReady, ErrRosettaState}
+
+// rosettaNetwork is the network of the Rosetta server, the chain id
+var rosettaNetwork string
+
+// writeRosettaError writes e, with err in the details. Rosetta answers all the
+// errors with 500.
+func writeRosettaError(c *gin.Context, e *RosettaError, err error) {
+	body := *e
+	if err != nil {
+		body.Details = map[string]interface{}{"error": err.Error()}
+	}
+	c.JSON(500, body)
+}
+
+// bindRosetta decodes the request and checks its network identifier
+func bindRosetta(c *gin.Context, req interface{}, network **NetworkIdentifier) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		writeRosettaError(c, ErrRosettaRequest, err)
+		return false
+	}
+	if *network == nil || (*network).Blockchain != RosettaBlockchain || (*network).Network != rosettaNetwork {
+		writeRosettaError(c, ErrRosettaNetwork, nil)
+		return false
+	}
+	return true
+}
+
+func blockIdentifier(header *storage.BlockHeader) *BlockIdentifier {
+	return &BlockIdentifier{Index: int64(header.Height), Hash: fmt.Sprintf("%X", header.Hash)}
+}
+
+func amountValue(v uint64, negative bool) *Amount {
+	s := strconv.FormatUint(v, 10)
+	if negative && v != 0 {
+		s = "-" + s
+	}
+	return &Amount{Value: s, Currency: RosettaCurrency}
+}
+
+// rosettaOperations returns the operations of the tx, with the status of the
+// archived txs or without status for the others
+func rosettaOperations(tx *common.Tx, status string) []*Operation {
+	if tx.Type == common.TxTypeMint {
+		return []*Operation{{
+			OperationIdentifier: &OperationIdentifier{Index: 0},
+			Type:                OpMint,
+			Status:              status,
+			Account:             &AccountIdentifier{Address: tx.To.String()},
+			Amount:              amountValue(tx.Amount, false),
+		}}
+	}
+	return []*Operation{
+		{
+			OperationIdentifier: &OperationIdentifier{Index: 0},
+			Type:                OpTransfer,
+			Status:              status,
+			Account:             &AccountIdentifier{Address: tx.From.String()},
+			Amount:              amountValue(tx.Amount, true),
+		},
+		{
+			OperationIdentifier: &OperationIdentifier{Index: 1},
+			RelatedOperations:   []*OperationIdentifier{{Index: 0}},
+			Type:                OpTransfer,
+			Status:              status,
+			Account:             &AccountIdentifier{Address: tx.To.String()},
+			Amount:              amountValue(tx.Amount, false),
+		},
+	}
+}
+
+func rosettaTransaction(hash []byte, tx *common.Tx, status string) *Transaction {
+	return &Transaction{
+		TransactionIdentifier: &TransactionIdentifier{Hash: fmt.Sprintf("%X", hash)},
+		Operations:            rosettaOperations(tx, status),
+	}
+}
+
+// parseTransfer returns the tx of the operations of a transfer: a debit of
+// the sender and a credit of the same amount to the receiver
+func parseTransfer(ops []*Operation) (*common.Tx, error) {
+	if len(ops) != 2 {
+		return nil, errors.New("a transfer has 2 operations")
+	}
+	var from, to *common.Address
+	var amount uint64
+	for _, op := range ops {
+		if op.Type != OpTransfer {
+			return nil, fmt.Errorf("operation type %q can not be constructed", op.Type)
+		}
+		if op.Account == nil || op.Amount == nil || op.Amount.Currency == nil ||
+			*op.Amount.Currency != *RosettaCurrency {
+			return nil, errors.New("operation without account or amount in " + RosettaCurrency.Symbol)
+		}
+		addr, err := common.AddressFromString(op.Account.Address)
+		if err != nil {
+			return nil, err
+		}
+		negative := strings.HasPrefix(op.Amount.Value, "-")
+		v, err := strconv.ParseUint(strings.TrimPrefix(op.Amount.Value, "-"), 10, 64)
+		if err != nil || v == 0 {
+			return nil, errors.New("invalid amount " + op.Amount.Value)
+		}
+		if amount != 0 && v != amount {
+			return nil, errors.New("the debit and the credit have different amounts")
+		}
+		amount = v
+		if negative {
+			from = &addr
+		} else {
+			to = &addr
+		}
+	}
+	if from == nil || to == nil {
+		return nil, errors.New("a transfer has a debit and a credit")
+	}
+	return common.NewTx(*from, *to, amount, 0), nil
+}
+
+// decodeRosettaTx returns the tx of the hex of its bytes
+func decodeRosettaTx(s string) (*common.Tx, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return common.TxFromBytes(b)
+}
+
+// currentHeader returns the header of the last archived block
+func currentHeader() (*storage.BlockHeader, *RosettaError, error) {
+	height, err := storage.GetArchiveHeight(archiveDb)
+	if err != nil {
+		return nil, ErrRosettaArchive, err
+	}
+	if height == 0 {
+		return nil, ErrRosettaNotReady, nil
+	}
+	return getHeader(height)
+}
+
+func getHeader(height uint64) (*storage.BlockHeader, *RosettaError, error) {
+	header, err := storage.GetBlockHeader(archiveDb, height)
+	if err != nil {
+		return nil, ErrRosettaArchive, err
+	}
+	if header == nil {
+		archiveHeight, err := storage.GetArchiveHeight(archiveDb)
+		if err == nil && height <= archiveHeight {
+			err = fmt.Errorf("header of block %d not archived, run the reindex command", height)
+		}
+		return nil, ErrRosettaBlockNotFound, err
+	}
+	return header, nil, nil
+}
+
+// findHeader returns the header of the block of the identifier, the current
+// block if it is empty
+func findHeader(id *PartialBlockIdentifier) (*storage.BlockHeader, *RosettaError, error) {
+	if id == nil || (id.Index == nil && id.Hash == nil) {
+		return currentHeader()
+	}
+	var height uint64
+	if id.Index != nil {
+		if *id.Index < 1 {
+			return nil, ErrRosettaBlockNotFound, nil
+		}
+		height = uint64(*id.Index)
+	} else {
+		hash, err := hex.DecodeString(*id.Hash)
+		if err != nil {
+			return nil, ErrRosettaRequest, err
+		}
+		height, err = storage.GetBlockHeight(archiveDb, hash)
+		if err != nil {
+			return nil, ErrRosettaArchive, err
+		}
+		if height == 0 {
+			return nil, ErrRosettaBlockNotFound, nil
+		}
+	}
+	header, e, err := getHeader(height)
+	if e != nil {
+		return nil, e, err
+	}
+	if id.Hash != nil && !strings.EqualFold(*id.Hash, hex.EncodeToString(header.Hash)) {
+		return nil, ErrRosettaBlockNotFound, errors.New("hash does not match the index")
+	}
+	return header, nil, nil
+}
+
+func handleRosettaNetworkList(c *gin.Context) {
+	c.JSON(200, NetworkListResponse{
+		NetworkIdentifiers: []*NetworkIdentifier{{Blockchain: RosettaBlockchain, Network: rosettaNetwork}},
+	})
+}
+
+func handleRosettaNetworkOptions(c *gin.Context) {
+	var req NetworkRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	c.JSON(200, NetworkOptionsResponse{
+		Version: &Version{RosettaVersion: RosettaVersion, NodeVersion: NodeVersion},
+		Allow: &Allow{
+			OperationStatuses:       []*OperationStatus{{Status: OpSuccess, Successful: true}},
+			OperationTypes:          []string{OpTransfer, OpMint},
+			Errors:                  rosettaErrors,
+			HistoricalBalanceLookup: true,
+		},
+	})
+}
+
+func handleRosettaNetworkStatus(c *gin.Context) {
+	var req NetworkRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	current, e, err := currentHeader()
+	if e != nil {
+		writeRosettaError(c, e, err)
+		return
+	}
+	genesis, e, err := getHeader(1)
+	if e != nil {
+		writeRosettaError(c, e, err)
+		return
+	}
+	c.JSON(200, NetworkStatusResponse{
+		CurrentBlockIdentifier: blockIdentifier(current),
+		CurrentBlockTimestamp:  current.Time.UnixNano() / 1e6,
+		GenesisBlockIdentifier: blockIdentifier(genesis),
+		Peers:                  []*Peer{},
+	})
+}
+
+func handleRosettaAccountBalance(c *gin.Context) {
+	var req AccountBalanceRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	if req.AccountIdentifier == nil {
+		writeRosettaError(c, ErrRosettaAddress, nil)
+		return
+	}
+	addr, err := common.AddressFromString(req.AccountIdentifier.Address)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaAddress, err)
+		return
+	}
+	header, e, err := findHeader(req.BlockIdentifier)
+	if e != nil {
+		writeRosettaError(c, e, err)
+		return
+	}
+	balance, err := storage.GetBalanceAt(db, archiveDb, addr, header.Height)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaArchive, err)
+		return
+	}
+	c.JSON(200, AccountBalanceResponse{
+		BlockIdentifier: blockIdentifier(header),
+		Balances:        []*Amount{amountValue(balance, false)},
+	})
+}
+
+func handleRosettaBlock(c *gin.Context) {
+	var req BlockRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	header, e, err := findHeader(req.BlockIdentifier)
+	if e != nil {
+		writeRosettaError(c, e, err)
+		return
+	}
+	txs, err := storage.GetBlockTxs(archiveDb, header.Height)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaArchive, err)
+		return
+	}
+	// the parent of the genesis block is itself
+	parent := blockIdentifier(header)
+	if header.Height > 1 {
+		parent = &BlockIdentifier{Index: int64(header.Height) - 1, Hash: fmt.Sprintf("%X", header.ParentHash)}
+	}
+	block := &Block{
+		BlockIdentifier:       blockIdentifier(header),
+		ParentBlockIdentifier: parent,
+		Timestamp:             header.Time.UnixNano() / 1e6,
+		Transactions:          []*Transaction{},
+	}
+	for _, archived := range txs {
+		block.Transactions = append(block.Transactions, rosettaTransaction(archived.Hash, archived.Tx, OpSuccess))
+	}
+	c.JSON(200, BlockResponse{Block: block})
+}
+
+func handleRosettaBlockTransaction(c *gin.Context) {
+	var req BlockTransactionRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	if req.BlockIdentifier == nil || req.TransactionIdentifier == nil {
+		writeRosettaError(c, ErrRosettaRequest, errors.New("block and transaction identifiers required"))
+		return
+	}
+	hash, err := hex.DecodeString(req.TransactionIdentifier.Hash)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaRequest, err)
+		return
+	}
+	archived, err := storage.GetTxByHash(archiveDb, hash)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaArchive, err)
+		return
+	}
+	if archived == nil || int64(archived.Height) != req.BlockIdentifier.Index {
+		writeRosettaError(c, ErrRosettaTxNotFound, nil)
+		return
+	}
+	c.JSON(200, TransactionResponse{Transaction: rosettaTransaction(archived.Hash, archived.Tx, OpSuccess)})
+}
+
+func handleRosettaMempool(c *gin.Context) {
+	var req NetworkRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	res, err := tmClient.UnconfirmedTxs(mempoolLimit)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaNode, err)
+		return
+	}
+	ids := []*TransactionIdentifier{}
+	for _, txRaw := range res.Txs {
+		ids = append(ids, &TransactionIdentifier{Hash: fmt.Sprintf("%X", txRaw.Hash())})
+	}
+	c.JSON(200, MempoolResponse{TransactionIdentifiers: ids})
+}
+
+func handleRosettaMempoolTransaction(c *gin.Context) {
+	var req MempoolTransactionRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	if req.TransactionIdentifier == nil {
+		writeRosettaError(c, ErrRosettaRequest, errors.New("transaction identifier required"))
+		return
+	}
+	res, err := tmClient.UnconfirmedTxs(mempoolLimit)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaNode, err)
+		return
+	}
+	for _, txRaw := range res.Txs {
+		if !strings.EqualFold(fmt.Sprintf("%X", txRaw.Hash()), req.TransactionIdentifier.Hash) {
+			continue
+		}
+		tx, err := decodeTx(txRaw)
+		if err != nil {
+			writeRosettaError(c, ErrRosettaTx, err)
+			return
+		}
+		c.JSON(200, TransactionResponse{Transaction: rosettaTransaction(txRaw.Hash(), tx, "")})
+		return
+	}
+	writeRosettaError(c, ErrRosettaTxNotFound, nil)
+}
+
+func handleRosettaDerive(c *gin.Context) {
+	var req ConstructionDeriveRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	if req.PublicKey == nil || req.PublicKey.CurveType != rosettaCurveType {
+		writeRosettaError(c, ErrRosettaRequest, errors.New("a "+rosettaCurveType+" public key is required"))
+		return
+	}
+	b, err := hex.DecodeString(req.PublicKey.HexBytes)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaRequest, err)
+		return
+	}
+	pk, err := btcec.ParsePubKey(b, btcec.S256())
+	if err != nil {
+		writeRosettaError(c, ErrRosettaRequest, err)
+		return
+	}
+	pubKey := common.PublicKey{PublicKey: pk}
+	c.JSON(200, ConstructionDeriveResponse{Address: pubKey.Address().String()})
+}
+
+func handleRosettaPreprocess(c *gin.Context) {
+	var req ConstructionPreprocessRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	tx, err := parseTransfer(req.Operations)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaOperations, err)
+		return
+	}
+	c.JSON(200, ConstructionPreprocessResponse{Options: &ConstructionOptions{From: tx.From.String()}})
+}
+
+// handleRosettaMetadata returns the next nonce of the sender, counting its
+// txs in the mempool
+func handleRosettaMetadata(c *gin.Context) {
+	var req ConstructionMetadataRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	if req.Options == nil {
+		writeRosettaError(c, ErrRosettaRequest, errors.New("options required"))
+		return
+	}
+	res, err := getNonce(req.Options.From, true)
+	if err != nil {
+		switch errorStatus(err) {
+		case http.StatusBadRequest:
+			writeRosettaError(c, ErrRosettaAddress, err)
+		case http.StatusBadGateway, http.StatusServiceUnavailable:
+			writeRosettaError(c, ErrRosettaNode, err)
+		default:
+			writeRosettaError(c, ErrRosettaState, err)
+		}
+		return
+	}
+	c.JSON(200, ConstructionMetadataResponse{Metadata: &ConstructionMetadata{Nonce: res.Nonce}})
+}
+
+// handleRosettaPayloads returns the tx without signature and the hash that
+// the sender signs
+func handleRosettaPayloads(c *gin.Context) {
+	var req ConstructionPayloadsRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	tx, err := parseTransfer(req.Operations)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaOperations, err)
+		return
+	}
+	if req.Metadata == nil {
+		writeRosettaError(c, ErrRosettaRequest, errors.New("metadata required"))
+		return
+	}
+	tx.Nonce = req.Metadata.Nonce
+	sigHash := common.TxSigHash(tx)
+	c.JSON(200, ConstructionPayloadsResponse{
+		UnsignedTransaction: tx.Hex(),
+		Payloads: []*SigningPayload{{
+			Address:       tx.From.String(),
+			HexBytes:      hex.EncodeToString(sigHash[:]),
+			SignatureType: rosettaSignatureType,
+		}},
+	})
+}
+
+// handleRosettaCombine adds the signature to the tx. The Rosetta signature is
+// r | s | recovery id, and the tx signature is the btcec compact format,
+// 27 + recovery id | r | s.
+func handleRosettaCombine(c *gin.Context) {
+	var req ConstructionCombineRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	tx, err := decodeRosettaTx(req.UnsignedTransaction)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaTx, err)
+		return
+	}
+	if len(tx.Signature) != 0 {
+		writeRosettaError(c, ErrRosettaTx, errors.New("the tx is already signed"))
+		return
+	}
+	if len(req.Signatures) != 1 || req.Signatures[0].SignatureType != rosettaSignatureType {
+		writeRosettaError(c, ErrRosettaSignature, errors.New("one "+rosettaSignatureType+" signature is required"))
+		return
+	}
+	sig, err := hex.DecodeString(req.Signatures[0].HexBytes)
+	if err != nil || len(sig) != 65 || sig[64] > 3 {
+		writeRosettaError(c, ErrRosettaSignature, errors.New("the signature is not r | s | recovery id"))
+		return
+	}
+	tx.Signature = append([]byte{27 + sig[64]}, sig[:64]...)
+	if !common.VerifySignatureTx(tx) {
+		writeRosettaError(c, ErrRosettaSignature, errors.New("the signature is not of the sender"))
+		return
+	}
+	c.JSON(200, ConstructionCombineResponse{SignedTransaction: tx.Hex()})
+}
+
+func handleRosettaParse(c *gin.Context) {
+	var req ConstructionParseRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	tx, err := decodeRosettaTx(req.Transaction)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaTx, err)
+		return
+	}
+	signers := []string{}
+	if req.Signed {
+		if !common.VerifySignatureTx(tx) {
+			writeRosettaError(c, ErrRosettaSignature, nil)
+			return
+		}
+		signers = append(signers, tx.From.String())
+	} else if len(tx.Signature) != 0 {
+		writeRosettaError(c, ErrRosettaTx, errors.New("the tx is signed"))
+		return
+	}
+	c.JSON(200, ConstructionParseResponse{Operations: rosettaOperations(tx, ""), Signers: signers})
+}
+
+func handleRosettaHash(c *gin.Context) {
+	var req ConstructionHashRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	tx, err := decodeRosettaTx(req.SignedTransaction)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaTx, err)
+		return
+	}
+	c.JSON(200, TransactionIdentifierResponse{
+		TransactionIdentifier: &TransactionIdentifier{Hash: fmt.Sprintf("%X", storage.TxHash([]byte(tx.Hex())))},
+	})
+}
+
+// handleRosettaSubmit broadcasts the tx as POST /tx does in sync mode
+func handleRosettaSubmit(c *gin.Context) {
+	var req ConstructionHashRequest
+	if !bindRosetta(c, &req, &req.NetworkIdentifier) {
+		return
+	}
+	tx, err := decodeRosettaTx(req.SignedTransaction)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaTx, err)
+		return
+	}
+	res, _, err := submitTx(tmtypes.Tx(tx.Hex()), BroadcastSync)
+	if err != nil {
+		writeRosettaError(c, ErrRosettaNode, err)
+		return
+	}
+	if res.CheckTx.Code != 0 {
+		writeRosettaError(c, ErrRosettaRejected, errors.New(res.CheckTx.Log))
+		return
+	}
+	c.JSON(200, TransactionIdentifierResponse{TransactionIdentifier: &TransactionIdentifier{Hash: res.Hash}})
+}
+
+// ServeRosetta returns the Rosetta Data and Construction api of the network,
+// the chain id, with the stores and the node client set by Serve, which has
+// to be called before. It has the api keys and rate limits of cfg, the
+// submission needs the submit scope and the rest the read scope. A nil cfg
+// serves an open api.
+func ServeRosetta(network string, cfg *Config) *gin.Engine {
+	rosettaNetwork = network
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	api := newEngine()
+	api.Use(rateLimit(cfg))
+	api.Use(authenticate(cfg))
+
+	read := api.Group("/", requireScope(cfg, ScopeRead))
+	read.POST("/network/list", handleRosettaNetworkList)
+	read.POST("/network/options", handleRosettaNetworkOptions)
+	read.POST("/network/status", handleRosettaNetworkStatus)
+	read.POST("/account/balance", handleRosettaAccountBalance)
+	read.POST("/block", handleRosettaBlock)
+	read.POST("/block/transaction", handleRosettaBlockTransaction)
+	read.POST("/mempool", handleRosettaMempool)
+	read.POST("/mempool/transaction", handleRosettaMempoolTransaction)
+	read.POST("/construction/derive", handleRosettaDerive)
+	read.POST("/construction/preprocess", handleRosettaPreprocess)
+	read.POST("/construction/metadata", handleRosettaMetadata)
+	read.POST("/construction/payloads", handleRosettaPayloads)
+	read.POST("/construction/combine", handleRosettaCombine)
+	read.POST("/construction/parse", handleRosettaParse)
+	read.POST("/construction/hash", handleRosettaHash)
+
+	submit := api.Group("/", requireScope(cfg, ScopeSubmit))
+	submit.POST("/construction/submit", handleRosettaSubmit)
+	return api
+}
@@ -0,0 +1,298 @@
+package endpoint
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testNetwork = &NetworkIdentifier{Blockchain: RosettaBlockchain, Network: "test-chain"}
+
+// doRosetta posts the request and decodes the response into res, or the
+// RosettaError if it fails
+func doRosetta(t *testing.T, api *gin.Engine, path string, req, res interface{}) *RosettaError {
+	body, err := json.Marshal(req)
+	require.Nil(t, err)
+	w := doRequest(api, "POST", path, string(body))
+	if w.Code != http.StatusOK {
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		var e RosettaError
+		require.Nil(t, json.Unmarshal(w.Body.Bytes(), &e))
+		return &e
+	}
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), res))
+	return nil
+}
+
+func TestRosettaData(t *testing.T) {
+	api, sto := newTestApi(t)
+	rosetta := ServeRosetta(testNetwork.Network, nil)
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	addr1, err := common.AddressFromString("HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2")
+	require.Nil(t, err)
+
+	var status NetworkStatusResponse
+	e := doRosetta(t, rosetta, "/network/status", NetworkRequest{NetworkIdentifier: testNetwork}, &status)
+	require.NotNil(t, e)
+	assert.Equal(t, ErrRosettaNotReady.Code, e.Code)
+	assert.True(t, e.Retriable)
+
+	mint := &common.Tx{Type: common.TxTypeMint, From: addr0, To: addr0, Amount: 10}
+	require.Nil(t, sk0.SignTx(mint))
+	transfer := common.NewTx(addr0, addr1, 4, 1)
+	require.Nil(t, sk0.SignTx(transfer))
+	for _, tx := range []*common.Tx{mint, transfer} {
+		w := doRequest(api, "POST", "/tx", `{"txHex": "`+tx.Hex()+`"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	e = doRosetta(t, rosetta, "/network/status", NetworkRequest{NetworkIdentifier: testNetwork}, &status)
+	require.Nil(t, e)
+	assert.Equal(t, int64(2), status.CurrentBlockIdentifier.Index)
+	assert.Equal(t, int64(1), status.GenesisBlockIdentifier.Index)
+	e = doRosetta(t, rosetta, "/network/status", NetworkRequest{
+		NetworkIdentifier: &NetworkIdentifier{Blockchain: RosettaBlockchain, Network: "other"},
+	}, &status)
+	require.NotNil(t, e)
+	assert.Equal(t, ErrRosettaNetwork.Code, e.Code)
+
+	var block BlockResponse
+	hash := status.CurrentBlockIdentifier.Hash
+	e = doRosetta(t, rosetta, "/block", BlockRequest{
+		NetworkIdentifier: testNetwork,
+		BlockIdentifier:   &PartialBlockIdentifier{Hash: &hash},
+	}, &block)
+	require.Nil(t, e)
+	assert.Equal(t, status.CurrentBlockIdentifier, block.Block.BlockIdentifier)
+	assert.Equal(t, status.GenesisBlockIdentifier, block.Block.ParentBlockIdentifier)
+	require.Equal(t, 1, len(block.Block.Transactions))
+	ops := block.Block.Transactions[0].Operations
+	require.Equal(t, 2, len(ops))
+	assert.Equal(t, OpTransfer, ops[0].Type)
+	assert.Equal(t, OpSuccess, ops[0].Status)
+	assert.Equal(t, addr0.String(), ops[0].Account.Address)
+	assert.Equal(t, "-4", ops[0].Amount.Value)
+	assert.Equal(t, addr1.String(), ops[1].Account.Address)
+	assert.Equal(t, "4", ops[1].Amount.Value)
+
+	var txRes TransactionResponse
+	e = doRosetta(t, rosetta, "/block/transaction", BlockTransactionRequest{
+		NetworkIdentifier:     testNetwork,
+		BlockIdentifier:       status.GenesisBlockIdentifier,
+		TransactionIdentifier: &TransactionIdentifier{Hash: fmtHash([]byte(mint.Hex()))},
+	}, &txRes)
+	require.Nil(t, e)
+	require.Equal(t, 1, len(txRes.Transaction.Operations))
+	assert.Equal(t, OpMint, txRes.Transaction.Operations[0].Type)
+	assert.Equal(t, "10", txRes.Transaction.Operations[0].Amount.Value)
+
+	// balance at the current block and at the genesis block
+	var balance AccountBalanceResponse
+	e = doRosetta(t, rosetta, "/account/balance", AccountBalanceRequest{
+		NetworkIdentifier: testNetwork,
+		AccountIdentifier: &AccountIdentifier{Address: addr0.String()},
+	}, &balance)
+	require.Nil(t, e)
+	assert.Equal(t, int64(2), balance.BlockIdentifier.Index)
+	assert.Equal(t, "6", balance.Balances[0].Value)
+	index := int64(1)
+	e = doRosetta(t, rosetta, "/account/balance", AccountBalanceRequest{
+		NetworkIdentifier: testNetwork,
+		AccountIdentifier: &AccountIdentifier{Address: addr0.String()},
+		BlockIdentifier:   &PartialBlockIdentifier{Index: &index},
+	}, &balance)
+	require.Nil(t, e)
+	assert.Equal(t, status.GenesisBlockIdentifier, balance.BlockIdentifier)
+	assert.Equal(t, "10", balance.Balances[0].Value)
+	assert.Equal(t, RosettaCurrency, balance.Balances[0].Currency)
+
+	index = 3
+	e = doRosetta(t, rosetta, "/block", BlockRequest{
+		NetworkIdentifier: testNetwork,
+		BlockIdentifier:   &PartialBlockIdentifier{Index: &index},
+	}, &block)
+	require.NotNil(t, e)
+	assert.Equal(t, ErrRosettaBlockNotFound.Code, e.Code)
+
+	balanceAddr1, err := storage.GetBalance(sto, addr1)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(4), balanceAddr1)
+}
+
+func TestRosettaConstruction(t *testing.T) {
+	api, sto := newTestApi(t)
+	rosetta := ServeRosetta(testNetwork.Network, nil)
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	addr1, err := common.AddressFromString("HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2")
+	require.Nil(t, err)
+	storage.SetAccount(sto, addr0, &storage.Account{Balance: 10})
+
+	var derive ConstructionDeriveResponse
+	e := doRosetta(t, rosetta, "/construction/derive", ConstructionDeriveRequest{
+		NetworkIdentifier: testNetwork,
+		PublicKey:         &PublicKey{HexBytes: hex.EncodeToString(sk0.Public().Bytes()), CurveType: "secp256k1"},
+	}, &derive)
+	require.Nil(t, e)
+	assert.Equal(t, addr0.String(), derive.Address)
+
+	ops := []*Operation{
+		{
+			OperationIdentifier: &OperationIdentifier{Index: 0},
+			Type:                OpTransfer,
+			Account:             &AccountIdentifier{Address: addr0.String()},
+			Amount:              &Amount{Value: "-4", Currency: RosettaCurrency},
+		},
+		{
+			OperationIdentifier: &OperationIdentifier{Index: 1},
+			Type:                OpTransfer,
+			Account:             &AccountIdentifier{Address: addr1.String()},
+			Amount:              &Amount{Value: "4", Currency: RosettaCurrency},
+		},
+	}
+	var preprocess ConstructionPreprocessResponse
+	e = doRosetta(t, rosetta, "/construction/preprocess", ConstructionPreprocessRequest{
+		NetworkIdentifier: testNetwork,
+		Operations:        ops,
+	}, &preprocess)
+	require.Nil(t, e)
+	assert.Equal(t, addr0.String(), preprocess.Options.From)
+
+	var metadata ConstructionMetadataResponse
+	e = doRosetta(t, rosetta, "/construction/metadata", ConstructionMetadataRequest{
+		NetworkIdentifier: testNetwork,
+		Options:           preprocess.Options,
+	}, &metadata)
+	require.Nil(t, e)
+	assert.Equal(t, uint64(0), metadata.Metadata.Nonce)
+
+	var payloads ConstructionPayloadsResponse
+	e = doRosetta(t, rosetta, "/construction/payloads", ConstructionPayloadsRequest{
+		NetworkIdentifier: testNetwork,
+		Operations:        ops,
+		Metadata:          metadata.Metadata,
+	}, &payloads)
+	require.Nil(t, e)
+	require.Equal(t, 1, len(payloads.Payloads))
+	assert.Equal(t, addr0.String(), payloads.Payloads[0].Address)
+
+	var parse ConstructionParseResponse
+	e = doRosetta(t, rosetta, "/construction/parse", ConstructionParseRequest{
+		NetworkIdentifier: testNetwork,
+		Transaction:       payloads.UnsignedTransaction,
+	}, &parse)
+	require.Nil(t, e)
+	assert.Equal(t, 0, len(parse.Signers))
+	assert.Equal(t, "-4", parse.Operations[0].Amount.Value)
+
+	// the signer returns r | s | recovery id
+	sigHash, err := hex.DecodeString(payloads.Payloads[0].HexBytes)
+	require.Nil(t, err)
+	compact, err := btcec.SignCompact(btcec.S256(), sk0.PrivateKey, sigHash, false)
+	require.Nil(t, err)
+	sig := append(compact[1:], compact[0]-27)
+	signatures := []*Signature{{
+		SigningPayload: payloads.Payloads[0],
+		PublicKey:      &PublicKey{HexBytes: hex.EncodeToString(sk0.Public().Bytes()), CurveType: "secp256k1"},
+		SignatureType:  "ecdsa_recovery",
+		HexBytes:       hex.EncodeToString(sig),
+	}}
+	var combine ConstructionCombineResponse
+	e = doRosetta(t, rosetta, "/construction/combine", ConstructionCombineRequest{
+		NetworkIdentifier:   testNetwork,
+		UnsignedTransaction: payloads.UnsignedTransaction,
+		Signatures:          signatures,
+	}, &combine)
+	require.Nil(t, e)
+
+	e = doRosetta(t, rosetta, "/construction/parse", ConstructionParseRequest{
+		NetworkIdentifier: testNetwork,
+		Signed:            true,
+		Transaction:       combine.SignedTransaction,
+	}, &parse)
+	require.Nil(t, e)
+	assert.Equal(t, []string{addr0.String()}, parse.Signers)
+
+	var hash, submit TransactionIdentifierResponse
+	e = doRosetta(t, rosetta, "/construction/hash", ConstructionHashRequest{
+		NetworkIdentifier: testNetwork,
+		SignedTransaction: combine.SignedTransaction,
+	}, &hash)
+	require.Nil(t, e)
+	e = doRosetta(t, rosetta, "/construction/submit", ConstructionHashRequest{
+		NetworkIdentifier: testNetwork,
+		SignedTransaction: combine.SignedTransaction,
+	}, &submit)
+	require.Nil(t, e)
+	assert.Equal(t, hash, submit)
+
+	w := doRequest(api, "GET", "/balance/"+addr1.String(), "")
+	assert.Contains(t, w.Body.String(), `"balance":4`)
+
+	// a signature of another key is rejected
+	sk1, err := btcec.NewPrivateKey(btcec.S256())
+	require.Nil(t, err)
+	compact, err = btcec.SignCompact(btcec.S256(), sk1, sigHash, false)
+	require.Nil(t, err)
+	signatures[0].HexBytes = hex.EncodeToString(append(compact[1:], compact[0]-27))
+	e = doRosetta(t, rosetta, "/construction/combine", ConstructionCombineRequest{
+		NetworkIdentifier:   testNetwork,
+		UnsignedTransaction: payloads.UnsignedTransaction,
+		Signatures:          signatures,
+	}, &combine)
+	require.NotNil(t, e)
+	assert.Equal(t, ErrRosettaSignature.Code, e.Code)
+
+	// mints can not be constructed
+	ops[0].Type = OpMint
+	e = doRosetta(t, rosetta, "/construction/preprocess", ConstructionPreprocessRequest{
+		NetworkIdentifier: testNetwork,
+		Operations:        ops,
+	}, &preprocess)
+	require.NotNil(t, e)
+	assert.Equal(t, ErrRosettaOperations.Code, e.Code)
+}
+
+func TestRosettaAuth(t *testing.T) {
+	cfg := &Config{
+		Keys: []APIKey{
+			{Name: "reader", Key: "k-read", Scopes: []string{ScopeRead}},
+			{Name: "wallet", Key: "k-submit", Scopes: []string{ScopeRead, ScopeSubmit}},
+		},
+		IPRateLimit: RateLimit{Rate: 1, Burst: 1},
+	}
+	newTestApiWithConfig(t, cfg)
+	rosetta := ServeRosetta(testNetwork.Network, cfg)
+	withKey := func(key string) map[string]string {
+		return map[string]string{APIKEYHEADER: key}
+	}
+	list := `{"metadata": {}}`
+	submit := `{"network_identifier": {"blockchain": "kvartalochain", "network": "` +
+		testNetwork.Network + `"}, "signed_transaction": "zz"}`
+
+	w := doRequestHeaders(rosetta, "POST", "/network/list", list, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = doRequestHeaders(rosetta, "POST", "/network/list", list, withKey("k-read"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequestHeaders(rosetta, "POST", "/construction/submit", submit, withKey("k-read"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	// an invalid tx, once the key is allowed
+	w = doRequestHeaders(rosetta, "POST", "/construction/submit", submit, withKey("k-submit"))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// the requests without key are rate limited
+	w = doRequestHeaders(rosetta, "POST", "/network/list", list, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
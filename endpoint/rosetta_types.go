@@ -0,0 +1,254 @@
+package endpoint
+
+// The models of the Rosetta API (https://www.rosetta-api.org) used by the
+// Rosetta server, with the field names of the specification
+
+type NetworkIdentifier struct {
+	Blockchain string `json:"blockchain"`
+	Network    string `json:"network"`
+}
+
+type BlockIdentifier struct {
+	Index int64  `json:"index"`
+	Hash  string `json:"hash"`
+}
+
+// PartialBlockIdentifier selects a block by index or hash, or the current
+// block if both are empty
+type PartialBlockIdentifier struct {
+	Index *int64  `json:"index,omitempty"`
+	Hash  *string `json:"hash,omitempty"`
+}
+
+type TransactionIdentifier struct {
+	Hash string `json:"hash"`
+}
+
+type AccountIdentifier struct {
+	Address string `json:"address"`
+}
+
+type Currency struct {
+	Symbol   string `json:"symbol"`
+	Decimals int32  `json:"decimals"`
+}
+
+// Amount is a signed integer value in the smallest unit of the Currency
+type Amount struct {
+	Value    string    `json:"value"`
+	Currency *Currency `json:"currency"`
+}
+
+type OperationIdentifier struct {
+	Index int64 `json:"index"`
+}
+
+type Operation struct {
+	OperationIdentifier *OperationIdentifier   `json:"operation_identifier"`
+	RelatedOperations   []*OperationIdentifier `json:"related_operations,omitempty"`
+	Type                string                 `json:"type"`
+	Status              string                 `json:"status,omitempty"`
+	Account             *AccountIdentifier     `json:"account,omitempty"`
+	Amount              *Amount                `json:"amount,omitempty"`
+}
+
+type Transaction struct {
+	TransactionIdentifier *TransactionIdentifier `json:"transaction_identifier"`
+	Operations            []*Operation           `json:"operations"`
+}
+
+type Block struct {
+	BlockIdentifier       *BlockIdentifier `json:"block_identifier"`
+	ParentBlockIdentifier *BlockIdentifier `json:"parent_block_identifier"`
+	// Timestamp is in milliseconds since the Unix epoch
+	Timestamp    int64          `json:"timestamp"`
+	Transactions []*Transaction `json:"transactions"`
+}
+
+type PublicKey struct {
+	HexBytes  string `json:"hex_bytes"`
+	CurveType string `json:"curve_type"`
+}
+
+type SigningPayload struct {
+	Address       string `json:"address"`
+	HexBytes      string `json:"hex_bytes"`
+	SignatureType string `json:"signature_type,omitempty"`
+}
+
+type Signature struct {
+	SigningPayload *SigningPayload `json:"signing_payload"`
+	PublicKey      *PublicKey      `json:"public_key"`
+	SignatureType  string          `json:"signature_type"`
+	HexBytes       string          `json:"hex_bytes"`
+}
+
+type OperationStatus struct {
+	Status     string `json:"status"`
+	Successful bool   `json:"successful"`
+}
+
+type Version struct {
+	RosettaVersion string `json:"rosetta_version"`
+	NodeVersion    string `json:"node_version"`
+}
+
+type Allow struct {
+	OperationStatuses       []*OperationStatus `json:"operation_statuses"`
+	OperationTypes          []string           `json:"operation_types"`
+	Errors                  []*RosettaError    `json:"errors"`
+	HistoricalBalanceLookup bool               `json:"historical_balance_lookup"`
+}
+
+type Peer struct {
+	PeerID string `json:"peer_id"`
+}
+
+// RosettaError is the body of the failed Rosetta requests
+type RosettaError struct {
+	Code      int32                  `json:"code"`
+	Message   string                 `json:"message"`
+	Retriable bool                   `json:"retriable"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+type NetworkRequest struct {
+	NetworkIdentifier *NetworkIdentifier `json:"network_identifier"`
+}
+
+type NetworkListResponse struct {
+	NetworkIdentifiers []*NetworkIdentifier `json:"network_identifiers"`
+}
+
+type NetworkOptionsResponse struct {
+	Version *Version `json:"version"`
+	Allow   *Allow   `json:"allow"`
+}
+
+type NetworkStatusResponse struct {
+	CurrentBlockIdentifier *BlockIdentifier `json:"current_block_identifier"`
+	CurrentBlockTimestamp  int64            `json:"current_block_timestamp"`
+	GenesisBlockIdentifier *BlockIdentifier `json:"genesis_block_identifier"`
+	Peers                  []*Peer          `json:"peers"`
+}
+
+type AccountBalanceRequest struct {
+	NetworkIdentifier *NetworkIdentifier      `json:"network_identifier"`
+	AccountIdentifier *AccountIdentifier      `json:"account_identifier"`
+	BlockIdentifier   *PartialBlockIdentifier `json:"block_identifier,omitempty"`
+}
+
+type AccountBalanceResponse struct {
+	BlockIdentifier *BlockIdentifier       `json:"block_identifier"`
+	Balances        []*Amount              `json:"balances"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
+}
+
+type BlockRequest struct {
+	NetworkIdentifier *NetworkIdentifier      `json:"network_identifier"`
+	BlockIdentifier   *PartialBlockIdentifier `json:"block_identifier"`
+}
+
+type BlockResponse struct {
+	Block *Block `json:"block"`
+}
+
+type BlockTransactionRequest struct {
+	NetworkIdentifier     *NetworkIdentifier     `json:"network_identifier"`
+	BlockIdentifier       *BlockIdentifier       `json:"block_identifier"`
+	TransactionIdentifier *TransactionIdentifier `json:"transaction_identifier"`
+}
+
+type TransactionResponse struct {
+	Transaction *Transaction `json:"transaction"`
+}
+
+type MempoolResponse struct {
+	TransactionIdentifiers []*TransactionIdentifier `json:"transaction_identifiers"`
+}
+
+type MempoolTransactionRequest struct {
+	NetworkIdentifier     *NetworkIdentifier     `json:"network_identifier"`
+	TransactionIdentifier *TransactionIdentifier `json:"transaction_identifier"`
+}
+
+type ConstructionDeriveRequest struct {
+	NetworkIdentifier *NetworkIdentifier `json:"network_identifier"`
+	PublicKey         *PublicKey         `json:"public_key"`
+}
+
+type ConstructionDeriveResponse struct {
+	Address string `json:"address"`
+}
+
+type ConstructionPreprocessRequest struct {
+	NetworkIdentifier *NetworkIdentifier `json:"network_identifier"`
+	Operations        []*Operation       `json:"operations"`
+}
+
+// ConstructionOptions are the options of /construction/preprocess, sent to
+// /construction/metadata
+type ConstructionOptions struct {
+	From string `json:"from"`
+}
+
+type ConstructionPreprocessResponse struct {
+	Options *ConstructionOptions `json:"options"`
+}
+
+type ConstructionMetadataRequest struct {
+	NetworkIdentifier *NetworkIdentifier   `json:"network_identifier"`
+	Options           *ConstructionOptions `json:"options"`
+}
+
+// ConstructionMetadata is the metadata of /construction/metadata, sent to
+// /construction/payloads
+type ConstructionMetadata struct {
+	Nonce uint64 `json:"nonce"`
+}
+
+type ConstructionMetadataResponse struct {
+	Metadata *ConstructionMetadata `json:"metadata"`
+}
+
+type ConstructionPayloadsRequest struct {
+	NetworkIdentifier *NetworkIdentifier    `json:"network_identifier"`
+	Operations        []*Operation          `json:"operations"`
+	Metadata          *ConstructionMetadata `json:"metadata"`
+}
+
+type ConstructionPayloadsResponse struct {
+	UnsignedTransaction string            `json:"unsigned_transaction"`
+	Payloads            []*SigningPayload `json:"payloads"`
+}
+
+type ConstructionCombineRequest struct {
+	NetworkIdentifier   *NetworkIdentifier `json:"network_identifier"`
+	UnsignedTransaction string             `json:"unsigned_transaction"`
+	Signatures          []*Signature       `json:"signatures"`
+}
+
+type ConstructionCombineResponse struct {
+	SignedTransaction string `json:"signed_transaction"`
+}
+
+type ConstructionParseRequest struct {
+	NetworkIdentifier *NetworkIdentifier `json:"network_identifier"`
+	Signed            bool               `json:"signed"`
+	Transaction       string             `json:"transaction"`
+}
+
+type ConstructionParseResponse struct {
+	Operations []*Operation `json:"operations"`
+	Signers    []string     `json:"signers"`
+}
+
+// ConstructionHashRequest is also the request of /construction/submit
+type ConstructionHashRequest struct {
+	NetworkIdentifier *NetworkIdentifier `json:"network_identifier"`
+	SignedTransaction string             `json:"signed_transaction"`
+}
+
+type TransactionIdentifierResponse struct {
+	TransactionIdentifier *TransactionIdentifier `json:"transaction_identifier"`
+}
@@ -1,29 +1,115 @@
 package endpoint
 
 import (
+	"time"
+
+	"kvartalochain/chain"
 	"kvartalochain/storage"
+	"kvartalochain/webhook"
 
-	"github.com/dgraph-io/badger"
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	tmlog "github.com/tendermint/tendermint/libs/log"
 )
 
-var db *storage.Storage
-var archiveDb *badger.DB
-
-func newApiService() *gin.Engine {
-	api := gin.Default()
-	api.Use(cors.Default())
-	api.GET("/info", handleInfo)
-	api.GET("/balance/:addr", handleGetBalance)
-	api.GET("/nonce/:addr", handleGetNonce)
-	api.POST("/tx", handlePostTx)
-	api.GET("/history/:addr", handleGetHistory)
+var abciApp *chain.KvartaloABCI
+var db storage.StateDB
+var archiveDb storage.ArchiveDB
+var tmClient NodeClient
+var webhooks *webhook.Notifier
+var tracker *Tracker
+var logger tmlog.Logger = tmlog.NewNopLogger()
+
+// newEngine returns a gin engine that logs the requests with logger
+func newEngine() *gin.Engine {
+	engine := gin.New()
+	// the X-Forwarded-For header is only read from the trusted proxies
+	engine.ForwardedByClientIP = false
+	engine.Use(gin.Recovery())
+	engine.Use(logRequests)
+	return engine
+}
+
+// logRequests logs each request once it is answered, the failed ones as
+// errors
+func logRequests(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	status := c.Writer.Status()
+	ip := c.GetString("clientip")
+	if ip == "" {
+		ip = c.ClientIP()
+	}
+	keyvals := []interface{}{"method", c.Request.Method, "path", c.Request.URL.Path,
+		"status", status, "duration", time.Since(start), "ip", ip}
+	if len(c.Errors) > 0 {
+		keyvals = append(keyvals, "err", c.Errors.String())
+	}
+	if status >= 500 {
+		logger.Error("api request failed", keyvals...)
+		return
+	}
+	logger.Info("api request", keyvals...)
+}
+
+func newApiService(cfg *Config) *gin.Engine {
+	api := newEngine()
+	metrics := cfg.Metrics
+	if metrics == nil {
+		metrics = NopMetrics()
+	}
+	api.Use(instrument(metrics))
+	// the probes of the load balancers are registered before the rate
+	// limit and the auth, so that they are never throttled
+	api.GET("/healthz", handleHealthz)
+	api.GET("/readyz", handleReadyz)
+	api.Use(corsMiddleware(cfg))
+	api.Use(rateLimit(cfg))
+	api.Use(authenticate(cfg))
+	api.GET("/openapi.json", handleOpenAPI)
+
+	read := api.Group("/", requireScope(cfg, ScopeRead))
+	read.GET("/info", handleInfo)
+	read.GET("/balance/:addr", handleGetBalance)
+	read.GET("/nonce/:addr", handleGetNonce)
+	read.GET("/mempool/:addr", handleGetMempool)
+	read.POST("/tx/simulate", handleSimulateTx)
+	read.POST("/tx/build", handleBuildTx)
+	read.GET("/tx/:hash", handleGetTx)
+	read.GET("/tx/:hash/status", handleGetTxStatus)
+	read.GET("/history/:addr", handleGetHistory)
+	read.GET("/supply", handleGetSupply)
+	read.GET("/accounts/top", handleGetTopAccounts)
+	read.GET("/stats", handleGetStats)
+	read.GET("/events", handleEvents)
+	read.POST("/graphql", handleGraphQL)
+
+	submit := api.Group("/", requireScope(cfg, ScopeSubmit))
+	submit.POST("/tx", handlePostTx)
+	submit.POST("/tx/submit", handleSubmitTx)
+	submit.POST("/txs", handlePostTxs)
+
+	admin := api.Group("/", requireScope(cfg, ScopeAdmin))
+	admin.POST("/webhooks", handlePostWebhook)
+	admin.GET("/webhooks", handleGetWebhooks)
+	admin.DELETE("/webhooks/:id", handleDeleteWebhook)
+	admin.GET("/webhooks/:id/deliveries", handleGetDeliveries)
 	return api
 }
 
-func Serve(sto *storage.Storage, badgerdb *badger.DB) *gin.Engine {
+// Serve returns the api service of the app. A nil cfg serves an open api.
+func Serve(app *chain.KvartaloABCI, sto storage.StateDB, archive storage.ArchiveDB, client NodeClient, notifier *webhook.Notifier, txTracker *Tracker, cfg *Config) *gin.Engine {
+	abciApp = app
 	db = sto
-	archiveDb = badgerdb
-	return newApiService()
+	archiveDb = archive
+	tmClient = client
+	webhooks = notifier
+	tracker = txTracker
+	topHolders = &holdersCache{}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	if cfg.Logger != nil {
+		logger = cfg.Logger
+	}
+	return newApiService(cfg)
 }
@@ -0,0 +1,61 @@
+package endpoint
+
+import (
+	"fmt"
+
+	"kvartalochain/chain"
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SimulateTxMsg struct {
+	TxHex string `json:"txHex" binding:"required"`
+}
+
+// SimulateTxResultMsg is the result of a simulated tx. There are no fees in
+// the chain, so the result has no fee.
+type SimulateTxResultMsg struct {
+	Hash     string          `json:"hash"`
+	Code     uint32          `json:"code"`
+	Log      string          `json:"log"`
+	Tx       *common.Tx      `json:"tx,omitempty"`
+	Balances []GetBalanceMsg `json:"balances,omitempty"`
+}
+
+// handleSimulateTx runs a tx against a branch of the current state, without
+// broadcasting it. The http status is 200 when the tx could be simulated,
+// the validity of the tx is given by the result code.
+func handleSimulateTx(c *gin.Context) {
+	var m SimulateTxMsg
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	// the tx is in the same format as in POST /tx, the hex string is the
+	// raw Tendermint tx
+	txRaw := []byte(m.TxHex)
+	sim, err := chain.SimulateTx(db, txRaw)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	res := SimulateTxResultMsg{
+		Hash: fmt.Sprintf("%X", storage.TxHash(txRaw)),
+		Code: sim.Code,
+		Log:  sim.Log,
+		Tx:   sim.Tx,
+	}
+	if sim.Tx != nil {
+		res.Balances = append(res.Balances, GetBalanceMsg{Addr: sim.Tx.From, Balance: sim.Balances[sim.Tx.From]})
+		if sim.Tx.To != sim.Tx.From {
+			res.Balances = append(res.Balances, GetBalanceMsg{Addr: sim.Tx.To, Balance: sim.Balances[sim.Tx.To]})
+		}
+	}
+	c.JSON(200, res)
+}
@@ -0,0 +1,90 @@
+package endpoint
+
+import (
+	"fmt"
+	"time"
+
+	"kvartalochain/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxStatsIntervals is the maximum number of intervals returned by /stats
+const maxStatsIntervals = 1000
+
+// defaultStatsIntervals is the number of intervals returned when from is not
+// given
+const defaultStatsIntervals = 30
+
+// parseStatsTime parses a date in the form 2006-01-02, or a RFC3339 time
+func parseStatsTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// handleGetStats returns the economic stats of each interval (day or week)
+// between the from and to query parameters
+func handleGetStats(c *gin.Context) {
+	interval := c.DefaultQuery("interval", storage.IntervalDay)
+	if !storage.ValidInterval(interval) {
+		c.JSON(400, gin.H{
+			"error": fmt.Sprintf("interval must be %s or %s", storage.IntervalDay, storage.IntervalWeek),
+		})
+		return
+	}
+
+	to := time.Now()
+	if toStr := c.Query("to"); toStr != "" {
+		var err error
+		if to, err = parseStatsTime(toStr); err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+	var from time.Time
+	if fromStr := c.Query("from"); fromStr != "" {
+		var err error
+		if from, err = parseStatsTime(fromStr); err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	} else if interval == storage.IntervalWeek {
+		from = to.AddDate(0, 0, -7*(defaultStatsIntervals-1))
+	} else {
+		from = to.AddDate(0, 0, -(defaultStatsIntervals - 1))
+	}
+	if from.After(to) {
+		c.JSON(400, gin.H{
+			"error": "from is after to",
+		})
+		return
+	}
+	days := to.Sub(from).Hours() / 24
+	if interval == storage.IntervalWeek {
+		days = days / 7
+	}
+	if days >= maxStatsIntervals {
+		c.JSON(400, gin.H{
+			"error": fmt.Sprintf("at most %d intervals can be requested", maxStatsIntervals),
+		})
+		return
+	}
+
+	stats, err := storage.GetStats(archiveDb, interval, from, to)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"interval": interval,
+		"stats":    stats,
+	})
+}
@@ -0,0 +1,140 @@
+package endpoint
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"kvartalochain/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NodeVersion is the version of the node returned by /info and by the
+// Rosetta api, set by the start command
+var NodeVersion = "dev"
+
+// readyMaxLag is the number of blocks that the state can be behind the
+// block store of the node and still be ready, as Tendermint stores each
+// block before the app commits it
+const readyMaxLag = 1
+
+type InfoMsg struct {
+	Version           string    `json:"version"`
+	TendermintVersion string    `json:"tendermintVersion"`
+	Network           string    `json:"network"`
+	LatestHeight      int64     `json:"latestHeight"`
+	LatestBlockHash   string    `json:"latestBlockHash"`
+	LatestAppHash     string    `json:"latestAppHash"`
+	LatestBlockTime   time.Time `json:"latestBlockTime"`
+	CatchingUp        bool      `json:"catchingUp"`
+	Peers             int       `json:"peers"`
+	MempoolSize       int       `json:"mempoolSize"`
+	// StateHeight is the last block committed to the state and
+	// ArchiveHeight the last block stored in the tx history archive
+	StateHeight   uint64 `json:"stateHeight"`
+	ArchiveHeight uint64 `json:"archiveHeight"`
+	// Archive is false when the node does not store the tx history
+	Archive bool `json:"archive"`
+}
+
+type HealthMsg struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// getInfo returns the status of the node and of the stores. It fails with
+// 502 if the node can not be reached.
+func getInfo() (*InfoMsg, error) {
+	status, err := tmClient.Status()
+	if err != nil {
+		return nil, newApiError(http.StatusBadGateway, fmt.Errorf("node status: %w", err))
+	}
+	netInfo, err := tmClient.NetInfo()
+	if err != nil {
+		return nil, newApiError(http.StatusBadGateway, fmt.Errorf("node net info: %w", err))
+	}
+	mempool, err := tmClient.NumUnconfirmedTxs()
+	if err != nil {
+		return nil, newApiError(http.StatusBadGateway, fmt.Errorf("node mempool: %w", err))
+	}
+	archiveHeight, err := storage.GetArchiveHeight(archiveDb)
+	if err != nil {
+		return nil, err
+	}
+	return &InfoMsg{
+		Version:           NodeVersion,
+		TendermintVersion: status.NodeInfo.Version,
+		Network:           status.NodeInfo.Network,
+		LatestHeight:      status.SyncInfo.LatestBlockHeight,
+		LatestBlockHash:   status.SyncInfo.LatestBlockHash.String(),
+		LatestAppHash:     status.SyncInfo.LatestAppHash.String(),
+		LatestBlockTime:   status.SyncInfo.LatestBlockTime,
+		CatchingUp:        status.SyncInfo.CatchingUp,
+		Peers:             netInfo.NPeers,
+		MempoolSize:       mempool.Total,
+		StateHeight:       abciApp.Height(),
+		ArchiveHeight:     archiveHeight,
+		Archive:           abciApp.Archive(),
+	}, nil
+}
+
+// healthy returns an error if the node is down or catching up
+func healthy(info *InfoMsg) error {
+	if info.CatchingUp {
+		return fmt.Errorf("node is catching up, at height %d", info.LatestHeight)
+	}
+	return nil
+}
+
+// ready returns an error if the node is not healthy or the state or the
+// archive, when enabled, are behind the node
+func ready(info *InfoMsg) error {
+	if err := healthy(info); err != nil {
+		return err
+	}
+	if int64(info.StateHeight)+readyMaxLag < info.LatestHeight {
+		return fmt.Errorf("state is behind the node, at height %d of %d",
+			info.StateHeight, info.LatestHeight)
+	}
+	if info.Archive && info.ArchiveHeight < info.StateHeight {
+		return fmt.Errorf("archive is behind the state, at height %d of %d",
+			info.ArchiveHeight, info.StateHeight)
+	}
+	return nil
+}
+
+func handleInfo(c *gin.Context) {
+	info, err := getInfo()
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(200, info)
+}
+
+// writeHealth responds 200 if check passes, and 503 with the reason if not
+func writeHealth(c *gin.Context, check func(*InfoMsg) error) {
+	info, err := getInfo()
+	if err == nil {
+		err = check(info)
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, HealthMsg{Status: "unavailable", Error: err.Error()})
+		return
+	}
+	c.JSON(200, HealthMsg{Status: "ok"})
+}
+
+// handleHealthz is the liveness probe, it fails while the node is down or
+// syncing
+func handleHealthz(c *gin.Context) {
+	writeHealth(c, healthy)
+}
+
+// handleReadyz is the readiness probe, it fails also while the state or the
+// archive are behind the node, so that the load balancers only route to up to
+// date nodes
+func handleReadyz(c *gin.Context) {
+	writeHealth(c, ready)
+}
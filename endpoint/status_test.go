@@ -0,0 +1,91 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"kvartalochain/chain"
+	"kvartalochain/common"
+	"kvartalochain/storage"
+	"kvartalochain/webhook"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sto, err := storage.NewMemStorage()
+	require.Nil(t, err)
+	archive := storage.NewMemArchive()
+	app := chain.NewKvartaloApplication(sto, archive)
+	client := NewMockNodeClient(app)
+	txTracker, err := NewTracker(archive, client)
+	require.Nil(t, err)
+	cfg := &Config{Keys: []APIKey{{Name: "reader", Key: "k-read", Scopes: []string{ScopeRead}}}}
+	api := Serve(app, sto, archive, client, webhook.NewNotifier(archive), txTracker, cfg)
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	storage.SetAccount(sto, addr0, &storage.Account{Balance: 10})
+	tx := common.NewTx(addr0, addr0, 1, 0)
+	require.Nil(t, sk0.SignTx(tx))
+	_, err = client.BroadcastTxCommit([]byte(tx.Hex()))
+	require.Nil(t, err)
+	client.Mempool = append(client.Mempool, []byte(tx.Hex()))
+	client.Peers = 2
+
+	// the info needs the read scope, the probes are public
+	w := doRequest(api, "GET", "/info", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = doRequestHeaders(api, "GET", "/info", "", map[string]string{APIKEYHEADER: "k-read"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var info InfoMsg
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, NodeVersion, info.Version)
+	assert.Equal(t, int64(1), info.LatestHeight)
+	hash := mockBlockHash(1)
+	assert.Equal(t, fmt.Sprintf("%X", hash), info.LatestBlockHash)
+	assert.Equal(t, uint64(1), info.StateHeight)
+	assert.Equal(t, uint64(1), info.ArchiveHeight)
+	assert.True(t, info.Archive)
+	assert.Equal(t, 2, info.Peers)
+	assert.Equal(t, 1, info.MempoolSize)
+	assert.False(t, info.CatchingUp)
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		w = doRequest(api, "GET", path, "")
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Equal(t, `{"status":"ok"}`, w.Body.String())
+	}
+
+	client.CatchingUp = true
+	for _, path := range []string{"/healthz", "/readyz"} {
+		w = doRequest(api, "GET", path, "")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, path)
+		assert.Contains(t, w.Body.String(), "catching up")
+	}
+}
+
+func TestReady(t *testing.T) {
+	info := &InfoMsg{LatestHeight: 10, StateHeight: 10, ArchiveHeight: 10, Archive: true}
+	assert.Nil(t, ready(info))
+
+	// the block being committed
+	info.StateHeight, info.ArchiveHeight = 9, 9
+	assert.Nil(t, ready(info))
+
+	info.StateHeight, info.ArchiveHeight = 8, 8
+	assert.EqualError(t, ready(info), "state is behind the node, at height 8 of 10")
+	assert.Nil(t, healthy(info))
+
+	info.StateHeight, info.ArchiveHeight = 10, 7
+	assert.EqualError(t, ready(info), "archive is behind the state, at height 7 of 10")
+
+	// without archive its height is not checked
+	info.Archive = false
+	assert.Nil(t, ready(info))
+}
@@ -0,0 +1,115 @@
+package endpoint
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"kvartalochain/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultTopHolders = 10
+const maxTopHolders = 1000
+
+// holdersCache keeps the maxTopHolders holders of the last committed height,
+// as storage.TopHolders iterates all the accounts
+type holdersCache struct {
+	mutex   sync.Mutex
+	height  uint64
+	holders []storage.Holder
+}
+
+var topHolders = &holdersCache{}
+
+// top returns the n holders with the highest balance at the committed
+// height, computing them once per height. They are read from the committed
+// state, as the working state is modified by the txs of the next block.
+func (h *holdersCache) top(n int) ([]storage.Holder, error) {
+	committed, err := db.Committed()
+	if err != nil {
+		return nil, err
+	}
+	height := storage.GetStateHeight(committed)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.holders == nil || h.height != height {
+		holders, err := storage.TopHolders(committed, maxTopHolders)
+		if err != nil {
+			return nil, err
+		}
+		if holders == nil {
+			holders = []storage.Holder{}
+		}
+		h.height = height
+		h.holders = holders
+	}
+	if n > len(h.holders) {
+		n = len(h.holders)
+	}
+	return h.holders[:n], nil
+}
+
+type GetSupplyMsg struct {
+	Minted      uint64 `json:"minted"`
+	Burned      uint64 `json:"burned"`
+	Circulating uint64 `json:"circulating"`
+	Accounts    uint64 `json:"accounts"`
+}
+
+type GetTopAccountsMsg struct {
+	Accounts uint64           `json:"accounts"`
+	Top      []storage.Holder `json:"top"`
+}
+
+func handleGetSupply(c *gin.Context) {
+	supply, err := storage.GetSupply(db)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, GetSupplyMsg{
+		Minted:      supply.Minted,
+		Burned:      supply.Burned,
+		Circulating: supply.Circulating(),
+		Accounts:    supply.Accounts,
+	})
+}
+
+// handleGetTopAccounts returns the holders with the highest balance at the
+// last committed height, the number of holders is given by the n query
+// parameter
+func handleGetTopAccounts(c *gin.Context) {
+	n := defaultTopHolders
+	if nStr := c.Query("n"); nStr != "" {
+		var err error
+		n, err = strconv.Atoi(nStr)
+		if err != nil || n < 1 || n > maxTopHolders {
+			c.JSON(400, gin.H{
+				"error": fmt.Sprintf("n must be a number between 1 and %d", maxTopHolders),
+			})
+			return
+		}
+	}
+	supply, err := storage.GetSupply(db)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	top, err := topHolders.top(n)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, GetTopAccountsMsg{
+		Accounts: supply.Accounts,
+		Top:      top,
+	})
+}
@@ -0,0 +1,417 @@
+package endpoint
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"kvartalochain/chain"
+	"kvartalochain/storage"
+
+	"github.com/gin-gonic/gin"
+	tmlog "github.com/tendermint/tendermint/libs/log"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+/*
+	submitted txs format in the archive DB:
+		tracked txs:
+			key: PREFIXTRACKEDTX | tx hash
+			value: TrackedTx json
+		submission order, to drop the oldest txs:
+			key: PREFIXTRACKEDORDER | submitted at (8 bytes BE) | tx hash
+			value: [ 1 ]
+*/
+
+var PREFIXTRACKEDTX = []byte("trackedtx")
+var PREFIXTRACKEDORDER = []byte("trackedorder")
+
+// states of a TrackedTx
+const (
+	TxPending   = "pending"
+	TxMempool   = "mempool"
+	TxCommitted = "committed"
+	TxRejected  = "rejected"
+	TxExpired   = "expired"
+)
+
+// TrackedTx is a tx submitted through the api and its state. Height is set
+// when the tx is in a block, and Code and Log when it is rejected.
+type TrackedTx struct {
+	Hash         string    `json:"hash"`
+	TxHex        string    `json:"txHex"`
+	State        string    `json:"state"`
+	Height       int64     `json:"height,omitempty"`
+	Code         uint32    `json:"code,omitempty"`
+	Log          string    `json:"log,omitempty"`
+	Rebroadcasts int       `json:"rebroadcasts"`
+	SubmittedAt  time.Time `json:"submittedAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+func (t *TrackedTx) final() bool {
+	return t.State == TxCommitted || t.State == TxRejected || t.State == TxExpired
+}
+
+// Tracker follows the txs submitted through the api until they are
+// committed, rebroadcasting the txs that drop out of the mempool
+type Tracker struct {
+	db     storage.ArchiveDB
+	client NodeClient
+	mutex  sync.Mutex
+	count  int // number of tracked txs
+	stop   chan struct{}
+
+	// MaxTxs is the number of tracked txs, the oldest ones are dropped
+	MaxTxs int
+	// MaxRebroadcasts is the number of times a tx that is not in the
+	// mempool is broadcasted again before it expires
+	MaxRebroadcasts int
+	// CheckInterval is the interval to look for the txs in the mempool
+	CheckInterval time.Duration
+	Logger        tmlog.Logger
+}
+
+// NewTracker returns a Tracker that stores the txs in db and checks them
+// with client
+func NewTracker(db storage.ArchiveDB, client NodeClient) (*Tracker, error) {
+	t := &Tracker{
+		db:              db,
+		client:          client,
+		stop:            make(chan struct{}),
+		MaxTxs:          10000,
+		MaxRebroadcasts: 3,
+		CheckInterval:   10 * time.Second,
+		Logger:          tmlog.NewNopLogger(),
+	}
+	err := db.Iterate(PREFIXTRACKEDORDER, func(k, v []byte) bool {
+		t.count++
+		return false
+	})
+	return t, err
+}
+
+func trackedKey(hash string) []byte {
+	return append(append([]byte{}, PREFIXTRACKEDTX...), hash...)
+}
+
+func orderKey(tracked *TrackedTx) []byte {
+	var submittedAt [8]byte
+	binary.BigEndian.PutUint64(submittedAt[:], uint64(tracked.SubmittedAt.UnixNano()))
+	key := append(append([]byte{}, PREFIXTRACKEDORDER...), submittedAt[:]...)
+	return append(key, tracked.Hash...)
+}
+
+// Get returns the TrackedTx with the given hash, or nil if it is not tracked
+func (t *Tracker) Get(hash string) (*TrackedTx, error) {
+	b, err := t.db.Get(trackedKey(hash))
+	if err != nil || b == nil {
+		return nil, err
+	}
+	var tracked TrackedTx
+	err = json.Unmarshal(b, &tracked)
+	return &tracked, err
+}
+
+// Track starts to follow a submitted tx in the given state. A committed tx
+// keeps its state if it is submitted again.
+func (t *Tracker) Track(tx tmtypes.Tx, state string, height int64, code uint32, log string) error {
+	now := time.Now()
+	tracked := &TrackedTx{
+		Hash:        fmt.Sprintf("%X", tx.Hash()),
+		TxHex:       string(tx),
+		State:       state,
+		Height:      height,
+		Code:        code,
+		Log:         log,
+		SubmittedAt: now,
+		UpdatedAt:   now,
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	previous, err := t.Get(tracked.Hash)
+	if err != nil {
+		return err
+	}
+	batch := t.db.NewBatch()
+	if previous != nil && previous.State == TxCommitted {
+		batch.Discard()
+		return nil
+	}
+	if previous != nil {
+		// a tx submitted again keeps its position
+		tracked.SubmittedAt = previous.SubmittedAt
+		tracked.Rebroadcasts = previous.Rebroadcasts
+	} else {
+		// the tx can be committed before it is tracked
+		archived, err := storage.GetTxByHash(t.db, tx.Hash())
+		if err != nil {
+			batch.Discard()
+			return err
+		}
+		if archived != nil {
+			tracked.State = TxCommitted
+			tracked.Height = int64(archived.Height)
+		}
+		if err := batch.Set(orderKey(tracked), []byte{1}); err != nil {
+			batch.Discard()
+			return err
+		}
+	}
+	if err := t.put(batch, tracked); err != nil {
+		batch.Discard()
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	if previous == nil {
+		t.count++
+	}
+	return t.prune()
+}
+
+func (t *Tracker) put(batch storage.ArchiveBatch, tracked *TrackedTx) error {
+	b, err := json.Marshal(tracked)
+	if err != nil {
+		return err
+	}
+	return batch.Set(trackedKey(tracked.Hash), b)
+}
+
+// update stores a change of state of a tracked tx. A committed tx is not
+// updated anymore.
+func (t *Tracker) update(tracked *TrackedTx) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// the tx could have been dropped or committed while it was checked
+	stored, err := t.Get(tracked.Hash)
+	if err != nil || stored == nil || stored.State == TxCommitted {
+		return err
+	}
+	tracked.UpdatedAt = time.Now()
+	batch := t.db.NewBatch()
+	if err := t.put(batch, tracked); err != nil {
+		batch.Discard()
+		return err
+	}
+	return batch.Commit()
+}
+
+// prune drops the oldest txs over MaxTxs. The mutex must be held.
+func (t *Tracker) prune() error {
+	if t.count <= t.MaxTxs {
+		return nil
+	}
+	var keys [][]byte
+	err := t.db.Iterate(PREFIXTRACKEDORDER, func(k, v []byte) bool {
+		keys = append(keys, k)
+		return len(keys) >= t.count-t.MaxTxs
+	})
+	if err != nil {
+		return err
+	}
+	batch := t.db.NewBatch()
+	for _, k := range keys {
+		hash := k[len(PREFIXTRACKEDORDER)+8:]
+		if err := batch.Delete(k); err != nil {
+			batch.Discard()
+			return err
+		}
+		if err := batch.Delete(trackedKey(string(hash))); err != nil {
+			batch.Discard()
+			return err
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	t.count -= len(keys)
+	return nil
+}
+
+// OnCommit is a chain.CommitListener that marks the tracked txs of the block
+// as committed, or as rejected at the height if their DeliverTx failed
+func (t *Tracker) OnCommit(height uint64, txs []chain.CommittedTx) {
+	for _, committed := range txs {
+		tracked, err := t.Get(fmt.Sprintf("%X", committed.Hash))
+		if err != nil || tracked == nil || tracked.State == TxCommitted {
+			continue
+		}
+		tracked.State = TxCommitted
+		if committed.Failed() {
+			tracked.State = TxRejected
+			tracked.Code = committed.Code
+			tracked.Log = committed.Log
+		}
+		tracked.Height = int64(height)
+		if err := t.update(tracked); err != nil {
+			t.Logger.Error("failed to update the tracked tx", "height", height, "hash", tracked.Hash, "err", err)
+		}
+	}
+}
+
+// Start runs the routine that checks the txs that are not committed yet,
+// until Stop is called
+func (t *Tracker) Start() {
+	go func() {
+		ticker := time.NewTicker(t.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				if err := t.check(); err != nil {
+					t.Logger.Error("failed to check the tracked txs", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+func (t *Tracker) Stop() {
+	close(t.stop)
+}
+
+// check updates the state of the txs that are not committed, rebroadcasting
+// the ones that are not in the mempool
+func (t *Tracker) check() error {
+	var waiting []*TrackedTx
+	var err error
+	iterErr := t.db.Iterate(PREFIXTRACKEDTX, func(k, v []byte) bool {
+		var tracked TrackedTx
+		if err = json.Unmarshal(v, &tracked); err != nil {
+			return true
+		}
+		if !tracked.final() {
+			waiting = append(waiting, &tracked)
+		}
+		return false
+	})
+	if iterErr != nil {
+		return iterErr
+	}
+	if err != nil || len(waiting) == 0 {
+		return err
+	}
+
+	mempool, err := t.client.UnconfirmedTxs(mempoolLimit)
+	if err != nil {
+		return err
+	}
+	inMempool := make(map[string]bool)
+	for _, tx := range mempool.Txs {
+		inMempool[fmt.Sprintf("%X", tx.Hash())] = true
+	}
+	// if only a part of the mempool is known, the txs that are not seen
+	// can still be in the mempool
+	fullMempool := mempool.Count == mempool.Total
+
+	for _, tracked := range waiting {
+		previous := *tracked
+		hash, err := hex.DecodeString(tracked.Hash)
+		if err != nil {
+			continue
+		}
+		archived, err := storage.GetTxByHash(t.db, hash)
+		if err != nil {
+			continue
+		}
+		switch {
+		case archived != nil:
+			tracked.State = TxCommitted
+			tracked.Height = int64(archived.Height)
+		case inMempool[tracked.Hash]:
+			tracked.State = TxMempool
+		case !fullMempool:
+			continue
+		case tracked.Rebroadcasts >= t.MaxRebroadcasts:
+			tracked.State = TxExpired
+			t.Logger.Info("tracked tx expired", "hash", tracked.Hash, "rebroadcasts", tracked.Rebroadcasts)
+		default:
+			// the attempt is stored before the broadcast, as the tx
+			// can be committed before the broadcast returns
+			tracked.Rebroadcasts++
+			if err := t.update(tracked); err != nil {
+				return err
+			}
+			t.Logger.Info("rebroadcasting tx", "hash", tracked.Hash, "rebroadcasts", tracked.Rebroadcasts)
+			res, err := t.client.BroadcastTxSync(tmtypes.Tx(tracked.TxHex))
+			if err != nil {
+				// the node may be unavailable
+				t.Logger.Error("failed to rebroadcast the tx", "hash", tracked.Hash, "err", err)
+				continue
+			}
+			if res.Code != 0 {
+				tracked.State = TxRejected
+				tracked.Code = res.Code
+				tracked.Log = res.Log
+			} else {
+				tracked.State = TxMempool
+			}
+		}
+		if *tracked != previous {
+			if err := t.update(tracked); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// track adds a submitted tx to the tracker, logging the errors, as the tx
+// has already been broadcasted
+func track(tx tmtypes.Tx, state string, height int64, code uint32, log string) {
+	if err := tracker.Track(tx, state, height, code, log); err != nil {
+		tracker.Logger.Error("failed to track the tx", "hash", fmt.Sprintf("%X", tx.Hash()), "err", err)
+	}
+}
+
+// handleGetTxStatus returns the state of a submitted tx. The txs that are not
+// tracked are searched in the archive.
+func handleGetTxStatus(c *gin.Context) {
+	hash := strings.ToUpper(c.Param("hash"))
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	tracked, err := tracker.Get(hash)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if tracked != nil {
+		c.JSON(200, tracked)
+		return
+	}
+	archived, err := storage.GetTxByHash(archiveDb, hashBytes)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if archived == nil {
+		c.JSON(404, gin.H{
+			"error": "tx not found",
+		})
+		return
+	}
+	c.JSON(200, TrackedTx{
+		Hash:   hash,
+		TxHex:  hex.EncodeToString(archived.Tx.Bytes()),
+		State:  TxCommitted,
+		Height: int64(archived.Height),
+	})
+}
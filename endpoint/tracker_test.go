@@ -0,0 +1,115 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"kvartalochain/chain"
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+func getTxStatus(t *testing.T, hash string) *TrackedTx {
+	tracked, err := tracker.Get(hash)
+	require.Nil(t, err)
+	require.NotNil(t, tracked)
+	return tracked
+}
+
+func TestTracker(t *testing.T) {
+	api, sto := newTestApi(t)
+	mock := tmClient.(*MockNodeClient)
+
+	sk0 := common.ImportKeyString("2NqXcWAZXfCvkVBZLaFAQ1ksEnF6G4fYRSubmUMckXGG")
+	addr0 := sk0.Public().Address()
+	addr1, err := common.AddressFromString("HzeXxgjb589tVBs991jAyLUX7wreSZvrWnRxdGQS4co2")
+	require.Nil(t, err)
+	storage.SetAccount(sto, addr0, &storage.Account{Balance: 10})
+	signed := func(nonce uint64) tmtypes.Tx {
+		tx := common.NewTx(addr0, addr1, 1, nonce)
+		require.Nil(t, sk0.SignTx(tx))
+		return tmtypes.Tx(tx.Hex())
+	}
+
+	// the mock commits the async txs before they are tracked
+	tx := signed(0)
+	w := doRequest(api, "POST", "/tx", `{"txHex": "`+string(tx)+`", "mode": "async"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var res PostTxResultMsg
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	w = doRequest(api, "GET", "/tx/"+res.Hash+"/status", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var status TrackedTx
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, TxCommitted, status.State)
+	assert.Equal(t, int64(1), status.Height)
+
+	// the committed tx is not tracked again
+	w = doRequest(api, "POST", "/tx", `{"txHex": "`+string(tx)+`", "mode": "sync"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, TxCommitted, getTxStatus(t, res.Hash).State)
+
+	// rejected in the CheckTx
+	noFunds := common.NewTx(addr0, addr1, 100, 1)
+	require.Nil(t, sk0.SignTx(noFunds))
+	w = doRequest(api, "POST", "/tx", `{"txHex": "`+noFunds.Hex()+`", "mode": "sync"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	rejected := getTxStatus(t, fmtHash(tmtypes.Tx(noFunds.Hex())))
+	assert.Equal(t, TxRejected, rejected.State)
+	assert.Equal(t, chain.ERRNOFUNDS, rejected.Code)
+
+	// a tx in the mempool
+	inMempool := signed(2)
+	mock.Mempool = []tmtypes.Tx{inMempool}
+	require.Nil(t, tracker.Track(inMempool, TxPending, 0, 0, ""))
+	// a tx that has dropped out of the mempool, and is committed when
+	// rebroadcasted
+	dropped := signed(1)
+	require.Nil(t, tracker.Track(dropped, TxPending, 0, 0, ""))
+	require.Nil(t, tracker.check())
+	assert.Equal(t, TxMempool, getTxStatus(t, fmtHash(inMempool)).State)
+	droppedStatus := getTxStatus(t, fmtHash(dropped))
+	assert.Equal(t, TxCommitted, droppedStatus.State)
+	assert.Equal(t, 1, droppedStatus.Rebroadcasts)
+
+	// a tx that fails in the DeliverTx is rejected at its height
+	failed := signed(5)
+	require.Nil(t, tracker.Track(failed, TxMempool, 0, 0, ""))
+	tracker.OnCommit(7, []chain.CommittedTx{{Height: 7, Hash: failed.Hash(), Code: chain.ERRNONCE, Log: "invalid nonce"}})
+	failedStatus := getTxStatus(t, fmtHash(failed))
+	assert.Equal(t, TxRejected, failedStatus.State)
+	assert.Equal(t, int64(7), failedStatus.Height)
+	assert.Equal(t, chain.ERRNONCE, failedStatus.Code)
+
+	// a tx that is not in the mempool expires after the rebroadcasts
+	tracker.MaxRebroadcasts = 0
+	lost := signed(3)
+	require.Nil(t, tracker.Track(lost, TxMempool, 0, 0, ""))
+	mock.Mempool = nil
+	require.Nil(t, tracker.check())
+	assert.Equal(t, TxExpired, getTxStatus(t, fmtHash(lost)).State)
+
+	// the oldest txs are dropped
+	tracker.MaxTxs = 2
+	require.Nil(t, tracker.Track(signed(4), TxPending, 0, 0, ""))
+	tracked, err := tracker.Get(res.Hash)
+	require.Nil(t, err)
+	assert.Nil(t, tracked)
+	w = doRequest(api, "GET", "/tx/"+fmtHash(lost)+"/status", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	// the committed txs are found in the archive
+	w = doRequest(api, "GET", "/tx/"+res.Hash+"/status", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(api, "GET", "/tx/"+fmtHash(inMempool)+"/status", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func fmtHash(tx tmtypes.Tx) string {
+	return fmt.Sprintf("%X", tx.Hash())
+}
@@ -0,0 +1,194 @@
+package endpoint
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/gin-gonic/gin"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// maxBatchTxs is the maximum number of txs of a POST /txs request
+const maxBatchTxs = 1000
+
+// status of each tx of a POST /txs request
+const (
+	BatchTxBroadcast = "broadcast"
+	BatchTxInvalid   = "invalid"
+	BatchTxError     = "error"
+	BatchTxSkipped   = "skipped"
+)
+
+type PostTxsMsg struct {
+	Txs []string `json:"txs" binding:"required"`
+}
+
+// BatchTxResultMsg is the result of a tx of a POST /txs request. The
+// broadcasted txs can be polled at GET /tx/:hash/status.
+type BatchTxResultMsg struct {
+	Hash   string `json:"hash"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// TxMsg is a committed tx
+type TxMsg struct {
+	Hash   string     `json:"hash"`
+	Height uint64     `json:"height"`
+	Index  uint32     `json:"index"`
+	Tx     *common.Tx `json:"tx"`
+}
+
+// validateBatch checks in order the signature and the nonce of the txs. The
+// nonces of each sender must be sequential, starting at its pending nonce.
+// Returns the error of each tx, nil for the valid ones, or errPartialMempool
+// if the pending nonces are unknown.
+func validateBatch(txs []tmtypes.Tx) ([]error, error) {
+	mempool, partial, err := mempoolTxs()
+	if err != nil {
+		return nil, err
+	}
+	if partial {
+		return nil, errPartialMempool
+	}
+	var pending []*common.Tx
+	for _, txRaw := range mempool {
+		if tx, err := decodeTx(txRaw); err == nil {
+			pending = append(pending, tx)
+		}
+	}
+	errs := make([]error, len(txs))
+	nonces := make(map[common.Address]uint64)
+	// senders with an invalid tx, their next txs would have a nonce gap
+	failed := make(map[common.Address]bool)
+	for i, txRaw := range txs {
+		tx, err := decodeTx(txRaw)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if failed[tx.From] {
+			errs[i] = fmt.Errorf("a previous tx of the sender is invalid")
+			continue
+		}
+		if err := checkTxType(tx.Type); err != nil {
+			errs[i] = err
+		} else if !common.VerifySignatureTx(tx) {
+			errs[i] = fmt.Errorf("invalid signature")
+		}
+		if errs[i] != nil {
+			failed[tx.From] = true
+			continue
+		}
+		expected, ok := nonces[tx.From]
+		if !ok {
+			committed, err := storage.GetNonce(db, tx.From)
+			if err != nil {
+				errs[i] = err
+				failed[tx.From] = true
+				continue
+			}
+			expected = nextNonce(pending, tx.From, committed)
+		}
+		if tx.Nonce != expected {
+			errs[i] = fmt.Errorf("invalid nonce %d, expected %d", tx.Nonce, expected)
+			failed[tx.From] = true
+			continue
+		}
+		nonces[tx.From] = expected + 1
+	}
+	return errs, nil
+}
+
+// handlePostTxs validates a batch of txs in the format of POST /tx, and
+// broadcasts the valid ones without waiting for their CheckTx. When a
+// broadcast fails, the next txs of the sender are skipped, as they would
+// have a nonce gap.
+func handlePostTxs(c *gin.Context) {
+	var m PostTxsMsg
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if len(m.Txs) > maxBatchTxs {
+		c.JSON(400, gin.H{
+			"error": fmt.Sprintf("at most %d txs can be sent in a batch", maxBatchTxs),
+		})
+		return
+	}
+
+	txs := make([]tmtypes.Tx, len(m.Txs))
+	for i, txHex := range m.Txs {
+		txs[i] = tmtypes.Tx(txHex)
+	}
+	errs, err := validateBatch(txs)
+	if err != nil {
+		writeError(c, mempoolError(err))
+		return
+	}
+
+	results := make([]BatchTxResultMsg, len(txs))
+	failed := make(map[common.Address]bool)
+	for i, tx := range txs {
+		results[i].Hash = fmt.Sprintf("%X", tx.Hash())
+		if errs[i] != nil {
+			results[i].Status = BatchTxInvalid
+			results[i].Error = errs[i].Error()
+			continue
+		}
+		// the valid txs are decoded
+		decoded, _ := decodeTx(tx)
+		if failed[decoded.From] {
+			results[i].Status = BatchTxSkipped
+			results[i].Error = "the broadcast of a previous tx of the sender failed"
+			continue
+		}
+		if _, err := tmClient.BroadcastTxAsync(tx); err != nil {
+			results[i].Status = BatchTxError
+			results[i].Error = err.Error()
+			failed[decoded.From] = true
+			continue
+		}
+		results[i].Status = BatchTxBroadcast
+		track(tx, TxPending, 0, 0, "")
+	}
+	c.JSON(200, gin.H{
+		"txs": results,
+	})
+}
+
+// handleGetTx returns the committed tx with the given hash
+func handleGetTx(c *gin.Context) {
+	hash, err := hex.DecodeString(c.Param("hash"))
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	archived, err := storage.GetTxByHash(archiveDb, hash)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if archived == nil {
+		c.JSON(404, gin.H{
+			"error": "tx not found",
+		})
+		return
+	}
+	c.JSON(200, TxMsg{
+		Hash:   strings.ToUpper(c.Param("hash")),
+		Height: archived.Height,
+		Index:  archived.Index,
+		Tx:     archived.Tx,
+	})
+}
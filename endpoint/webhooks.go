@@ -0,0 +1,113 @@
+package endpoint
+
+import (
+	"kvartalochain/common"
+	"kvartalochain/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PostWebhookMsg struct {
+	Addr   common.Address `json:"addr" binding:"required"`
+	URL    string         `json:"url" binding:"required"`
+	Secret string         `json:"secret" binding:"required"`
+}
+
+func handlePostWebhook(c *gin.Context) {
+	var m PostWebhookMsg
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	hook, err := webhooks.Register(m.Addr, m.URL, m.Secret)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	hook.Secret = ""
+	c.JSON(201, hook)
+}
+
+func handleGetWebhooks(c *gin.Context) {
+	addr, err := common.AddressFromString(c.Query("addr"))
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	hooks, err := webhooks.List(addr)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	if hooks == nil {
+		hooks = []webhook.Webhook{}
+	}
+	c.JSON(200, gin.H{
+		"webhooks": hooks,
+	})
+}
+
+func handleDeleteWebhook(c *gin.Context) {
+	hook, err := webhooks.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if hook == nil {
+		c.JSON(404, gin.H{
+			"error": "webhook not found",
+		})
+		return
+	}
+	if err := webhooks.Remove(hook.ID); err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"status": "ok",
+	})
+}
+
+func handleGetDeliveries(c *gin.Context) {
+	hook, err := webhooks.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if hook == nil {
+		c.JSON(404, gin.H{
+			"error": "webhook not found",
+		})
+		return
+	}
+	deliveries, err := webhooks.Deliveries(hook.ID)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if deliveries == nil {
+		deliveries = []webhook.Delivery{}
+	}
+	c.JSON(200, gin.H{
+		"deliveries": deliveries,
+	})
+}
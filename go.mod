@@ -8,8 +8,11 @@ require (
 	github.com/dgraph-io/badger v1.6.1
 	github.com/gin-contrib/cors v1.3.1
 	github.com/gin-gonic/gin v1.6.3
+	github.com/go-kit/kit v0.10.0
+	github.com/golang/protobuf v1.4.0
+	github.com/graphql-go/graphql v0.7.9
 	github.com/pkg/errors v0.9.1
-	github.com/sirupsen/logrus v1.6.0
+	github.com/prometheus/client_golang v1.5.1
 	github.com/spf13/viper v1.7.0
 	github.com/stretchr/testify v1.5.1
 	github.com/tendermint/iavl v0.13.3
@@ -17,5 +20,7 @@ require (
 	github.com/tendermint/tm-db v0.5.1
 	github.com/urfave/cli v1.22.4
 	golang.org/x/crypto v0.0.0-20200510223506-06a226fb4e37
+	google.golang.org/grpc v1.28.1
+	google.golang.org/protobuf v1.21.0
 	gopkg.in/go-playground/assert.v1 v1.2.1
 )
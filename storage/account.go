@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// AccountVersion is the schema version of the encoded Account
+const AccountVersion = byte(1)
+
+// accountLenV1 is the length of an encoded Account of version 1
+const accountLenV1 = 1 + 8 + 8
+
+// Account is the state of an address
+type Account struct {
+	Balance uint64 `json:"balance"`
+	Nonce   uint64 `json:"nonce"`
+}
+
+/*
+	Account encoding:
+		[ version 1 byte | balance 8 bytes | nonce 8 bytes ]
+	new fields must be appended at the end, increasing the AccountVersion
+*/
+
+func (acc *Account) Bytes() []byte {
+	var b [accountLenV1]byte
+	b[0] = AccountVersion
+	binary.LittleEndian.PutUint64(b[1:9], acc.Balance)
+	binary.LittleEndian.PutUint64(b[9:17], acc.Nonce)
+	return b[:]
+}
+
+func AccountFromBytes(b []byte) (*Account, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("error on account bytes format")
+	}
+	switch b[0] {
+	case 1:
+		if len(b) != accountLenV1 {
+			return nil, fmt.Errorf("error on account bytes format")
+		}
+		return &Account{
+			Balance: binary.LittleEndian.Uint64(b[1:9]),
+			Nonce:   binary.LittleEndian.Uint64(b[9:17]),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown account version: %d", b[0])
+	}
+}
@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// ArchiveDB is the store used for the tx history archive
+type ArchiveDB interface {
+	// Get returns the value for k, or nil if k does not exist
+	Get(k []byte) ([]byte, error)
+	// Iterate calls fn for each key with the given prefix in order, until
+	// fn returns true
+	Iterate(prefix []byte, fn func(k, v []byte) (stop bool)) error
+	// NewBatch returns an ArchiveBatch, which writes are only stored
+	// after calling its Commit method
+	NewBatch() ArchiveBatch
+	Close() error
+}
+
+// ArchiveBatch groups the ArchiveDB writes of a block
+type ArchiveBatch interface {
+	// Get returns the value for k, including the writes of the batch, or
+	// nil if k does not exist
+	Get(k []byte) ([]byte, error)
+	Set(k, v []byte) error
+	Delete(k []byte) error
+	Commit() error
+	Discard()
+}
+
+// BadgerArchive is the ArchiveDB implementation over badger
+type BadgerArchive struct {
+	db *badger.DB
+}
+
+var _ ArchiveDB = (*BadgerArchive)(nil)
+
+func NewBadgerArchive(db *badger.DB) *BadgerArchive {
+	return &BadgerArchive{db: db}
+}
+
+func (a *BadgerArchive) Get(k []byte) ([]byte, error) {
+	var v []byte
+	err := a.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(k)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v, err = item.ValueCopy(nil)
+		return err
+	})
+	return v, err
+}
+
+func (a *BadgerArchive) Iterate(prefix []byte, fn func(k, v []byte) bool) error {
+	return a.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if fn(item.KeyCopy(nil), v) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (a *BadgerArchive) NewBatch() ArchiveBatch {
+	return &badgerBatch{a.db.NewTransaction(true)}
+}
+
+// Size returns the size in bytes of the LSM tree and of the value log of
+// the badger db
+func (a *BadgerArchive) Size() (lsm, vlog int64) {
+	return a.db.Size()
+}
+
+func (a *BadgerArchive) Close() error {
+	return a.db.Close()
+}
+
+type badgerBatch struct {
+	*badger.Txn
+}
+
+func (b *badgerBatch) Get(k []byte) ([]byte, error) {
+	item, err := b.Txn.Get(k)
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+// MemArchive is an in memory ArchiveDB implementation
+type MemArchive struct {
+	rw sync.RWMutex
+	kv map[string][]byte
+}
+
+var _ ArchiveDB = (*MemArchive)(nil)
+
+func NewMemArchive() *MemArchive {
+	return &MemArchive{kv: make(map[string][]byte)}
+}
+
+func (a *MemArchive) Get(k []byte) ([]byte, error) {
+	a.rw.RLock()
+	defer a.rw.RUnlock()
+	v, ok := a.kv[string(k)]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, v...), nil
+}
+
+func (a *MemArchive) Iterate(prefix []byte, fn func(k, v []byte) bool) error {
+	a.rw.RLock()
+	var keys []string
+	for k := range a.kv {
+		if strings.HasPrefix(k, string(prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	a.rw.RUnlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, err := a.Get([]byte(k))
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			continue // deleted while iterating
+		}
+		if fn([]byte(k), v) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (a *MemArchive) NewBatch() ArchiveBatch {
+	return &memBatch{db: a, write: a.write, kv: make(map[string][]byte)}
+}
+
+func (a *MemArchive) write(kv map[string][]byte) {
+	a.rw.Lock()
+	defer a.rw.Unlock()
+	for k, v := range kv {
+		if v == nil {
+			delete(a.kv, k)
+			continue
+		}
+		a.kv[k] = v
+	}
+}
+
+func (a *MemArchive) Close() error {
+	return nil
+}
+
+// memBatch keeps the writes in memory until they are committed with write
+type memBatch struct {
+	db    ArchiveDB
+	write func(kv map[string][]byte)
+	kv    map[string][]byte // a nil value is a deleted key
+}
+
+func (b *memBatch) Get(k []byte) ([]byte, error) {
+	if v, ok := b.kv[string(k)]; ok {
+		if v == nil {
+			return nil, nil
+		}
+		return append([]byte{}, v...), nil
+	}
+	return b.db.Get(k)
+}
+
+func (b *memBatch) Set(k, v []byte) error {
+	b.kv[string(k)] = append([]byte{}, v...)
+	return nil
+}
+
+func (b *memBatch) Delete(k []byte) error {
+	b.kv[string(k)] = nil
+	return nil
+}
+
+func (b *memBatch) Commit() error {
+	b.write(b.kv)
+	b.kv = make(map[string][]byte)
+	return nil
+}
+
+func (b *memBatch) Discard() {
+	b.kv = make(map[string][]byte)
+}
+
+// overlayArchive is an ArchiveDB that keeps its writes in memory, over an
+// ArchiveDB that is only read. It runs the migrations in dry run.
+type overlayArchive struct {
+	base ArchiveDB
+	kv   map[string][]byte // a nil value is a deleted key
+}
+
+var _ ArchiveDB = (*overlayArchive)(nil)
+
+func newOverlayArchive(base ArchiveDB) *overlayArchive {
+	return &overlayArchive{base: base, kv: make(map[string][]byte)}
+}
+
+func (a *overlayArchive) Get(k []byte) ([]byte, error) {
+	if v, ok := a.kv[string(k)]; ok {
+		if v == nil {
+			return nil, nil
+		}
+		return append([]byte{}, v...), nil
+	}
+	return a.base.Get(k)
+}
+
+// Iterate merges in order the keys of the base and the ones written in the
+// overlay, which take precedence
+func (a *overlayArchive) Iterate(prefix []byte, fn func(k, v []byte) bool) error {
+	var keys []string
+	for k := range a.kv {
+		if strings.HasPrefix(k, string(prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	// emit calls fn with the overlay key i, skipping the deleted ones
+	emit := func(i int) bool {
+		v := a.kv[keys[i]]
+		return v != nil && fn([]byte(keys[i]), v)
+	}
+	i := 0
+	stopped := false
+	err := a.base.Iterate(prefix, func(k, v []byte) bool {
+		for ; i < len(keys) && keys[i] < string(k); i++ {
+			if emit(i) {
+				stopped = true
+				return true
+			}
+		}
+		if i < len(keys) && keys[i] == string(k) {
+			i++
+			stopped = emit(i - 1)
+			return stopped
+		}
+		stopped = fn(k, v)
+		return stopped
+	})
+	if err != nil || stopped {
+		return err
+	}
+	for ; i < len(keys); i++ {
+		if emit(i) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (a *overlayArchive) NewBatch() ArchiveBatch {
+	return &memBatch{db: a, write: a.write, kv: make(map[string][]byte)}
+}
+
+func (a *overlayArchive) write(kv map[string][]byte) {
+	for k, v := range kv {
+		a.kv[k] = v
+	}
+}
+
+func (a *overlayArchive) Close() error {
+	return nil
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"sort"
+)
+
+// Branch is a StateDB that keeps its changes in memory on top of a base
+// StateDB, which is not modified until Commit
+type Branch struct {
+	base StateDB
+	// changes by key, a nil value is a deleted key
+	changes map[string][]byte
+}
+
+var _ StateDB = (*Branch)(nil)
+
+// NewBranch returns a Branch of base. The branch reads the current values of
+// base, so base must not change while the branch is used.
+func NewBranch(base StateDB) *Branch {
+	return &Branch{base: base, changes: make(map[string][]byte)}
+}
+
+func (b *Branch) Set(k, v []byte) {
+	b.changes[string(k)] = append([]byte{}, v...)
+}
+
+func (b *Branch) Get(k []byte) []byte {
+	if v, ok := b.changes[string(k)]; ok {
+		return v
+	}
+	return b.base.Get(k)
+}
+
+func (b *Branch) Delete(k []byte) {
+	b.changes[string(k)] = nil
+}
+
+func (b *Branch) Iterate(fn func(k, v []byte) bool) {
+	keys := make([]string, 0, len(b.changes))
+	for k := range b.changes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// merge the keys of base with the changed keys, both in order
+	stopped := false
+	emitChanges := func(until string, all bool) {
+		for len(keys) > 0 && !stopped && (all || keys[0] < until) {
+			if v := b.changes[keys[0]]; v != nil {
+				stopped = fn([]byte(keys[0]), v)
+			}
+			keys = keys[1:]
+		}
+	}
+	b.base.Iterate(func(k, v []byte) bool {
+		emitChanges(string(k), false)
+		if stopped {
+			return true
+		}
+		if _, ok := b.changes[string(k)]; ok {
+			// the changed value is emitted with the changes
+			return false
+		}
+		stopped = fn(k, v)
+		return stopped
+	})
+	emitChanges("", true)
+}
+
+// State returns the state of base, without the changes of the branch
+func (b *Branch) State() []byte {
+	return b.base.State()
+}
+
+// Commit writes the changes into base, without committing base
+func (b *Branch) Commit() ([]byte, error) {
+	for k, v := range b.changes {
+		if v == nil {
+			b.base.Delete([]byte(k))
+		} else {
+			b.base.Set([]byte(k), v)
+		}
+	}
+	b.changes = make(map[string][]byte)
+	return nil, nil
+}
+
+func (b *Branch) Rollback() {
+	b.changes = make(map[string][]byte)
+}
+
+// Committed returns the committed state of base, without the changes of the
+// branch
+func (b *Branch) Committed() (StateDB, error) {
+	return b.base.Committed()
+}
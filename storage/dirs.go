@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DIRMARKER is the file that marks which database owns a directory
+const DIRMARKER = ".kvartalochain-db"
+
+// StateDirName and ArchiveDirName are the database names written in the
+// DIRMARKER of each directory
+const StateDirName = "state"
+const ArchiveDirName = "archive"
+
+// IsStateFile returns true for the files of a StateDB directory
+func IsStateFile(name string) bool {
+	return name == "treedb.db"
+}
+
+// IsArchiveFile returns true for the files of a BadgerArchive directory
+func IsArchiveFile(name string) bool {
+	return name == "MANIFEST" || name == "LOCK" || name == "KEYREGISTRY" ||
+		strings.HasSuffix(name, ".vlog") || strings.HasSuffix(name, ".sst")
+}
+
+// CheckDir checks that dir is only used by the database with the given name,
+// creating it if it does not exist. A directory without DIRMARKER is accepted
+// if it only contains files of the database (isDbFile), and gets marked.
+func CheckDir(dir, name string, isDbFile func(name string) bool) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	marker := filepath.Join(dir, DIRMARKER)
+	owner, err := ioutil.ReadFile(marker)
+	if err == nil {
+		if string(owner) != name {
+			return fmt.Errorf("directory %s belongs to the %s database, not to the %s database", dir, owner, name)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !isDbFile(e.Name()) {
+			return fmt.Errorf("directory %s of the %s database contains %s, which belongs to another database", dir, name, e.Name())
+		}
+	}
+	return ioutil.WriteFile(marker, []byte(name), 0644)
+}
+
+// CheckLegacyLayout checks that dataDir, the Tendermint data directory, does
+// not have the state and archive databases, which were kept there by the
+// earlier versions. The error tells where to move them.
+func CheckLegacyLayout(dataDir, stateDir, archiveDir string) error {
+	entries, err := ioutil.ReadDir(dataDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var stateFiles, archiveFiles []string
+	for _, e := range entries {
+		switch {
+		case IsStateFile(e.Name()):
+			stateFiles = append(stateFiles, e.Name())
+		case IsArchiveFile(e.Name()):
+			archiveFiles = append(archiveFiles, e.Name())
+		}
+	}
+	var moves []string
+	if len(stateFiles) > 0 {
+		moves = append(moves, fmt.Sprintf("%s into %s", strings.Join(stateFiles, " "), stateDir))
+	}
+	if len(archiveFiles) > 0 {
+		moves = append(moves, fmt.Sprintf("%s into %s", strings.Join(archiveFiles, " "), archiveDir))
+	}
+	if len(moves) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s has the databases of an earlier version, with the node stopped move %s",
+		dataDir, strings.Join(moves, " and "))
+}
+
+// CheckDirsDisjoint checks that no directory is equal to or inside another one
+func CheckDirsDisjoint(dirs ...string) error {
+	abs := make([]string, len(dirs))
+	for i, dir := range dirs {
+		var err error
+		abs[i], err = filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range abs {
+		for j := range abs {
+			if i == j {
+				continue
+			}
+			if abs[i] == abs[j] || strings.HasPrefix(abs[i], abs[j]+string(filepath.Separator)) {
+				return fmt.Errorf("database directory %s is shared with %s", dirs[i], dirs[j])
+			}
+		}
+	}
+	return nil
+}
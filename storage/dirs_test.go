@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("./", "tmpTest")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	stateDir := filepath.Join(tmpDir, "state")
+	archiveDir := filepath.Join(tmpDir, "archive")
+	assert.Nil(t, CheckDir(stateDir, StateDirName, IsStateFile))
+	assert.Nil(t, CheckDir(stateDir, StateDirName, IsStateFile))
+	// the state dir can not be used by the archive
+	assert.NotNil(t, CheckDir(stateDir, ArchiveDirName, IsArchiveFile))
+
+	// a dir with the files of another db is rejected
+	require.Nil(t, os.MkdirAll(archiveDir, os.ModePerm))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(archiveDir, "MANIFEST"), []byte{}, 0644))
+	require.Nil(t, os.Mkdir(filepath.Join(archiveDir, "treedb.db"), os.ModePerm))
+	assert.NotNil(t, CheckDir(archiveDir, ArchiveDirName, IsArchiveFile))
+	// an unmarked dir with only the db files is accepted
+	require.Nil(t, os.Remove(filepath.Join(archiveDir, "treedb.db")))
+	assert.Nil(t, CheckDir(archiveDir, ArchiveDirName, IsArchiveFile))
+	assert.NotNil(t, CheckDir(archiveDir, StateDirName, IsStateFile))
+}
+
+func TestCheckDirsDisjoint(t *testing.T) {
+	assert.Nil(t, CheckDirsDisjoint("tmp/appdata/state", "tmp/appdata/archive", "tmp/data"))
+	assert.NotNil(t, CheckDirsDisjoint("tmp/data", "tmp/appdata/archive", "tmp/data"))
+	assert.NotNil(t, CheckDirsDisjoint("tmp/data/state", "tmp/appdata/archive", "tmp/data"))
+	assert.NotNil(t, CheckDirsDisjoint("tmp/appdata/state", "tmp/appdata/archive", "tmp/appdata"))
+	assert.Nil(t, CheckDirsDisjoint("tmp/data2", "tmp/data"))
+}
+
+func TestCheckLegacyLayout(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("./", "tmpTest")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	dataDir := filepath.Join(tmpDir, "data")
+	assert.Nil(t, CheckLegacyLayout(dataDir, "appdata/state", "appdata/archive"))
+	require.Nil(t, os.MkdirAll(filepath.Join(dataDir, "blockstore.db"), os.ModePerm))
+	assert.Nil(t, CheckLegacyLayout(dataDir, "appdata/state", "appdata/archive"))
+
+	require.Nil(t, os.Mkdir(filepath.Join(dataDir, "treedb.db"), os.ModePerm))
+	err = CheckLegacyLayout(dataDir, "appdata/state", "appdata/archive")
+	require.NotNil(t, err)
+	assert.True(t, strings.HasSuffix(err.Error(), "move treedb.db into appdata/state"))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dataDir, "MANIFEST"), []byte{}, 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dataDir, "000001.vlog"), []byte{}, 0644))
+	err = CheckLegacyLayout(dataDir, "appdata/state", "appdata/archive")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "move treedb.db into appdata/state and 000001.vlog MANIFEST into appdata/archive")
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+/*
+	block header format in the archive DB:
+		header of each block:
+			key: PREFIXHEADER | height (8 bytes BE)
+			value: [ time unix nanoseconds (8 bytes LE) | hash length 1 byte |
+				hash | parent hash ]
+		height of each block hash:
+			key: PREFIXHEIGHTBYHASH | hash
+			value: height (8 bytes LE)
+*/
+
+var PREFIXHEADER = []byte("header")
+var PREFIXHEIGHTBYHASH = []byte("heightbyhash")
+
+// BlockHeader is the Tendermint block id and time of an archived block.
+// ParentHash is empty for the first block.
+type BlockHeader struct {
+	Height     uint64
+	Hash       []byte
+	ParentHash []byte
+	Time       time.Time
+}
+
+func headerKey(height uint64) []byte {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], height)
+	return append(append([]byte{}, PREFIXHEADER...), h[:]...)
+}
+
+// StoreBlockHeader adds the header to the batch
+func StoreBlockHeader(batch ArchiveBatch, header *BlockHeader) error {
+	var t [8]byte
+	binary.LittleEndian.PutUint64(t[:], uint64(header.Time.UnixNano()))
+	v := append(t[:], byte(len(header.Hash)))
+	v = append(v, header.Hash...)
+	v = append(v, header.ParentHash...)
+	if err := batch.Set(headerKey(header.Height), v); err != nil {
+		return err
+	}
+	var h [8]byte
+	binary.LittleEndian.PutUint64(h[:], header.Height)
+	return batch.Set(append(append([]byte{}, PREFIXHEIGHTBYHASH...), header.Hash...), h[:])
+}
+
+// GetBlockHeader returns the header of the block at height, or nil if it is
+// not in the archive. The headers of the blocks archived by older versions
+// are added by the reindex command.
+func GetBlockHeader(db ArchiveDB, height uint64) (*BlockHeader, error) {
+	v, err := db.Get(headerKey(height))
+	if err != nil || len(v) == 0 {
+		return nil, err
+	}
+	if len(v) < 9 || len(v) < 9+int(v[8]) {
+		return nil, fmt.Errorf("error on archived block header format")
+	}
+	hashLen := int(v[8])
+	return &BlockHeader{
+		Height:     height,
+		Time:       time.Unix(0, int64(binary.LittleEndian.Uint64(v[:8]))).UTC(),
+		Hash:       v[9 : 9+hashLen],
+		ParentHash: v[9+hashLen:],
+	}, nil
+}
+
+// GetBlockHeight returns the height of the block with the given hash, or 0
+// if it is not in the archive
+func GetBlockHeight(db ArchiveDB, hash []byte) (uint64, error) {
+	v, err := db.Get(append(append([]byte{}, PREFIXHEIGHTBYHASH...), hash...))
+	if err != nil || len(v) == 0 {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(v), nil
+}
@@ -0,0 +1,409 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"kvartalochain/common"
+)
+
+// StateVersion and ArchiveVersion are the schema versions of the current
+// layout of each store
+const StateVersion = byte(2)
+const ArchiveVersion = byte(2)
+
+// KEYSCHEMAVERSION is the key where each store keeps its schema version
+var KEYSCHEMAVERSION = []byte("schemaversion")
+
+// legacyPrefixNonce is the prefix of the nonces in the state version 0, where
+// the balance was stored under the bare address key
+var legacyPrefixNonce = []byte("nonce")
+
+// StateMigration upgrades the state to Version from the previous one
+type StateMigration struct {
+	Version     byte
+	Description string
+	// Apply does the changes in the StateDB, without committing them. It
+	// returns a function that checks the result of the migration.
+	Apply func(db StateDB) (verify func() error, err error)
+}
+
+// ArchiveMigration upgrades the archive to Version from the previous one
+type ArchiveMigration struct {
+	Version     byte
+	Description string
+	// Apply adds the changes in the ArchiveBatch. It returns a function that
+	// checks the result of the migration once the batch is committed.
+	Apply func(db ArchiveDB, batch ArchiveBatch) (verify func() error, err error)
+}
+
+var StateMigrations = []StateMigration{
+	{
+		Version:     1,
+		Description: "move balances and nonces into Account records",
+		Apply:       migrateStateV1,
+	},
+	{
+		Version:     2,
+		Description: "add the supply totals, computed from the accounts",
+		Apply:       migrateStateV2,
+	},
+}
+
+var ArchiveMigrations = []ArchiveMigration{
+	{
+		Version:     1,
+		Description: "add schema version",
+		Apply: func(db ArchiveDB, batch ArchiveBatch) (func() error, error) {
+			return func() error { return nil }, nil
+		},
+	},
+	{
+		Version:     2,
+		Description: "rebuild the history of each address from the block index, with the height and hash of each tx",
+		Apply:       migrateArchiveV2,
+	},
+}
+
+func isStateEmpty(db StateDB) bool {
+	empty := true
+	db.Iterate(func(k, v []byte) bool {
+		empty = false
+		return true
+	})
+	return empty
+}
+
+func isArchiveEmpty(db ArchiveDB) (bool, error) {
+	empty := true
+	err := db.Iterate(nil, func(k, v []byte) bool {
+		empty = false
+		return true
+	})
+	return empty, err
+}
+
+// GetStateVersion returns the schema version of the state. A state without
+// version is a version 0 state.
+func GetStateVersion(db StateDB) byte {
+	v := db.Get(KEYSCHEMAVERSION)
+	if len(v) == 0 {
+		return 0
+	}
+	return v[0]
+}
+
+// GetArchiveVersion returns the schema version of the archive. An archive
+// without version is a version 0 archive.
+func GetArchiveVersion(db ArchiveDB) (byte, error) {
+	v, err := db.Get(KEYSCHEMAVERSION)
+	if err != nil || len(v) == 0 {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// CheckVersions checks that both stores are in the current schema version.
+// Empty stores get the current version.
+func CheckVersions(db StateDB, archiveDb ArchiveDB) error {
+	if isStateEmpty(db) {
+		db.Set(KEYSCHEMAVERSION, []byte{StateVersion})
+		if _, err := db.Commit(); err != nil {
+			return err
+		}
+	}
+	if v := GetStateVersion(db); v != StateVersion {
+		return fmt.Errorf("state schema version is %d, expected %d, run the migrate command", v, StateVersion)
+	}
+
+	empty, err := isArchiveEmpty(archiveDb)
+	if err != nil {
+		return err
+	}
+	if empty {
+		batch := archiveDb.NewBatch()
+		if err := batch.Set(KEYSCHEMAVERSION, []byte{ArchiveVersion}); err != nil {
+			return err
+		}
+		if err := batch.Commit(); err != nil {
+			return err
+		}
+	}
+	v, err := GetArchiveVersion(archiveDb)
+	if err != nil {
+		return err
+	}
+	if v != ArchiveVersion {
+		return fmt.Errorf("archive schema version is %d, expected %d, run the migrate command", v, ArchiveVersion)
+	}
+	return nil
+}
+
+// MigrateState applies the pending StateMigrations and verifies them. If
+// dryRun is true, the migrations are applied and verified over a Branch of
+// db, and db is not changed. The description of the applied migrations is
+// sent to log.
+func MigrateState(db StateDB, dryRun bool, log func(msg string)) error {
+	current := GetStateVersion(db)
+	if current == 0 && isStateEmpty(db) {
+		current = StateVersion
+	}
+	if dryRun {
+		db = NewBranch(db)
+	}
+	for _, m := range StateMigrations {
+		if m.Version <= current {
+			continue
+		}
+		log(fmt.Sprintf("state: migrating to version %d: %s", m.Version, m.Description))
+		verify, err := m.Apply(db)
+		if err != nil {
+			db.Rollback()
+			return err
+		}
+		db.Set(KEYSCHEMAVERSION, []byte{m.Version})
+		if err := verify(); err != nil {
+			db.Rollback()
+			return fmt.Errorf("state version %d verification failed: %w", m.Version, err)
+		}
+		if dryRun {
+			// the next migrations are applied over the changes of
+			// this one, which are never committed
+			log(fmt.Sprintf("state: version %d verified, dry run, discarding changes", m.Version))
+			continue
+		}
+		if _, err := db.Commit(); err != nil {
+			return err
+		}
+		log(fmt.Sprintf("state: version %d verified and stored", m.Version))
+	}
+	return nil
+}
+
+// MigrateArchive applies the pending ArchiveMigrations and verifies them. If
+// dryRun is true, the migrations are applied and verified over an in memory
+// copy of their writes, and the archive is not changed. The description of
+// the applied migrations is sent to log.
+func MigrateArchive(db ArchiveDB, dryRun bool, log func(msg string)) error {
+	current, err := GetArchiveVersion(db)
+	if err != nil {
+		return err
+	}
+	empty, err := isArchiveEmpty(db)
+	if err != nil {
+		return err
+	}
+	if current == 0 && empty {
+		current = ArchiveVersion
+	}
+	if dryRun {
+		db = newOverlayArchive(db)
+	}
+	for _, m := range ArchiveMigrations {
+		if m.Version <= current {
+			continue
+		}
+		log(fmt.Sprintf("archive: migrating to version %d: %s", m.Version, m.Description))
+		batch := db.NewBatch()
+		verify, err := m.Apply(db, batch)
+		if err != nil {
+			batch.Discard()
+			return err
+		}
+		if err := batch.Set(KEYSCHEMAVERSION, []byte{m.Version}); err != nil {
+			batch.Discard()
+			return err
+		}
+		if err := batch.Commit(); err != nil {
+			return err
+		}
+		if err := verify(); err != nil {
+			return fmt.Errorf("archive version %d verification failed: %w", m.Version, err)
+		}
+		if dryRun {
+			log(fmt.Sprintf("archive: version %d verified, dry run, discarding changes", m.Version))
+			continue
+		}
+		log(fmt.Sprintf("archive: version %d stored and verified", m.Version))
+	}
+	return nil
+}
+
+func migrateStateV1(db StateDB) (func() error, error) {
+	legacy := make(map[common.Address]Account)
+	var legacyKeys [][]byte
+	var err error
+	db.Iterate(func(k, v []byte) bool {
+		var addr common.Address
+		switch {
+		case len(k) == len(addr):
+			copy(addr[:], k)
+			if len(v) != 8 {
+				err = fmt.Errorf("invalid balance of %s", addr)
+				return true
+			}
+			acc := legacy[addr]
+			acc.Balance = binary.LittleEndian.Uint64(v)
+			legacy[addr] = acc
+		case len(k) == len(legacyPrefixNonce)+len(addr) &&
+			bytes.HasPrefix(k, legacyPrefixNonce):
+			copy(addr[:], k[len(legacyPrefixNonce):])
+			if len(v) != 8 {
+				err = fmt.Errorf("invalid nonce of %s", addr)
+				return true
+			}
+			acc := legacy[addr]
+			acc.Nonce = binary.LittleEndian.Uint64(v)
+			legacy[addr] = acc
+		default:
+			return false
+		}
+		legacyKeys = append(legacyKeys, append([]byte{}, k...))
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range legacyKeys {
+		db.Delete(k)
+	}
+	for addr, acc := range legacy {
+		acc := acc
+		SetAccount(db, addr, &acc)
+	}
+
+	verify := func() error {
+		for _, k := range legacyKeys {
+			if len(db.Get(k)) != 0 {
+				return fmt.Errorf("legacy key %x not removed", k)
+			}
+		}
+		for addr, acc := range legacy {
+			migrated, err := GetAccount(db, addr)
+			if err != nil {
+				return err
+			}
+			if *migrated != acc {
+				return fmt.Errorf("account %s: expected %+v, got %+v", addr, acc, *migrated)
+			}
+		}
+		return nil
+	}
+	return verify, nil
+}
+
+func migrateStateV2(db StateDB) (func() error, error) {
+	var supply Supply
+	err := IterateAccounts(db, func(addr common.Address, acc *Account) bool {
+		// there are no burns yet, so all the coins in the accounts
+		// have been minted
+		supply.Minted += acc.Balance
+		supply.Accounts++
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	SetSupply(db, &supply)
+
+	verify := func() error {
+		stored, err := GetSupply(db)
+		if err != nil {
+			return err
+		}
+		if *stored != supply {
+			return fmt.Errorf("expected supply %+v, got %+v", supply, *stored)
+		}
+		return nil
+	}
+	return verify, nil
+}
+
+func migrateArchiveV2(db ArchiveDB, batch ArchiveBatch) (func() error, error) {
+	// the old entries are the tx bytes, the ones of the replayed blocks are
+	// duplicated
+	entryLen := len(PREFIXHISTORY) + 32 + 8
+	history := make(map[string]bool)
+	var historyKeys [][]byte
+	err := db.Iterate(PREFIXHISTORY, func(k, v []byte) bool {
+		if len(k) == entryLen {
+			history[string(v)] = true
+		}
+		historyKeys = append(historyKeys, append([]byte{}, k...))
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes [][]byte
+	err = db.Iterate(PREFIXBLOCK, func(k, v []byte) bool {
+		hashes = append(hashes, v)
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	var indexed []*ArchivedTx
+	for _, hash := range hashes {
+		archived, err := GetTxByHash(db, hash)
+		if err != nil {
+			return nil, err
+		}
+		if archived == nil {
+			return nil, fmt.Errorf("tx %X of the block index not in the tx hash index", hash)
+		}
+		indexed = append(indexed, archived)
+		delete(history, string(archived.Tx.Bytes()))
+	}
+	// the archives written before the block index have txs that can only
+	// be found again in the Tendermint blocks
+	if len(history) > 0 {
+		return nil, fmt.Errorf("%d txs of the history are not in the block index, rebuild the archive with the reindex command", len(history))
+	}
+
+	for _, k := range historyKeys {
+		if err := batch.Delete(k); err != nil {
+			return nil, err
+		}
+	}
+	counts := make(map[common.Address]uint64)
+	for _, archived := range indexed {
+		if err := storeHistory(batch, archived); err != nil {
+			return nil, err
+		}
+		counts[archived.Tx.From]++
+		if archived.Tx.To != archived.Tx.From {
+			counts[archived.Tx.To]++
+		}
+	}
+
+	verify := func() error {
+		for addr, count := range counts {
+			stored, err := GetTxCount(db, addr)
+			if err != nil {
+				return err
+			}
+			if stored != count {
+				return fmt.Errorf("address %s: expected %d txs, got %d", addr, count, stored)
+			}
+			for n := uint64(0); n < count; n++ {
+				archived, err := GetArchivedTx(db, addr, n)
+				if err != nil {
+					return err
+				}
+				indexed, err := GetTxByHash(db, archived.Hash)
+				if err != nil {
+					return err
+				}
+				if indexed == nil || indexed.Height != archived.Height || indexed.Index != archived.Index {
+					return fmt.Errorf("tx %d of %s does not match the tx hash index", n, addr)
+				}
+			}
+		}
+		return nil
+	}
+	return verify, nil
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"kvartalochain/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setLegacyAccount(db StateDB, addr common.Address, balance, nonce uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], balance)
+	db.Set(addr[:], b[:])
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], nonce)
+	db.Set(append(append([]byte{}, legacyPrefixNonce...), addr[:]...), n[:])
+}
+
+// setV1Tx stores a tx as the archive versions 0 and 1 did, with the tx bytes
+// in the history. Without index, the tx is only added to the history, as
+// before the block index.
+func setV1Tx(t *testing.T, batch ArchiveBatch, height uint64, txRaw []byte, tx *common.Tx, index bool) {
+	if index {
+		var pos [12]byte
+		binary.LittleEndian.PutUint64(pos[:8], height)
+		require.Nil(t, batch.Set(append(append([]byte{}, PREFIXTXHASH...), TxHash(txRaw)...), append(pos[:], tx.Bytes()...)))
+		require.Nil(t, batch.Set(blockKey(height, 0), TxHash(txRaw)))
+	}
+	require.Nil(t, addToHistory(batch, tx.From, tx.Bytes()))
+	if tx.To != tx.From {
+		require.Nil(t, addToHistory(batch, tx.To, tx.Bytes()))
+	}
+}
+
+func TestCheckVersionsEmpty(t *testing.T) {
+	sto, err := NewMemStorage()
+	require.Nil(t, err)
+	archive := NewMemArchive()
+
+	assert.Nil(t, CheckVersions(sto, archive))
+	assert.Equal(t, StateVersion, GetStateVersion(sto))
+	v, err := GetArchiveVersion(archive)
+	assert.Nil(t, err)
+	assert.Equal(t, ArchiveVersion, v)
+}
+
+func TestMigrate(t *testing.T) {
+	sto, err := NewMemStorage()
+	require.Nil(t, err)
+	archive := NewMemArchive()
+
+	var addr0, addr1 common.Address
+	addr0[0] = 1
+	addr1[0] = 2
+	setLegacyAccount(sto, addr0, 10, 2)
+	setLegacyAccount(sto, addr1, 20, 0)
+	_, err = sto.Commit()
+	require.Nil(t, err)
+	// a version 0 archive, indexed by the reindex of the version 1
+	mint := &common.Tx{Type: common.TxTypeMint, From: addr0, To: addr0, Amount: 10}
+	batch := archive.NewBatch()
+	setV1Tx(t, batch, 1, []byte(mint.Hex()), mint, true)
+	require.Nil(t, batch.Commit())
+
+	assert.NotNil(t, CheckVersions(sto, archive))
+
+	var msgs []string
+	log := func(msg string) { msgs = append(msgs, msg) }
+
+	// dry run does not change the stores, and all the migrations are
+	// verified
+	assert.Nil(t, MigrateState(sto, true, log))
+	assert.Nil(t, MigrateArchive(archive, true, log))
+	assert.Equal(t, 8, len(msgs))
+	assert.Contains(t, msgs[3], "state: version 2 verified")
+	assert.Equal(t, byte(0), GetStateVersion(sto))
+	acc, err := GetAccount(sto, addr0)
+	assert.Nil(t, err)
+	assert.Equal(t, &Account{}, acc)
+	assert.NotNil(t, CheckVersions(sto, archive))
+
+	assert.Nil(t, MigrateState(sto, false, log))
+	assert.Nil(t, MigrateArchive(archive, false, log))
+	assert.Nil(t, CheckVersions(sto, archive))
+
+	acc, err = GetAccount(sto, addr0)
+	assert.Nil(t, err)
+	assert.Equal(t, &Account{Balance: 10, Nonce: 2}, acc)
+	acc, err = GetAccount(sto, addr1)
+	assert.Nil(t, err)
+	assert.Equal(t, &Account{Balance: 20, Nonce: 0}, acc)
+	assert.Nil(t, sto.Get(addr0[:]))
+	supply, err := GetSupply(sto)
+	assert.Nil(t, err)
+	assert.Equal(t, &Supply{Minted: 30, Accounts: 2}, supply)
+	// the history is still readable
+	archived, err := GetArchivedTx(archive, addr0, 0)
+	require.Nil(t, err)
+	assert.Equal(t, TxHash([]byte(mint.Hex())), archived.Hash)
+	assert.Equal(t, uint64(1), archived.Height)
+	assert.Equal(t, mint.Bytes(), archived.Tx.Bytes())
+
+	// running it again does nothing
+	msgs = nil
+	assert.Nil(t, MigrateState(sto, false, log))
+	assert.Nil(t, MigrateArchive(archive, false, log))
+	assert.Equal(t, 0, len(msgs))
+}
+
+func TestMigrateArchiveV2(t *testing.T) {
+	archive := NewMemArchive()
+	var addr0, addr1 common.Address
+	addr0[0] = 1
+	addr1[0] = 2
+	tx0 := &common.Tx{Type: common.TxTypeMint, From: addr0, To: addr0, Amount: 10}
+	tx1 := common.NewTx(addr0, addr1, 4, 1)
+	raw1 := []byte(strings.ToUpper(tx1.Hex()))
+
+	// a version 1 archive, where the history has the tx bytes and the
+	// block 2 was archived twice
+	batch := archive.NewBatch()
+	require.Nil(t, batch.Set(KEYSCHEMAVERSION, []byte{1}))
+	setV1Tx(t, batch, 1, []byte(tx0.Hex()), tx0, true)
+	setV1Tx(t, batch, 2, raw1, tx1, true)
+	require.Nil(t, addToHistory(batch, tx1.From, tx1.Bytes()))
+	require.Nil(t, addToHistory(batch, tx1.To, tx1.Bytes()))
+	require.Nil(t, batch.Commit())
+
+	// the dry run verifies the migration without changing the archive
+	assert.Nil(t, MigrateArchive(archive, true, func(string) {}))
+	v, err := GetArchiveVersion(archive)
+	require.Nil(t, err)
+	assert.Equal(t, byte(1), v)
+	count, err := GetTxCount(archive, addr1)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	assert.Nil(t, MigrateArchive(archive, false, func(string) {}))
+	v, err = GetArchiveVersion(archive)
+	require.Nil(t, err)
+	assert.Equal(t, ArchiveVersion, v)
+
+	count, err = GetTxCount(archive, addr0)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), count)
+	count, err = GetTxCount(archive, addr1)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), count)
+	archived, err := GetArchivedTx(archive, addr1, 0)
+	require.Nil(t, err)
+	assert.Equal(t, &ArchivedTx{Hash: TxHash(raw1), Height: 2, Tx: tx1}, archived)
+
+	// the dry run fails on a block index with a missing tx
+	broken := NewMemArchive()
+	batch = broken.NewBatch()
+	require.Nil(t, batch.Set(KEYSCHEMAVERSION, []byte{1}))
+	require.Nil(t, batch.Set(blockKey(1, 0), TxHash([]byte("missing"))))
+	require.Nil(t, batch.Commit())
+	assert.NotNil(t, MigrateArchive(broken, true, func(string) {}))
+
+	// an archive written before the block index is not migrated, as its
+	// history would be lost
+	unindexed := NewMemArchive()
+	batch = unindexed.NewBatch()
+	setV1Tx(t, batch, 1, []byte(tx0.Hex()), tx0, true)
+	setV1Tx(t, batch, 2, raw1, tx1, false)
+	require.Nil(t, batch.Commit())
+	err = MigrateArchive(unindexed, false, func(string) {})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "reindex")
+	v, err = GetArchiveVersion(unindexed)
+	require.Nil(t, err)
+	assert.Equal(t, byte(1), v)
+	count, err = GetTxCount(unindexed, addr1)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestOverlayArchive(t *testing.T) {
+	base := NewMemArchive()
+	batch := base.NewBatch()
+	for _, k := range []string{"a1", "a3", "a5", "b1"} {
+		require.Nil(t, batch.Set([]byte(k), []byte("base")))
+	}
+	require.Nil(t, batch.Commit())
+
+	overlay := newOverlayArchive(base)
+	batch = overlay.NewBatch()
+	require.Nil(t, batch.Set([]byte("a0"), []byte("new")))
+	require.Nil(t, batch.Set([]byte("a3"), []byte("new")))
+	require.Nil(t, batch.Delete([]byte("a5")))
+	require.Nil(t, batch.Set([]byte("a6"), []byte("new")))
+	require.Nil(t, batch.Commit())
+
+	var kvs []string
+	require.Nil(t, overlay.Iterate([]byte("a"), func(k, v []byte) bool {
+		kvs = append(kvs, string(k)+"="+string(v))
+		return false
+	}))
+	assert.Equal(t, []string{"a0=new", "a1=base", "a3=new", "a6=new"}, kvs)
+	kvs = nil
+	require.Nil(t, overlay.Iterate([]byte("a"), func(k, v []byte) bool {
+		kvs = append(kvs, string(k))
+		return len(kvs) == 2
+	}))
+	assert.Equal(t, []string{"a0", "a1"}, kvs)
+	v, err := overlay.Get([]byte("a5"))
+	require.Nil(t, err)
+	assert.Nil(t, v)
+
+	// the base is not changed
+	v, err = base.Get([]byte("a3"))
+	require.Nil(t, err)
+	assert.Equal(t, []byte("base"), v)
+	v, err = base.Get([]byte("a0"))
+	require.Nil(t, err)
+	assert.Nil(t, v)
+}
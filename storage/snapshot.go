@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/tendermint/iavl"
+)
+
+// ErrReadOnly is returned when committing a Snapshot
+var ErrReadOnly = errors.New("storage: the snapshot is read-only")
+
+// Snapshot is a read-only StateDB of a committed version of a Storage. It
+// reads the saved nodes of the version, so it can be used while the Storage
+// is modified.
+type Snapshot struct {
+	tree *iavl.ImmutableTree
+}
+
+var _ StateDB = (*Snapshot)(nil)
+
+// Set panics, as the snapshot is read-only. The changes over a snapshot go in
+// a Branch.
+func (s *Snapshot) Set(k, v []byte) {
+	panic(ErrReadOnly)
+}
+
+func (s *Snapshot) Get(k []byte) []byte {
+	_, v := s.tree.Get(k)
+	return v
+}
+
+// Delete panics, as the snapshot is read-only
+func (s *Snapshot) Delete(k []byte) {
+	panic(ErrReadOnly)
+}
+
+func (s *Snapshot) Iterate(fn func(k, v []byte) bool) {
+	s.tree.Iterate(fn)
+}
+
+func (s *Snapshot) State() []byte {
+	return s.tree.Hash()
+}
+
+func (s *Snapshot) Commit() ([]byte, error) {
+	return nil, ErrReadOnly
+}
+
+func (s *Snapshot) Rollback() {}
+
+func (s *Snapshot) Committed() (StateDB, error) {
+	return s, nil
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"kvartalochain/common"
+)
+
+/*
+	economic statistics format in the archive DB:
+		aggregates of an interval:
+			key: PREFIXSTATSBUCKET | interval | interval start unix seconds (8 bytes BE)
+			value: [ version 1 byte | transfers | volume | senders | receivers |
+				new accounts | minted | burned | supply ] (8 bytes LE each)
+		addresses active in an interval:
+			key: PREFIXSTATSACTIVE | interval | interval start | role | address
+			value: [ 1 ]
+*/
+
+var PREFIXSTATSBUCKET = []byte("statsbucket")
+var PREFIXSTATSACTIVE = []byte("statsactive")
+
+// StatsVersion is the schema version of the encoded Stats
+const StatsVersion = byte(1)
+
+const statsLenV1 = 1 + 8*8
+
+const (
+	IntervalDay  = "day"
+	IntervalWeek = "week"
+)
+
+var intervalKeys = map[string]byte{
+	IntervalDay:  'd',
+	IntervalWeek: 'w',
+}
+
+const (
+	roleSender   = byte('s')
+	roleReceiver = byte('r')
+)
+
+// Stats are the aggregates of the txs of an interval starting at Start.
+// Transfers, Volume, Senders and Receivers only count normal txs. Supply is
+// the circulating supply after the last tx of the interval, and Velocity is
+// Volume divided by Supply.
+type Stats struct {
+	Start       time.Time `json:"start"`
+	Transfers   uint64    `json:"transfers"`
+	Volume      uint64    `json:"volume"`
+	Senders     uint64    `json:"senders"`
+	Receivers   uint64    `json:"receivers"`
+	NewAccounts uint64    `json:"newAccounts"`
+	Minted      uint64    `json:"minted"`
+	Burned      uint64    `json:"burned"`
+	Supply      uint64    `json:"supply"`
+	Velocity    float64   `json:"velocity"`
+}
+
+func (s *Stats) fields() []*uint64 {
+	return []*uint64{&s.Transfers, &s.Volume, &s.Senders, &s.Receivers,
+		&s.NewAccounts, &s.Minted, &s.Burned, &s.Supply}
+}
+
+func (s *Stats) Bytes() []byte {
+	b := make([]byte, statsLenV1)
+	b[0] = StatsVersion
+	for i, f := range s.fields() {
+		binary.LittleEndian.PutUint64(b[1+8*i:], *f)
+	}
+	return b
+}
+
+func StatsFromBytes(b []byte) (*Stats, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("error on stats bytes format")
+	}
+	switch b[0] {
+	case 1:
+		if len(b) != statsLenV1 {
+			return nil, fmt.Errorf("error on stats bytes format")
+		}
+		var s Stats
+		for i, f := range s.fields() {
+			*f = binary.LittleEndian.Uint64(b[1+8*i:])
+		}
+		return &s, nil
+	default:
+		return nil, fmt.Errorf("unknown stats version: %d", b[0])
+	}
+}
+
+// ValidInterval returns true if interval is IntervalDay or IntervalWeek
+func ValidInterval(interval string) bool {
+	_, ok := intervalKeys[interval]
+	return ok
+}
+
+// IntervalStart returns the start of the interval that contains t. Days
+// start at 00:00 UTC, and weeks on Monday.
+func IntervalStart(interval string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if interval == IntervalWeek {
+		// days since Monday
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+	return day
+}
+
+func nextIntervalStart(interval string, start time.Time) time.Time {
+	if interval == IntervalWeek {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+func statsKey(prefix []byte, interval string, start time.Time) []byte {
+	var startBytes [8]byte
+	binary.BigEndian.PutUint64(startBytes[:], uint64(start.Unix()))
+	key := append(append([]byte{}, prefix...), intervalKeys[interval])
+	return append(key, startBytes[:]...)
+}
+
+// markActive marks the address as active in the interval with the role, and
+// returns true if it was not active before
+func markActive(batch ArchiveBatch, interval string, start time.Time, role byte, addr common.Address) (bool, error) {
+	key := append(append(statsKey(PREFIXSTATSACTIVE, interval, start), role), addr[:]...)
+	v, err := batch.Get(key)
+	if err != nil || v != nil {
+		return false, err
+	}
+	return true, batch.Set(key, []byte{1})
+}
+
+// StoreStats adds the tx done at time t to the stats of its day and week.
+// newAccounts is the number of accounts created by the tx, and supply the
+// circulating supply after it.
+func StoreStats(batch ArchiveBatch, t time.Time, tx *common.Tx, newAccounts, supply uint64) error {
+	for _, interval := range []string{IntervalDay, IntervalWeek} {
+		start := IntervalStart(interval, t)
+		key := statsKey(PREFIXSTATSBUCKET, interval, start)
+		s := &Stats{}
+		b, err := batch.Get(key)
+		if err != nil {
+			return err
+		}
+		if b != nil {
+			if s, err = StatsFromBytes(b); err != nil {
+				return err
+			}
+		}
+
+		switch tx.Type {
+		case common.TxTypeMint:
+			s.Minted += tx.Amount
+		default:
+			s.Transfers++
+			s.Volume += tx.Amount
+			isNew, err := markActive(batch, interval, start, roleSender, tx.From)
+			if err != nil {
+				return err
+			}
+			if isNew {
+				s.Senders++
+			}
+			isNew, err = markActive(batch, interval, start, roleReceiver, tx.To)
+			if err != nil {
+				return err
+			}
+			if isNew {
+				s.Receivers++
+			}
+		}
+		s.NewAccounts += newAccounts
+		s.Supply = supply
+
+		if err := batch.Set(key, s.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetStats returns the stats of each interval from the one that contains
+// from to the one that contains to. Intervals without txs keep the supply of
+// the previous interval.
+func GetStats(db ArchiveDB, interval string, from, to time.Time) ([]Stats, error) {
+	if !ValidInterval(interval) {
+		return nil, fmt.Errorf("invalid interval %q", interval)
+	}
+	from = IntervalStart(interval, from)
+	to = IntervalStart(interval, to)
+
+	// the stored intervals are iterated from the first one, to know the
+	// supply of the intervals without txs
+	stored := make(map[int64]*Stats)
+	var supply uint64
+	var err error
+	prefix := append(append([]byte{}, PREFIXSTATSBUCKET...), intervalKeys[interval])
+	iterErr := db.Iterate(prefix, func(k, v []byte) bool {
+		start := int64(binary.BigEndian.Uint64(k[len(prefix):]))
+		if start > to.Unix() {
+			return true
+		}
+		var s *Stats
+		if s, err = StatsFromBytes(v); err != nil {
+			return true
+		}
+		if start < from.Unix() {
+			supply = s.Supply
+		} else {
+			stored[start] = s
+		}
+		return false
+	})
+	if iterErr != nil {
+		return nil, iterErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []Stats
+	for start := from; !start.After(to); start = nextIntervalStart(interval, start) {
+		s, ok := stored[start.Unix()]
+		if !ok {
+			s = &Stats{Supply: supply}
+		}
+		s.Start = start
+		supply = s.Supply
+		if s.Supply > 0 {
+			s.Velocity = float64(s.Volume) / float64(s.Supply)
+		}
+		stats = append(stats, *s)
+	}
+	return stats, nil
+}
@@ -1,26 +1,64 @@
 package storage
 
 import (
+	"sync/atomic"
+
 	"github.com/tendermint/iavl"
 	tmdb "github.com/tendermint/tm-db"
 )
 
+// StateDB is the store used for the chain state (balances and nonces)
+type StateDB interface {
+	Set(k, v []byte)
+	Get(k []byte) []byte
+	Delete(k []byte)
+	// Iterate calls fn for each key in order, until fn returns true
+	Iterate(fn func(k, v []byte) (stop bool))
+	State() []byte
+	Commit() ([]byte, error)
+	// Rollback discards the changes done since the last Commit
+	Rollback()
+	// Committed returns a read-only StateDB of the last committed state,
+	// which the later changes do not modify. It can be called from any
+	// goroutine.
+	Committed() (StateDB, error)
+}
+
+// Storage is the StateDB implementation, an iavl tree over a tm-db backend
 type Storage struct {
-	tree *iavl.MutableTree
+	tree    *iavl.MutableTree
+	version int64 // last committed version, accessed atomically
 }
 
+var _ StateDB = (*Storage)(nil)
+
+// NewStorage returns a Storage persisted in a leveldb at dataDir
 func NewStorage(dataDir string) (*Storage, error) {
 	lvldb, err := tmdb.NewGoLevelDB("treedb", dataDir)
 	if err != nil {
 		return nil, err
 	}
+	return newStorage(lvldb)
+}
+
+// NewMemStorage returns a Storage that lives only in memory
+func NewMemStorage() (*Storage, error) {
+	return newStorage(tmdb.NewMemDB())
+}
 
+func newStorage(backend tmdb.DB) (*Storage, error) {
 	var sto Storage
-	tree, err := iavl.NewMutableTree(lvldb, 0)
+	tree, err := iavl.NewMutableTree(backend, 0)
+	if err != nil {
+		return nil, err
+	}
+	// load the last committed version, if any
+	version, err := tree.Load()
 	if err != nil {
 		return nil, err
 	}
 	sto.tree = tree
+	sto.version = version
 
 	return &sto, nil
 }
@@ -33,11 +71,41 @@ func (sto *Storage) Get(k []byte) []byte {
 	_, v := sto.tree.Get(k)
 	return v
 }
+
+func (sto *Storage) Delete(k []byte) {
+	sto.tree.Remove(k)
+}
+
+func (sto *Storage) Iterate(fn func(k, v []byte) bool) {
+	sto.tree.Iterate(fn)
+}
+
 func (sto *Storage) State() []byte {
 	return sto.tree.Hash()
 }
 
 func (sto *Storage) Commit() ([]byte, error) {
-	h, _, err := sto.tree.SaveVersion()
-	return h, err
+	h, version, err := sto.tree.SaveVersion()
+	if err != nil {
+		return nil, err
+	}
+	atomic.StoreInt64(&sto.version, version)
+	return h, nil
+}
+
+func (sto *Storage) Rollback() {
+	sto.tree.Rollback()
+}
+
+// Committed returns a Snapshot of the last committed version of the tree
+func (sto *Storage) Committed() (StateDB, error) {
+	version := atomic.LoadInt64(&sto.version)
+	if version == 0 {
+		return &Snapshot{tree: iavl.NewImmutableTree(nil, 0)}, nil
+	}
+	tree, err := sto.tree.GetImmutable(version)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{tree: tree}, nil
 }
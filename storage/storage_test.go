@@ -4,7 +4,11 @@ import (
 	"encoding/hex"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"kvartalochain/common"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -25,3 +29,355 @@ func TestStorage(t *testing.T) {
 	assert.Equal(t, "c778aafd61b926abbfb8a8d6c7d8727bcbc069207a67ba9a26fefe71cd155ae5", hex.EncodeToString(sto.State()))
 	assert.Equal(t, []byte("value0"), sto.Get([]byte("test0")))
 }
+
+func TestMemStorage(t *testing.T) {
+	sto, err := NewMemStorage()
+	assert.Nil(t, err)
+
+	sto.Set([]byte("test0"), []byte("value0"))
+	assert.Equal(t, []byte("value0"), sto.Get([]byte("test0")))
+
+	sto.Commit()
+	assert.Equal(t, "c778aafd61b926abbfb8a8d6c7d8727bcbc069207a67ba9a26fefe71cd155ae5", hex.EncodeToString(sto.State()))
+	assert.Equal(t, []byte("value0"), sto.Get([]byte("test0")))
+}
+
+func TestMemArchive(t *testing.T) {
+	archive := NewMemArchive()
+
+	v, err := archive.Get([]byte("test0"))
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+
+	batch := archive.NewBatch()
+	assert.Nil(t, batch.Set([]byte("test0"), []byte("value0")))
+	v, err = archive.Get([]byte("test0"))
+	assert.Nil(t, err)
+	assert.Nil(t, v) // not visible until the batch is committed
+
+	assert.Nil(t, batch.Commit())
+	v, err = archive.Get([]byte("test0"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value0"), v)
+
+	batch = archive.NewBatch()
+	assert.Nil(t, batch.Set([]byte("test1"), []byte("value1")))
+	batch.Discard()
+	v, err = archive.Get([]byte("test1"))
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+}
+
+func TestAccount(t *testing.T) {
+	acc := &Account{Balance: 10, Nonce: 2}
+	b := acc.Bytes()
+	assert.Equal(t, "010a000000000000000200000000000000", hex.EncodeToString(b))
+	acc2, err := AccountFromBytes(b)
+	assert.Nil(t, err)
+	assert.Equal(t, acc, acc2)
+
+	_, err = AccountFromBytes(b[:10])
+	assert.NotNil(t, err)
+	b[0] = 0
+	_, err = AccountFromBytes(b)
+	assert.NotNil(t, err)
+
+	sto, err := NewMemStorage()
+	require.Nil(t, err)
+	var addr common.Address
+	addr[0] = 1
+	acc3, err := GetAccount(sto, addr)
+	assert.Nil(t, err)
+	assert.Equal(t, &Account{}, acc3)
+	SetAccount(sto, addr, acc)
+	balance, err := GetBalance(sto, addr)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), balance)
+	nonce, err := GetNonce(sto, addr)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), nonce)
+}
+
+func TestStoreTx(t *testing.T) {
+	archive := NewMemArchive()
+
+	var addr0, addr1 common.Address
+	addr0[0] = 1
+	addr1[0] = 2
+	tx0 := common.NewTx(addr0, addr1, 10, 0)
+	tx1 := common.NewTx(addr0, addr1, 5, 1)
+
+	// two txs of the same address in the same block
+	batch := archive.NewBatch()
+	assert.Nil(t, StoreTx(batch, 1, 0, []byte(tx0.Hex()), tx0))
+	assert.Nil(t, StoreTx(batch, 1, 2, []byte(tx1.Hex()), tx1))
+	assert.Nil(t, SetArchiveHeight(batch, 1))
+	assert.Nil(t, batch.Commit())
+
+	height, err := GetArchiveHeight(archive)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), height)
+	for _, addr := range []common.Address{addr0, addr1} {
+		count, err := GetTxCount(archive, addr)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(2), count)
+		txs, err := GetAddressHistory(archive, addr, count)
+		assert.Nil(t, err)
+		assert.Equal(t, []common.Tx{*tx0, *tx1}, txs)
+	}
+
+	archivedTx, err := GetArchivedTx(archive, addr1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, &ArchivedTx{Hash: TxHash([]byte(tx1.Hex())), Height: 1, Index: 2, Tx: tx1}, archivedTx)
+
+	archivedTx, err = GetTxByHash(archive, TxHash([]byte(tx1.Hex())))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), archivedTx.Height)
+	assert.Equal(t, uint32(2), archivedTx.Index)
+	assert.Equal(t, tx1, archivedTx.Tx)
+
+	blockTxs, err := GetBlockTxs(archive, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(blockTxs))
+	assert.Equal(t, tx0, blockTxs[0].Tx)
+	assert.Equal(t, tx1, blockTxs[1].Tx)
+
+	assert.Nil(t, DeleteArchivedTxs(archive))
+	count, err := GetTxCount(archive, addr0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), count)
+	archivedTx, err = GetTxByHash(archive, TxHash([]byte(tx1.Hex())))
+	assert.Nil(t, err)
+	assert.Nil(t, archivedTx)
+	height, err = GetArchiveHeight(archive)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), height)
+}
+
+func TestTopHolders(t *testing.T) {
+	sto, err := NewMemStorage()
+	require.Nil(t, err)
+
+	var addr0, addr1, addr2 common.Address
+	addr0[0] = 1
+	addr1[0] = 2
+	addr2[0] = 3
+	SetAccount(sto, addr0, &Account{Balance: 5})
+	SetAccount(sto, addr1, &Account{Balance: 7})
+	SetAccount(sto, addr2, &Account{Balance: 5})
+
+	top, err := TopHolders(sto, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []Holder{{addr1, 7}, {addr0, 5}}, top)
+
+	supply := &Supply{Minted: 20, Burned: 3, Accounts: 3}
+	SetSupply(sto, supply)
+	stored, err := GetSupply(sto)
+	assert.Nil(t, err)
+	assert.Equal(t, supply, stored)
+	assert.Equal(t, uint64(17), stored.Circulating())
+}
+
+func TestStats(t *testing.T) {
+	archive := NewMemArchive()
+
+	var addr0, addr1 common.Address
+	addr0[0] = 1
+	addr1[0] = 2
+	// Sunday and Monday
+	sunday := time.Date(2020, 6, 7, 10, 0, 0, 0, time.UTC)
+	monday := time.Date(2020, 6, 8, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), IntervalStart(IntervalWeek, sunday))
+	assert.Equal(t, time.Date(2020, 6, 8, 0, 0, 0, 0, time.UTC), IntervalStart(IntervalWeek, monday))
+
+	batch := archive.NewBatch()
+	mint := common.NewTx(addr0, addr0, 100, 0)
+	mint.Type = common.TxTypeMint
+	require.Nil(t, StoreStats(batch, sunday, mint, 1, 100))
+	require.Nil(t, StoreStats(batch, sunday, common.NewTx(addr0, addr1, 10, 1), 1, 100))
+	require.Nil(t, StoreStats(batch, sunday, common.NewTx(addr0, addr1, 20, 2), 0, 100))
+	require.Nil(t, batch.Commit())
+	batch = archive.NewBatch()
+	require.Nil(t, StoreStats(batch, monday, common.NewTx(addr1, addr0, 5, 0), 0, 100))
+	require.Nil(t, batch.Commit())
+
+	stats, err := GetStats(archive, IntervalDay, sunday.AddDate(0, 0, -1), monday.AddDate(0, 0, 1))
+	require.Nil(t, err)
+	require.Equal(t, 4, len(stats))
+	assert.Equal(t, Stats{Start: time.Date(2020, 6, 6, 0, 0, 0, 0, time.UTC)}, stats[0])
+	assert.Equal(t, Stats{Start: IntervalStart(IntervalDay, sunday), Transfers: 2, Volume: 30,
+		Senders: 1, Receivers: 1, NewAccounts: 2, Minted: 100, Supply: 100, Velocity: 0.3}, stats[1])
+	assert.Equal(t, uint64(5), stats[2].Volume)
+	// days without txs keep the supply
+	assert.Equal(t, uint64(100), stats[3].Supply)
+	assert.Equal(t, uint64(0), stats[3].Transfers)
+
+	stats, err = GetStats(archive, IntervalWeek, sunday, monday)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(stats))
+	assert.Equal(t, uint64(2), stats[0].Transfers)
+	assert.Equal(t, uint64(1), stats[1].Transfers)
+	assert.Equal(t, uint64(1), stats[1].Senders)
+
+	require.Nil(t, DeleteArchivedTxs(archive))
+	stats, err = GetStats(archive, IntervalWeek, sunday, monday)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(0), stats[0].Transfers)
+}
+
+func TestBranch(t *testing.T) {
+	sto, err := NewMemStorage()
+	require.Nil(t, err)
+	sto.Set([]byte("a"), []byte("1"))
+	sto.Set([]byte("c"), []byte("3"))
+	sto.Set([]byte("e"), []byte("5"))
+
+	branch := NewBranch(sto)
+	branch.Set([]byte("b"), []byte("2"))
+	branch.Set([]byte("c"), []byte("33"))
+	branch.Delete([]byte("e"))
+	branch.Set([]byte("f"), []byte("6"))
+	assert.Equal(t, []byte("33"), branch.Get([]byte("c")))
+	assert.Nil(t, branch.Get([]byte("e")))
+	assert.Equal(t, []byte("3"), sto.Get([]byte("c")))
+
+	var kvs []string
+	branch.Iterate(func(k, v []byte) bool {
+		kvs = append(kvs, string(k)+"="+string(v))
+		return false
+	})
+	assert.Equal(t, []string{"a=1", "b=2", "c=33", "f=6"}, kvs)
+	kvs = nil
+	branch.Iterate(func(k, v []byte) bool {
+		kvs = append(kvs, string(k)+"="+string(v))
+		return len(kvs) == 2
+	})
+	assert.Equal(t, []string{"a=1", "b=2"}, kvs)
+
+	branch.Rollback()
+	assert.Equal(t, []byte("3"), branch.Get([]byte("c")))
+	branch.Set([]byte("c"), []byte("33"))
+	branch.Delete([]byte("a"))
+	_, err = branch.Commit()
+	require.Nil(t, err)
+	assert.Equal(t, []byte("33"), sto.Get([]byte("c")))
+	assert.Nil(t, sto.Get([]byte("a")))
+}
+
+func TestSnapshot(t *testing.T) {
+	sto, err := NewMemStorage()
+	require.Nil(t, err)
+	committed, err := sto.Committed()
+	require.Nil(t, err)
+	assert.Nil(t, committed.Get([]byte("a")))
+
+	sto.Set([]byte("a"), []byte("1"))
+	_, err = sto.Commit()
+	require.Nil(t, err)
+	committed, err = sto.Committed()
+	require.Nil(t, err)
+	assert.Equal(t, sto.State(), committed.State())
+
+	// the snapshot does not see the later changes
+	sto.Set([]byte("a"), []byte("11"))
+	sto.Set([]byte("b"), []byte("2"))
+	var kvs []string
+	committed.Iterate(func(k, v []byte) bool {
+		kvs = append(kvs, string(k)+"="+string(v))
+		return false
+	})
+	assert.Equal(t, []string{"a=1"}, kvs)
+	_, err = sto.Commit()
+	require.Nil(t, err)
+	assert.Equal(t, []byte("1"), committed.Get([]byte("a")))
+	assert.Nil(t, committed.Get([]byte("b")))
+
+	assert.Panics(t, func() { committed.Set([]byte("a"), []byte("3")) })
+	_, err = committed.Commit()
+	assert.Equal(t, ErrReadOnly, err)
+	branch := NewBranch(committed)
+	branch.Set([]byte("a"), []byte("3"))
+	assert.Equal(t, []byte("3"), branch.Get([]byte("a")))
+}
+
+func TestBlockHeaders(t *testing.T) {
+	sto, err := NewMemStorage()
+	require.Nil(t, err)
+	archive := NewMemArchive()
+
+	var addr0, addr1 common.Address
+	addr0[0] = 1
+	addr1[0] = 2
+	// a mint of 10 to addr0 at height 1, and a transfer of 4 to addr1 at
+	// height 2, broadcasted as uppercase hex
+	txs := []*common.Tx{
+		{Type: common.TxTypeMint, From: addr0, To: addr0, Amount: 10},
+		common.NewTx(addr0, addr1, 4, 1),
+	}
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, tx := range txs {
+		height := uint64(i + 1)
+		txRaw := []byte(tx.Hex())
+		if i == 1 {
+			txRaw = []byte(strings.ToUpper(tx.Hex()))
+		}
+		batch := archive.NewBatch()
+		require.Nil(t, StoreTx(batch, height, 0, txRaw, tx))
+		require.Nil(t, StoreBlockHeader(batch, &BlockHeader{
+			Height:     height,
+			Hash:       []byte{byte(height)},
+			ParentHash: []byte{byte(height - 1)},
+			Time:       start.Add(time.Duration(i) * time.Second),
+		}))
+		require.Nil(t, SetArchiveHeight(batch, height))
+		require.Nil(t, batch.Commit())
+	}
+	SetAccount(sto, addr0, &Account{Balance: 6, Nonce: 2})
+	SetAccount(sto, addr1, &Account{Balance: 4})
+	SetStateHeight(sto, 2)
+	_, err = sto.Commit()
+	require.Nil(t, err)
+	// a block archived and not yet committed to the state
+	next := common.NewTx(addr0, addr1, 1, 2)
+	batch := archive.NewBatch()
+	require.Nil(t, StoreTx(batch, 3, 0, []byte(next.Hex()), next))
+	require.Nil(t, SetArchiveHeight(batch, 3))
+	require.Nil(t, batch.Commit())
+	SetAccount(sto, addr0, &Account{Balance: 5, Nonce: 3})
+	SetAccount(sto, addr1, &Account{Balance: 5})
+
+	header, err := GetBlockHeader(archive, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, &BlockHeader{Height: 2, Hash: []byte{2}, ParentHash: []byte{1}, Time: start.Add(time.Second)}, header)
+	header, err = GetBlockHeader(archive, 3)
+	assert.Nil(t, err)
+	assert.Nil(t, header)
+	height, err := GetBlockHeight(archive, []byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), height)
+
+	for _, c := range []struct {
+		addr     common.Address
+		height   uint64
+		expected uint64
+	}{
+		{addr0, 0, 0},
+		{addr0, 1, 10},
+		{addr0, 2, 6},
+		{addr1, 1, 0},
+		{addr1, 2, 4},
+	} {
+		balance, err := GetBalanceAt(sto, archive, c.addr, c.height)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, balance)
+	}
+	_, err = GetBalanceAt(sto, archive, addr0, 3)
+	assert.NotNil(t, err)
+	_, err = GetBalanceAt(sto, NewMemArchive(), addr0, 1)
+	assert.NotNil(t, err)
+
+	require.Nil(t, DeleteArchivedTxs(archive))
+	header, err = GetBlockHeader(archive, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, header)
+}
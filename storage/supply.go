@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"kvartalochain/common"
+)
+
+// SupplyVersion is the schema version of the encoded Supply
+const SupplyVersion = byte(1)
+
+const supplyLenV1 = 1 + 8 + 8 + 8
+
+var KEYSUPPLY = []byte("supply")
+
+// Supply are the totals of the currency
+type Supply struct {
+	Minted uint64 `json:"minted"`
+	// Burned is kept for the txs that destroy coins, currently there is no
+	// tx type that burns them
+	Burned   uint64 `json:"burned"`
+	Accounts uint64 `json:"accounts"`
+}
+
+// Circulating returns the amount of coins held by the accounts
+func (s *Supply) Circulating() uint64 {
+	return s.Minted - s.Burned
+}
+
+/*
+	Supply encoding:
+		[ version 1 byte | minted 8 bytes | burned 8 bytes | accounts 8 bytes ]
+*/
+
+func (s *Supply) Bytes() []byte {
+	var b [supplyLenV1]byte
+	b[0] = SupplyVersion
+	binary.LittleEndian.PutUint64(b[1:9], s.Minted)
+	binary.LittleEndian.PutUint64(b[9:17], s.Burned)
+	binary.LittleEndian.PutUint64(b[17:25], s.Accounts)
+	return b[:]
+}
+
+func SupplyFromBytes(b []byte) (*Supply, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("error on supply bytes format")
+	}
+	switch b[0] {
+	case 1:
+		if len(b) != supplyLenV1 {
+			return nil, fmt.Errorf("error on supply bytes format")
+		}
+		return &Supply{
+			Minted:   binary.LittleEndian.Uint64(b[1:9]),
+			Burned:   binary.LittleEndian.Uint64(b[9:17]),
+			Accounts: binary.LittleEndian.Uint64(b[17:25]),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown supply version: %d", b[0])
+	}
+}
+
+// GetSupply returns the Supply stored in the state, or an empty Supply
+func GetSupply(db StateDB) (*Supply, error) {
+	b := db.Get(KEYSUPPLY)
+	if len(b) == 0 {
+		return &Supply{}, nil
+	}
+	return SupplyFromBytes(b)
+}
+
+func SetSupply(db StateDB, s *Supply) {
+	db.Set(KEYSUPPLY, s.Bytes())
+}
+
+// Holder is an address and its balance
+type Holder struct {
+	Addr    common.Address `json:"addr"`
+	Balance uint64         `json:"balance"`
+}
+
+// TopHolders returns the n accounts with the highest balance, in descending
+// order of balance. Accounts with the same balance are in address order.
+func TopHolders(db StateDB, n int) ([]Holder, error) {
+	var holders []Holder
+	err := IterateAccounts(db, func(addr common.Address, acc *Account) bool {
+		if acc.Balance > 0 {
+			holders = append(holders, Holder{Addr: addr, Balance: acc.Balance})
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(holders, func(i, j int) bool {
+		return holders[i].Balance > holders[j].Balance
+	})
+	if len(holders) > n {
+		holders = holders[:n]
+	}
+	return holders, nil
+}
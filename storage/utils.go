@@ -1,77 +1,355 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 	"kvartalochain/common"
 
-	"github.com/dgraph-io/badger"
+	"github.com/tendermint/tendermint/crypto/tmhash"
 )
 
-var PREFIXNONCE = []byte("nonce")
+var PREFIXACCOUNT = []byte("account")
 var PREFIXHISTORY = []byte("history")
+var PREFIXTXHASH = []byte("txhash")
+var PREFIXBLOCK = []byte("block")
+var KEYARCHIVEHEIGHT = []byte("archiveheight")
+var KEYSTATEHEIGHT = []byte("stateheight")
+var KEYAPPHASH = []byte("apphash")
 
-func GetBalance(db *Storage, addr common.Address) uint64 {
-	balanceBytes := db.Get(addr[:])
-	if len(balanceBytes) == 0 {
-		return uint64(0)
+// ArchivedTx is a tx from the archive, with its position in the chain
+type ArchivedTx struct {
+	Hash   []byte     `json:"hash"`
+	Height uint64     `json:"height"`
+	Index  uint32     `json:"index"`
+	Tx     *common.Tx `json:"tx"`
+}
+
+func accountKey(addr common.Address) []byte {
+	return append(append([]byte{}, PREFIXACCOUNT...), addr[:]...)
+}
+
+// GetAccount returns the Account of the address. If the address is not in
+// the db, returns an empty Account.
+func GetAccount(db StateDB, addr common.Address) (*Account, error) {
+	accBytes := db.Get(accountKey(addr))
+	if len(accBytes) == 0 {
+		return &Account{}, nil
+	}
+	return AccountFromBytes(accBytes)
+}
+
+func SetAccount(db StateDB, addr common.Address, acc *Account) {
+	db.Set(accountKey(addr), acc.Bytes())
+}
+
+// AccountExists returns true if the address has an Account in the db
+func AccountExists(db StateDB, addr common.Address) bool {
+	return len(db.Get(accountKey(addr))) != 0
+}
+
+// IterateAccounts calls fn for each Account in the db, in address order, until
+// fn returns true
+func IterateAccounts(db StateDB, fn func(addr common.Address, acc *Account) bool) error {
+	var err error
+	db.Iterate(func(k, v []byte) bool {
+		if len(k) != len(PREFIXACCOUNT)+32 || !bytes.HasPrefix(k, PREFIXACCOUNT) {
+			return false
+		}
+		var acc *Account
+		acc, err = AccountFromBytes(v)
+		if err != nil {
+			return true
+		}
+		var addr common.Address
+		copy(addr[:], k[len(PREFIXACCOUNT):])
+		return fn(addr, acc)
+	})
+	return err
+}
+
+func GetBalance(db StateDB, addr common.Address) (uint64, error) {
+	acc, err := GetAccount(db, addr)
+	if err != nil {
+		return 0, err
+	}
+	return acc.Balance, nil
+}
+
+func GetNonce(db StateDB, addr common.Address) (uint64, error) {
+	acc, err := GetAccount(db, addr)
+	if err != nil {
+		return 0, err
+	}
+	return acc.Nonce, nil
+}
+
+// TxHash returns the hash of the raw tx, as it is used by Tendermint
+func TxHash(txRaw []byte) []byte {
+	return tmhash.Sum(txRaw)
+}
+
+/*
+	tx archive format in DB:
+		history of each address:
+			key: PREFIXHISTORY | address | count
+			value: height | index | TxHash(txRaw) | tx.Bytes()
+		and the number of txs of the address:
+			key: PREFIXHISTORY | address
+			value: count
+		tx hash index:
+			key: PREFIXTXHASH | TxHash(txRaw)
+			value: height | index | tx.Bytes()
+		block index:
+			key: PREFIXBLOCK | height | index (big endian, to iterate them in order)
+			value: TxHash(txRaw)
+*/
+
+// StoreTx adds to the batch the history, tx hash and block index entries of
+// a tx. txRaw is the tx as received from Tendermint, and index is its position
+// in the block.
+func StoreTx(batch ArchiveBatch, height uint64, index uint32, txRaw []byte, tx *common.Tx) error {
+	hash := TxHash(txRaw)
+	if err := storeHistory(batch, &ArchivedTx{Hash: hash, Height: height, Index: index, Tx: tx}); err != nil {
+		return err
 	}
-	return binary.LittleEndian.Uint64(balanceBytes)
+	var pos [12]byte
+	binary.LittleEndian.PutUint64(pos[:8], height)
+	binary.LittleEndian.PutUint32(pos[8:], index)
+	if err := batch.Set(append(append([]byte{}, PREFIXTXHASH...), hash...), append(pos[:], tx.Bytes()...)); err != nil {
+		return err
+	}
+	return batch.Set(blockKey(height, index), hash)
 }
 
-func GetNonce(db *Storage, addr common.Address) uint64 {
-	nonceKey := append(PREFIXNONCE, addr[:]...)
-	nonceBytes := db.Get(nonceKey)
-	if len(nonceBytes) == 0 {
-		return uint64(0)
+// storeHistory adds the tx to the history of its sender and its receiver
+func storeHistory(batch ArchiveBatch, archived *ArchivedTx) error {
+	entry := historyEntryBytes(archived)
+	if err := addToHistory(batch, archived.Tx.From, entry); err != nil {
+		return err
+	}
+	if archived.Tx.To != archived.Tx.From {
+		return addToHistory(batch, archived.Tx.To, entry)
 	}
-	return binary.LittleEndian.Uint64(nonceBytes)
+	return nil
+}
+
+// historyEntryBytes returns the value of a history entry. The hash is the
+// one of the tx as broadcasted, which can not be computed from the tx.
+func historyEntryBytes(archived *ArchivedTx) []byte {
+	var pos [12]byte
+	binary.LittleEndian.PutUint64(pos[:8], archived.Height)
+	binary.LittleEndian.PutUint32(pos[8:], archived.Index)
+	v := append(pos[:], archived.Hash...)
+	return append(v, archived.Tx.Bytes()...)
 }
 
-func GetTxCount(db *badger.DB, addr common.Address) (uint64, error) {
+func historyEntryFromBytes(v []byte) (*ArchivedTx, error) {
+	if len(v) < 12+tmhash.Size {
+		return nil, fmt.Errorf("error on archived history entry format")
+	}
+	tx, err := common.TxFromBytes(v[12+tmhash.Size:])
+	if err != nil {
+		return nil, err
+	}
+	return &ArchivedTx{
+		Hash:   v[12 : 12+tmhash.Size],
+		Height: binary.LittleEndian.Uint64(v[:8]),
+		Index:  binary.LittleEndian.Uint32(v[8:12]),
+		Tx:     tx,
+	}, nil
+}
+
+func addToHistory(batch ArchiveBatch, addr common.Address, entry []byte) error {
+	countKey := append(append([]byte{}, PREFIXHISTORY...), addr[:]...)
 	var count uint64
-	err := db.View(func(txn *badger.Txn) error {
-		countKey := append(PREFIXHISTORY, addr[:]...)
-		item, err := txn.Get(countKey)
-		if err != nil && err != badger.ErrKeyNotFound {
-			return err
+	countBytes, err := batch.Get(countKey)
+	if err != nil {
+		return err
+	}
+	if len(countBytes) != 0 {
+		count = binary.LittleEndian.Uint64(countBytes)
+	}
+
+	var countBytesNew [8]byte
+	binary.LittleEndian.PutUint64(countBytesNew[:], count)
+	if err := batch.Set(append(countKey, countBytesNew[:]...), entry); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(countBytesNew[:], count+1)
+	return batch.Set(countKey, countBytesNew[:])
+}
+
+func blockKey(height uint64, index uint32) []byte {
+	var k [12]byte
+	binary.BigEndian.PutUint64(k[:8], height)
+	binary.BigEndian.PutUint32(k[8:], index)
+	return append(append([]byte{}, PREFIXBLOCK...), k[:]...)
+}
+
+// SetArchiveHeight adds to the batch the height of the last archived block
+func SetArchiveHeight(batch ArchiveBatch, height uint64) error {
+	var h [8]byte
+	binary.LittleEndian.PutUint64(h[:], height)
+	return batch.Set(KEYARCHIVEHEIGHT, h[:])
+}
+
+// GetArchiveHeight returns the height of the last archived block
+func GetArchiveHeight(db ArchiveDB) (uint64, error) {
+	h, err := db.Get(KEYARCHIVEHEIGHT)
+	if err != nil || len(h) == 0 {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(h), nil
+}
+
+// SetStateHeight sets the height of the last block committed to the state
+func SetStateHeight(db StateDB, height uint64) {
+	var h [8]byte
+	binary.LittleEndian.PutUint64(h[:], height)
+	db.Set(KEYSTATEHEIGHT, h[:])
+}
+
+// GetStateHeight returns the height of the last block committed to the
+// state, 0 if no block has been committed
+func GetStateHeight(db StateDB) uint64 {
+	h := db.Get(KEYSTATEHEIGHT)
+	if len(h) != 8 {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(h)
+}
+
+// EnableAppHash marks the state of a chain whose app hash is the state hash
+func EnableAppHash(db StateDB) {
+	db.Set(KEYAPPHASH, []byte{1})
+}
+
+// AppHashEnabled returns whether the app hash of the chain is the state hash.
+// The chains started before it have empty app hashes.
+func AppHashEnabled(db StateDB) bool {
+	return len(db.Get(KEYAPPHASH)) == 1
+}
+
+// GetTxByHash returns the archived tx with the given hash, or nil if it is not
+// in the archive
+func GetTxByHash(db ArchiveDB, hash []byte) (*ArchivedTx, error) {
+	v, err := db.Get(append(append([]byte{}, PREFIXTXHASH...), hash...))
+	if err != nil || len(v) == 0 {
+		return nil, err
+	}
+	if len(v) < 12 {
+		return nil, fmt.Errorf("error on archived tx format")
+	}
+	tx, err := common.TxFromBytes(v[12:])
+	if err != nil {
+		return nil, err
+	}
+	return &ArchivedTx{
+		Hash:   hash,
+		Height: binary.LittleEndian.Uint64(v[:8]),
+		Index:  binary.LittleEndian.Uint32(v[8:12]),
+		Tx:     tx,
+	}, nil
+}
+
+// GetBlockTxs returns the archived txs of the block at the given height
+func GetBlockTxs(db ArchiveDB, height uint64) ([]ArchivedTx, error) {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], height)
+	prefix := append(append([]byte{}, PREFIXBLOCK...), h[:]...)
+
+	var hashes [][]byte
+	err := db.Iterate(prefix, func(k, v []byte) bool {
+		hashes = append(hashes, v)
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	var txs []ArchivedTx
+	for _, hash := range hashes {
+		tx, err := GetTxByHash(db, hash)
+		if err != nil {
+			return nil, err
 		}
-		if err == nil {
-			return item.Value(func(val []byte) error {
-				count = binary.LittleEndian.Uint64(val)
-				return err
+		if tx == nil {
+			return nil, fmt.Errorf("tx %X of block %d not in archive", hash, height)
+		}
+		txs = append(txs, *tx)
+	}
+	return txs, nil
+}
+
+// DeleteArchivedTxs removes from the archive the history, tx hash and block
+// index entries, the stats, the block headers, and the archive height
+func DeleteArchivedTxs(db ArchiveDB) error {
+	// keys are deleted in small batches, as badger limits the transaction
+	// size
+	const batchSize = 1000
+	for _, prefix := range [][]byte{PREFIXHISTORY, PREFIXTXHASH, PREFIXBLOCK,
+		PREFIXSTATSBUCKET, PREFIXSTATSACTIVE, PREFIXHEADER, PREFIXHEIGHTBYHASH,
+		KEYARCHIVEHEIGHT} {
+		for {
+			var keys [][]byte
+			err := db.Iterate(prefix, func(k, v []byte) bool {
+				keys = append(keys, k)
+				return len(keys) >= batchSize
 			})
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				break
+			}
+			batch := db.NewBatch()
+			for _, k := range keys {
+				if err := batch.Delete(k); err != nil {
+					batch.Discard()
+					return err
+				}
+			}
+			if err := batch.Commit(); err != nil {
+				return err
+			}
 		}
-		return nil
-	})
-	return count, err
+	}
+	return nil
+}
+
+func GetTxCount(db ArchiveDB, addr common.Address) (uint64, error) {
+	countKey := append(append([]byte{}, PREFIXHISTORY...), addr[:]...)
+	val, err := db.Get(countKey)
+	if err != nil || len(val) == 0 {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(val), nil
 }
 
-func GetTx(db *badger.DB, addr common.Address, n uint64) (*common.Tx, error) {
+func GetTx(db ArchiveDB, addr common.Address, n uint64) (*common.Tx, error) {
+	archived, err := GetArchivedTx(db, addr, n)
+	if err != nil {
+		return nil, err
+	}
+	return archived.Tx, nil
+}
+
+// GetArchivedTx returns the tx n of the history of addr, with its hash and
+// its position in the chain
+func GetArchivedTx(db ArchiveDB, addr common.Address, n uint64) (*ArchivedTx, error) {
 	var nBytes [8]byte
 	binary.LittleEndian.PutUint64(nBytes[:], n)
 
-	var txBytes []byte
-	err := db.View(func(txn *badger.Txn) error {
-		key := append(PREFIXHISTORY, addr[:]...)
-		key = append(key, nBytes[:]...)
-		item, err := txn.Get(key)
-		if err != nil && err != badger.ErrKeyNotFound {
-			return err
-		}
-		if err == nil {
-			return item.Value(func(val []byte) error {
-				txBytes = make([]byte, len(val))
-				copy(txBytes, val)
-				return err
-			})
-		}
-		return nil
-	})
-	tx, err := common.TxFromBytes(txBytes)
-	return tx, err
+	key := append(append([]byte{}, PREFIXHISTORY...), addr[:]...)
+	key = append(key, nBytes[:]...)
+	v, err := db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return historyEntryFromBytes(v)
 }
 
-func GetAddressHistory(db *badger.DB, addr common.Address, n uint64) ([]common.Tx, error) {
+func GetAddressHistory(db ArchiveDB, addr common.Address, n uint64) ([]common.Tx, error) {
 	var txs []common.Tx
 	for i := 0; i < int(n); i++ {
 		tx, err := GetTx(db, addr, uint64(i))
@@ -82,3 +360,55 @@ func GetAddressHistory(db *badger.DB, addr common.Address, n uint64) ([]common.T
 	}
 	return txs, nil
 }
+
+// GetBalanceAt returns the balance of addr after the block at height. It
+// undoes from the balance of the committed state the archived txs of the
+// blocks after height, so the archive must have the blocks up to the height
+// of the state.
+func GetBalanceAt(db StateDB, archiveDb ArchiveDB, addr common.Address, height uint64) (uint64, error) {
+	committed, err := db.Committed()
+	if err != nil {
+		return 0, err
+	}
+	stateHeight := GetStateHeight(committed)
+	if height > stateHeight {
+		return 0, fmt.Errorf("the state is at height %d, before %d", stateHeight, height)
+	}
+	archiveHeight, err := GetArchiveHeight(archiveDb)
+	if err != nil {
+		return 0, err
+	}
+	if height < stateHeight && archiveHeight < stateHeight {
+		return 0, fmt.Errorf("the archive is at height %d, behind the state at %d", archiveHeight, stateHeight)
+	}
+	balance, err := GetBalance(committed, addr)
+	if err != nil {
+		return 0, err
+	}
+	count, err := GetTxCount(archiveDb, addr)
+	if err != nil {
+		return 0, err
+	}
+	for n := count; n > 0; n-- {
+		archived, err := GetArchivedTx(archiveDb, addr, n-1)
+		if err != nil {
+			return 0, err
+		}
+		// the blocks archived before the state is committed are not in
+		// the balance
+		if archived.Height > stateHeight {
+			continue
+		}
+		if archived.Height <= height {
+			break
+		}
+		tx := archived.Tx
+		if tx.To == addr {
+			balance -= tx.Amount
+		}
+		if tx.From == addr && tx.Type != common.TxTypeMint {
+			balance += tx.Amount
+		}
+	}
+	return balance, nil
+}
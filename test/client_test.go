@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"flag"
@@ -48,9 +47,7 @@ func init() {
 
 func setDbBalance(db *storage.Storage, addr common.Address, balance uint64) {
 	fmt.Println("ADD BALANCE")
-	var balanceBytes [8]byte
-	binary.LittleEndian.PutUint64(balanceBytes[:], balance)
-	db.Set(addr[:], balanceBytes[:])
+	storage.SetAccount(db, addr, &storage.Account{Balance: balance})
 	// txn := db.NewTransaction(true)
 	// if err := txn.Set(addr[:], balanceBytes[:]); err == badger.ErrTxnTooBig {
 	//         _ = txn.Commit()
@@ -60,7 +57,10 @@ func setDbBalance(db *storage.Storage, addr common.Address, balance uint64) {
 
 func printDbBalance(db *storage.Storage, addr common.Address) {
 	// view if balance is updated
-	balance := storage.GetBalance(db, addr)
+	balance, err := storage.GetBalance(db, addr)
+	if err != nil {
+		panic(err)
+	}
 	// var balance uint64
 	// err := db.View(func(txn *badger.Txn) error {
 	//         item, err := txn.Get(addr[:])
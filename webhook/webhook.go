@@ -0,0 +1,551 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"kvartalochain/chain"
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+/*
+	webhooks format in the archive DB:
+		registered webhooks:
+			key: PREFIXWEBHOOK | webhook id
+			value: Webhook json
+		deliveries waiting to be sent:
+			key: PREFIXDELIVERYQUEUE | delivery id
+			value: Delivery json
+		sent and failed deliveries:
+			key: PREFIXDELIVERYLOG | webhook id | created at | delivery id
+			value: Delivery json
+		height of the last block whose deliveries were queued:
+			key: KEYNOTIFIEDHEIGHT
+			value: height (8 bytes little endian)
+*/
+
+var PREFIXWEBHOOK = []byte("webhook")
+var PREFIXDELIVERYQUEUE = []byte("deliveryqueue")
+var PREFIXDELIVERYLOG = []byte("deliverylog")
+var KEYNOTIFIEDHEIGHT = []byte("notifiedheight")
+
+// SIGNATUREHEADER is the http header with the hex HMAC-SHA256 of the request
+// body, using the webhook secret as key
+const SIGNATUREHEADER = "X-Kvartalo-Signature"
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Webhook is called for each payment received by Addr
+type Webhook struct {
+	ID     string         `json:"id"`
+	Addr   common.Address `json:"addr"`
+	URL    string         `json:"url"`
+	Secret string         `json:"secret,omitempty"`
+}
+
+// Payload is the body of the webhook requests
+type Payload struct {
+	DeliveryID string         `json:"deliveryId"`
+	WebhookID  string         `json:"webhookId"`
+	Addr       common.Address `json:"addr"`
+	Height     uint64         `json:"height"`
+	Hash       string         `json:"hash"`
+	Tx         *common.Tx     `json:"tx"`
+}
+
+// Delivery is a webhook request and its state
+type Delivery struct {
+	ID             string          `json:"id"`
+	WebhookID      string          `json:"webhookId"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	NextAttempt    time.Time       `json:"nextAttempt"`
+	LastStatusCode int             `json:"lastStatusCode,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+}
+
+// Notifier stores the webhooks and sends their deliveries
+type Notifier struct {
+	db     storage.ArchiveDB
+	client *http.Client
+	mutex  sync.Mutex // serializes the writes of the deliveries
+	wake   chan struct{}
+	stop   chan struct{}
+
+	// MaxAttempts is the number of attempts before a delivery fails
+	MaxAttempts int
+	// Backoff is the wait after the first failed attempt, doubled after
+	// each failed attempt up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is the interval to look for deliveries to retry
+	PollInterval time.Duration
+	// AllowPrivate allows the webhook urls of loopback, private and link
+	// local addresses, which are rejected by default so that the api can
+	// not be used to reach the network of the node
+	AllowPrivate bool
+	Logger       log.Logger
+}
+
+func NewNotifier(db storage.ArchiveDB) *Notifier {
+	n := &Notifier{
+		db:           db,
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		MaxAttempts:  10,
+		Backoff:      5 * time.Second,
+		MaxBackoff:   time.Hour,
+		PollInterval: time.Second,
+		Logger:       log.NewNopLogger(),
+	}
+	// the address is checked again when connecting, as the host can
+	// resolve to another address than when the webhook was registered
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return n.checkIP(net.ParseIP(host))
+		},
+	}
+	n.client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		// the redirects could lead to a private address, they are not
+		// followed
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return n
+}
+
+// privateNets are the ranges of private networks, besides the loopback and
+// link local ones
+var privateNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16",
+		"100.64.0.0/10", "fc00::/7"} {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}()
+
+// checkIP returns an error if the webhooks can not be sent to ip
+func (n *Notifier) checkIP(ip net.IP) error {
+	if n.AllowPrivate {
+		return nil
+	}
+	if ip == nil {
+		return fmt.Errorf("invalid webhook address")
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("webhook address %s is not public", ip)
+	}
+	for _, ipNet := range privateNets {
+		if ipNet.Contains(ip) {
+			return fmt.Errorf("webhook address %s is not public", ip)
+		}
+	}
+	return nil
+}
+
+// checkHost returns an error if any address of host is not public
+func (n *Notifier) checkHost(host string) error {
+	if n.AllowPrivate {
+		return nil
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = net.LookupIP(host); err != nil {
+			return fmt.Errorf("can not resolve the webhook host: %w", err)
+		}
+	}
+	for _, ip := range ips {
+		if err := n.checkIP(ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+func concat(bs ...[]byte) []byte {
+	var r []byte
+	for _, b := range bs {
+		r = append(r, b...)
+	}
+	return r
+}
+
+func putJSON(batch storage.ArchiveBatch, k []byte, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return batch.Set(k, b)
+}
+
+// Sign returns the hex HMAC-SHA256 of body with the secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Register stores a new Webhook for the payments to addr. The url must be
+// http or https, and resolve to public addresses unless AllowPrivate is set.
+func (n *Notifier) Register(addr common.Address, callbackURL, secret string) (*Webhook, error) {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("webhook url must be http or https")
+	}
+	if err := n.checkHost(u.Hostname()); err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("webhook secret can not be empty")
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	hook := &Webhook{ID: id, Addr: addr, URL: callbackURL, Secret: secret}
+	batch := n.db.NewBatch()
+	if err := putJSON(batch, concat(PREFIXWEBHOOK, []byte(id)), hook); err != nil {
+		batch.Discard()
+		return nil, err
+	}
+	return hook, batch.Commit()
+}
+
+// Get returns the Webhook with the given id, or nil if it does not exist
+func (n *Notifier) Get(id string) (*Webhook, error) {
+	b, err := n.db.Get(concat(PREFIXWEBHOOK, []byte(id)))
+	if err != nil || b == nil {
+		return nil, err
+	}
+	var hook Webhook
+	err = json.Unmarshal(b, &hook)
+	return &hook, err
+}
+
+// Remove deletes the Webhook with the given id. Its pending deliveries are
+// dropped when they are due.
+func (n *Notifier) Remove(id string) error {
+	batch := n.db.NewBatch()
+	if err := batch.Delete(concat(PREFIXWEBHOOK, []byte(id))); err != nil {
+		batch.Discard()
+		return err
+	}
+	return batch.Commit()
+}
+
+// List returns the Webhooks of addr
+func (n *Notifier) List(addr common.Address) ([]Webhook, error) {
+	hooks, err := n.all()
+	if err != nil {
+		return nil, err
+	}
+	var r []Webhook
+	for _, hook := range hooks {
+		if hook.Addr == addr {
+			r = append(r, hook)
+		}
+	}
+	return r, nil
+}
+
+func (n *Notifier) all() ([]Webhook, error) {
+	var hooks []Webhook
+	var err error
+	iterErr := n.db.Iterate(PREFIXWEBHOOK, func(k, v []byte) bool {
+		var hook Webhook
+		if err = json.Unmarshal(v, &hook); err != nil {
+			return true
+		}
+		hooks = append(hooks, hook)
+		return false
+	})
+	if iterErr != nil {
+		return nil, iterErr
+	}
+	return hooks, err
+}
+
+// Deliveries returns the sent and failed deliveries of a webhook, oldest
+// first, and then its pending deliveries
+func (n *Notifier) Deliveries(webhookID string) ([]Delivery, error) {
+	var deliveries []Delivery
+	var err error
+	collect := func(k, v []byte) bool {
+		var d Delivery
+		if err = json.Unmarshal(v, &d); err != nil {
+			return true
+		}
+		if d.WebhookID == webhookID {
+			deliveries = append(deliveries, d)
+		}
+		return false
+	}
+	if iterErr := n.db.Iterate(concat(PREFIXDELIVERYLOG, []byte(webhookID)), collect); iterErr != nil {
+		return nil, iterErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if iterErr := n.db.Iterate(PREFIXDELIVERYQUEUE, collect); iterErr != nil {
+		return nil, iterErr
+	}
+	return deliveries, err
+}
+
+// OnCommit is a chain.CommitListener that queues a Delivery for each webhook
+// of the receivers of the successful txs. The blocks replayed by Tendermint
+// when the state is behind are not queued again.
+func (n *Notifier) OnCommit(height uint64, txs []chain.CommittedTx) {
+	if len(txs) == 0 {
+		return
+	}
+	if err := n.enqueue(height, txs); err != nil {
+		n.Logger.Error("failed to queue the deliveries of the block", "height", height, "err", err)
+		return
+	}
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// notifiedHeight returns the height of the last block whose deliveries were
+// queued, 0 if none
+func (n *Notifier) notifiedHeight() (uint64, error) {
+	h, err := n.db.Get(KEYNOTIFIEDHEIGHT)
+	if err != nil || len(h) == 0 {
+		return 0, err
+	}
+	if len(h) != 8 {
+		return 0, fmt.Errorf("invalid notified height")
+	}
+	return binary.LittleEndian.Uint64(h), nil
+}
+
+func (n *Notifier) enqueue(height uint64, txs []chain.CommittedTx) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	notified, err := n.notifiedHeight()
+	if err != nil {
+		return err
+	}
+	if height <= notified {
+		n.Logger.Debug("skipping the deliveries of a replayed block", "height", height)
+		return nil
+	}
+	hooks, err := n.all()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	batch := n.db.NewBatch()
+	// the height is stored with the deliveries, even without webhooks, as
+	// the webhooks registered later must not get the replayed blocks
+	var h [8]byte
+	binary.LittleEndian.PutUint64(h[:], height)
+	if err := batch.Set(KEYNOTIFIEDHEIGHT, h[:]); err != nil {
+		batch.Discard()
+		return err
+	}
+	for _, committed := range txs {
+		if committed.Failed() {
+			continue
+		}
+		for _, hook := range hooks {
+			if hook.Addr != committed.Tx.To {
+				continue
+			}
+			id, err := newID()
+			if err != nil {
+				batch.Discard()
+				return err
+			}
+			payload, err := json.Marshal(Payload{
+				DeliveryID: id,
+				WebhookID:  hook.ID,
+				Addr:       hook.Addr,
+				Height:     height,
+				Hash:       fmt.Sprintf("%X", committed.Hash),
+				Tx:         committed.Tx,
+			})
+			if err != nil {
+				batch.Discard()
+				return err
+			}
+			d := Delivery{
+				ID:          id,
+				WebhookID:   hook.ID,
+				Payload:     payload,
+				Status:      StatusPending,
+				CreatedAt:   now,
+				NextAttempt: now,
+			}
+			if err := putJSON(batch, concat(PREFIXDELIVERYQUEUE, []byte(id)), d); err != nil {
+				batch.Discard()
+				return err
+			}
+		}
+	}
+	return batch.Commit()
+}
+
+// Start runs the routine that sends the queued deliveries, until Stop is
+// called
+func (n *Notifier) Start() {
+	go func() {
+		ticker := time.NewTicker(n.PollInterval)
+		defer ticker.Stop()
+		for {
+			n.sendDue(time.Now())
+			select {
+			case <-n.stop:
+				return
+			case <-n.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (n *Notifier) Stop() {
+	close(n.stop)
+}
+
+func (n *Notifier) sendDue(now time.Time) {
+	var due []Delivery
+	err := n.db.Iterate(PREFIXDELIVERYQUEUE, func(k, v []byte) bool {
+		var d Delivery
+		if err := json.Unmarshal(v, &d); err != nil {
+			return false
+		}
+		if !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+		return false
+	})
+	if err != nil {
+		n.Logger.Error("failed to read the queued deliveries", "err", err)
+		return
+	}
+	for _, d := range due {
+		hook, err := n.Get(d.WebhookID)
+		if err != nil {
+			continue
+		}
+		if hook == nil {
+			// the webhook has been removed
+			d.Status = StatusFailed
+			d.LastError = "webhook removed"
+		} else {
+			n.send(hook, &d)
+		}
+		n.update(&d)
+	}
+}
+
+// send does an attempt of the delivery, updating its state
+func (n *Notifier) send(hook *Webhook, d *Delivery) {
+	d.Attempts++
+	d.LastStatusCode = 0
+	d.LastError = ""
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(d.Payload))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(SIGNATUREHEADER, Sign(hook.Secret, d.Payload))
+		var resp *http.Response
+		resp, err = n.client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			d.LastStatusCode = resp.StatusCode
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				d.Status = StatusDelivered
+				return
+			}
+			err = fmt.Errorf("http status %d", resp.StatusCode)
+		}
+	}
+	d.LastError = err.Error()
+	n.Logger.Info("webhook delivery failed", "delivery", d.ID, "webhook", hook.ID,
+		"attempts", d.Attempts, "err", err)
+	if d.Attempts >= n.MaxAttempts {
+		d.Status = StatusFailed
+		return
+	}
+	backoff := n.Backoff << uint(d.Attempts-1)
+	if backoff > n.MaxBackoff || backoff <= 0 {
+		backoff = n.MaxBackoff
+	}
+	d.NextAttempt = time.Now().Add(backoff)
+}
+
+// update stores the delivery, moving it to the log once it is not pending
+func (n *Notifier) update(d *Delivery) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	queueKey := concat(PREFIXDELIVERYQUEUE, []byte(d.ID))
+	batch := n.db.NewBatch()
+	var err error
+	if d.Status == StatusPending {
+		err = putJSON(batch, queueKey, d)
+	} else {
+		var createdAt [8]byte
+		binary.BigEndian.PutUint64(createdAt[:], uint64(d.CreatedAt.UnixNano()))
+		logKey := concat(PREFIXDELIVERYLOG, []byte(d.WebhookID), createdAt[:], []byte(d.ID))
+		if err = putJSON(batch, logKey, d); err == nil {
+			err = batch.Delete(queueKey)
+		}
+	}
+	if err != nil {
+		batch.Discard()
+		n.Logger.Error("failed to store the delivery", "delivery", d.ID, "err", err)
+		return
+	}
+	batch.Commit()
+}
@@ -0,0 +1,132 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kvartalochain/chain"
+	"kvartalochain/common"
+	"kvartalochain/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifier(t *testing.T) {
+	var received []Payload
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		assert.Equal(t, Sign("secret", body), r.Header.Get(SIGNATUREHEADER))
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var p Payload
+		require.Nil(t, json.Unmarshal(body, &p))
+		received = append(received, p)
+	}))
+	defer server.Close()
+
+	n := NewNotifier(storage.NewMemArchive())
+	n.Backoff = time.Millisecond
+	n.MaxAttempts = 3
+
+	var addr0, addr1 common.Address
+	addr0[0] = 1
+	addr1[0] = 2
+	_, err := n.Register(addr1, "ftp://shop", "secret")
+	assert.NotNil(t, err)
+	// the test server is in the loopback address
+	for _, u := range []string{server.URL, "http://10.0.0.1/paid", "http://169.254.169.254/", "http://[::1]:80/"} {
+		_, err = n.Register(addr1, u, "secret")
+		assert.NotNil(t, err, u)
+	}
+	n.AllowPrivate = true
+	hook, err := n.Register(addr1, server.URL, "secret")
+	require.Nil(t, err)
+	hooks, err := n.List(addr1)
+	require.Nil(t, err)
+	assert.Equal(t, []Webhook{*hook}, hooks)
+
+	// payments to addr0 and failed txs are not sent to the webhook of addr1
+	tx0 := common.NewTx(addr1, addr0, 5, 0)
+	tx1 := common.NewTx(addr0, addr1, 10, 0)
+	n.OnCommit(3, []chain.CommittedTx{
+		{Height: 3, Index: 0, Hash: []byte{1}, Tx: tx0},
+		{Height: 3, Index: 1, Hash: []byte{2}, Tx: tx1},
+		{Height: 3, Index: 2, Hash: []byte{4}, Tx: tx1, Code: chain.ERRNONCE, Log: "invalid nonce"},
+	})
+	deliveries, err := n.Deliveries(hook.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(deliveries))
+	assert.Equal(t, StatusPending, deliveries[0].Status)
+
+	// first attempt fails, and is retried after the backoff
+	n.sendDue(time.Now())
+	deliveries, err = n.Deliveries(hook.ID)
+	require.Nil(t, err)
+	assert.Equal(t, StatusPending, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].LastStatusCode)
+	assert.Equal(t, 0, len(received))
+
+	fail = false
+	n.sendDue(time.Now().Add(time.Second))
+	deliveries, err = n.Deliveries(hook.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(deliveries))
+	assert.Equal(t, StatusDelivered, deliveries[0].Status)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+	require.Equal(t, 1, len(received))
+	assert.Equal(t, uint64(3), received[0].Height)
+	assert.Equal(t, "02", received[0].Hash)
+	assert.Equal(t, tx1, received[0].Tx)
+
+	// deliveries of removed webhooks fail
+	n.OnCommit(4, []chain.CommittedTx{{Height: 4, Index: 0, Hash: []byte{3}, Tx: tx1}})
+	// the blocks replayed when the state is behind are not queued again
+	n.OnCommit(3, []chain.CommittedTx{{Height: 3, Index: 1, Hash: []byte{2}, Tx: tx1}})
+	n.OnCommit(4, []chain.CommittedTx{{Height: 4, Index: 0, Hash: []byte{3}, Tx: tx1}})
+	deliveries, err = n.Deliveries(hook.ID)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(deliveries))
+	require.Nil(t, n.Remove(hook.ID))
+	n.sendDue(time.Now())
+	deliveries, err = n.Deliveries(hook.ID)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(deliveries))
+	assert.Equal(t, StatusFailed, deliveries[1].Status)
+	assert.Equal(t, 1, len(received))
+}
+
+func TestNotifierMaxAttempts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	n := NewNotifier(storage.NewMemArchive())
+	n.Backoff = time.Millisecond
+	n.MaxAttempts = 2
+	n.AllowPrivate = true
+
+	var addr common.Address
+	addr[0] = 1
+	hook, err := n.Register(addr, server.URL, "secret")
+	require.Nil(t, err)
+	n.OnCommit(1, []chain.CommittedTx{{Height: 1, Hash: []byte{1}, Tx: common.NewTx(addr, addr, 1, 0)}})
+
+	n.sendDue(time.Now())
+	n.sendDue(time.Now().Add(time.Second))
+	deliveries, err := n.Deliveries(hook.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(deliveries))
+	assert.Equal(t, StatusFailed, deliveries[0].Status)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+}